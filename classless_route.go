@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"errors"
+	"net"
+)
+
+var ErrInvalidRoute = errors.New("dhcpv4: invalid classless route")
+
+// ClasslessRoute is a route in the classless static route option (RFC3442).
+type ClasslessRoute struct {
+	Destination net.IPNet
+	Gateway     net.IP
+}
+
+// GetClasslessRoutes gets the routes in the classless static route option. It
+// returns false if the option is not present or if any of its destination
+// descriptors is malformed.
+func (om OptionMap) GetClasslessRoutes() ([]ClasslessRoute, bool) {
+	v, ok := om.GetOption(OptionClasslessStaticRouteOption)
+	if !ok {
+		return nil, false
+	}
+
+	var rs []ClasslessRoute
+	for len(v) > 0 {
+		width := int(v[0])
+		if width > 32 {
+			return nil, false
+		}
+
+		// The destination descriptor only holds the significant octets of the
+		// subnet number, followed by the router address.
+		n := (width + 7) / 8
+		if len(v) < 1+n+4 {
+			return nil, false
+		}
+
+		mask := net.CIDRMask(width, 32)
+		dst := make(net.IP, 4)
+		copy(dst, v[1:1+n])
+		gw := v[1+n : 1+n+4]
+
+		rs = append(rs, ClasslessRoute{
+			Destination: net.IPNet{
+				IP:   dst.Mask(mask),
+				Mask: mask,
+			},
+			Gateway: net.IPv4(gw[0], gw[1], gw[2], gw[3]),
+		})
+
+		v = v[1+n+4:]
+	}
+
+	return rs, true
+}
+
+// classlessMaskWidth returns the number of leading ones in mask, which must be
+// a contiguous IPv4 mask in either its 4 or 16 byte form.
+func classlessMaskWidth(mask net.IPMask) (int, bool) {
+	width, bits := mask.Size()
+
+	switch bits {
+	case 32:
+		return width, true
+	case 128:
+		// The 16 byte form of an IPv4 mask has all bits of the prefix set
+		if width >= 96 {
+			return width - 96, true
+		}
+	}
+
+	return 0, false
+}
+
+// SetClasslessRoutes sets the routes in the classless static route option.
+// Route lists longer than 255 bytes are split into multiple instances of the
+// option when the packet is serialized (RFC3396). It returns ErrInvalidRoute
+// and leaves the option untouched if the destination of a route is not an
+// IPv4 network with a contiguous mask, or its gateway is not an IPv4 address.
+func (om OptionMap) SetClasslessRoutes(rs []ClasslessRoute) error {
+	var b []byte
+	for _, r := range rs {
+		width, ok := classlessMaskWidth(r.Destination.Mask)
+		if !ok {
+			return ErrInvalidRoute
+		}
+
+		n := (width + 7) / 8
+
+		dst := r.Destination.IP.To4()
+		gw := r.Gateway.To4()
+		if dst == nil || gw == nil {
+			return ErrInvalidRoute
+		}
+
+		dst = dst.Mask(net.CIDRMask(width, 32))
+
+		b = append(b, byte(width))
+		b = append(b, dst[:n]...)
+		b = append(b, gw...)
+	}
+
+	om.SetOption(OptionClasslessStaticRouteOption, b)
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testClasslessRoute(cidr string, gw net.IP) ClasslessRoute {
+	_, dst, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	return ClasslessRoute{Destination: *dst, Gateway: gw}
+}
+
+func TestOptionMapClasslessRoutes(t *testing.T) {
+	om := make(OptionMap)
+
+	_, ok := om.GetClasslessRoutes()
+	assert.False(t, ok)
+
+	rs := []ClasslessRoute{
+		testClasslessRoute("0.0.0.0/0", net.IPv4(10, 0, 0, 1)),
+		testClasslessRoute("10.0.0.0/8", net.IPv4(10, 0, 0, 2)),
+		testClasslessRoute("10.17.0.0/16", net.IPv4(10, 0, 0, 3)),
+		testClasslessRoute("10.27.129.0/24", net.IPv4(10, 0, 0, 4)),
+		testClasslessRoute("10.229.0.128/25", net.IPv4(10, 0, 0, 5)),
+		testClasslessRoute("10.198.122.47/32", net.IPv4(10, 0, 0, 6)),
+	}
+
+	assert.NoError(t, om.SetClasslessRoutes(rs))
+
+	// Examples from RFC3442, section 3
+	v, _ := om.GetOption(OptionClasslessStaticRouteOption)
+	assert.Equal(t, []byte{
+		0, 10, 0, 0, 1,
+		8, 10, 10, 0, 0, 2,
+		16, 10, 17, 10, 0, 0, 3,
+		24, 10, 27, 129, 10, 0, 0, 4,
+		25, 10, 229, 0, 128, 10, 0, 0, 5,
+		32, 10, 198, 122, 47, 10, 0, 0, 6,
+	}, v)
+
+	rsX, ok := om.GetClasslessRoutes()
+	if assert.True(t, ok) && assert.Len(t, rsX, len(rs)) {
+		for i := range rs {
+			assert.Equal(t, rs[i].Destination.String(), rsX[i].Destination.String())
+			assert.True(t, rs[i].Gateway.Equal(rsX[i].Gateway))
+		}
+	}
+}
+
+func TestOptionMapClasslessRoutesMalformed(t *testing.T) {
+	tests := [][]byte{
+		// Width out of range
+		{33, 10, 0, 0, 0, 10, 0, 0, 1},
+		// Truncated subnet number
+		{24, 10, 0},
+		// Truncated router
+		{8, 10, 10, 0, 0},
+	}
+
+	for _, v := range tests {
+		om := make(OptionMap)
+		om.SetOption(OptionClasslessStaticRouteOption, v)
+		_, ok := om.GetClasslessRoutes()
+		assert.False(t, ok, "%v", v)
+	}
+}
+
+func TestOptionMapSetClasslessRoutesInvalid(t *testing.T) {
+	gw := net.IPv4(10, 0, 0, 1)
+
+	tests := []ClasslessRoute{
+		// IPv6 destination
+		testClasslessRoute("2001:db8::/32", gw),
+		// Missing mask
+		{Destination: net.IPNet{IP: net.IPv4(10, 0, 0, 0)}, Gateway: gw},
+		// Non-contiguous mask
+		{Destination: net.IPNet{IP: net.IPv4(10, 0, 0, 0), Mask: net.IPv4Mask(255, 0, 255, 0)}, Gateway: gw},
+		// Missing destination
+		{Destination: net.IPNet{Mask: net.CIDRMask(8, 32)}, Gateway: gw},
+		// IPv6 gateway
+		testClasslessRoute("10.0.0.0/8", net.ParseIP("2001:db8::1")),
+		// Missing gateway
+		testClasslessRoute("10.0.0.0/8", nil),
+	}
+
+	for _, r := range tests {
+		om := make(OptionMap)
+		assert.Equal(t, ErrInvalidRoute, om.SetClasslessRoutes([]ClasslessRoute{r}), "%v", r)
+
+		_, ok := om.GetOption(OptionClasslessStaticRouteOption)
+		assert.False(t, ok)
+	}
+
+	// A mask in its 16 byte form is accepted
+	om := make(OptionMap)
+	r := ClasslessRoute{
+		Destination: net.IPNet{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(96+8, 128)},
+		Gateway:     gw,
+	}
+
+	if assert.NoError(t, om.SetClasslessRoutes([]ClasslessRoute{r})) {
+		v, _ := om.GetOption(OptionClasslessStaticRouteOption)
+		assert.Equal(t, []byte{8, 10, 10, 0, 0, 1}, v)
+	}
+}
+
+func TestPacketClasslessRoutesLong(t *testing.T) {
+	var rs []ClasslessRoute
+	for i := 0; i < 100; i++ {
+		dst := net.IPNet{
+			IP:   net.IPv4(10, byte(i), 0, 0),
+			Mask: net.CIDRMask(16, 32),
+		}
+		rs = append(rs, ClasslessRoute{Destination: dst, Gateway: net.IPv4(192, 168, 0, 1)})
+	}
+
+	p := NewPacket(BootReply)
+	assert.NoError(t, p.SetClasslessRoutes(rs))
+
+	// 100 routes of 7 bytes each need to be split over multiple options
+	b, err := PacketToBytes(p, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	q, err := PacketFromBytes(b)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rsX, ok := q.GetClasslessRoutes()
+	if assert.True(t, ok) && assert.Len(t, rsX, len(rs)) {
+		assert.Equal(t, "10.99.0.0/16", rsX[99].Destination.String())
+	}
+}
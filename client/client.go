@@ -0,0 +1,636 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/vmware/godhcpv4"
+)
+
+var (
+	ErrTimeout = errors.New("client: timeout")
+	ErrNoLease = errors.New("client: no lease")
+)
+
+// State is the state of a client, as defined in RFC2131 section 4.4.
+type State int
+
+const (
+	StateInit State = iota
+	StateSelecting
+	StateRequesting
+	StateBound
+	StateRenewing
+	StateRebinding
+)
+
+var stateNames = []string{
+	"INIT",
+	"SELECTING",
+	"REQUESTING",
+	"BOUND",
+	"RENEWING",
+	"REBINDING",
+}
+
+func (s State) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+
+	return "UNKNOWN"
+}
+
+// Lease holds the address and configuration parameters a client is bound to.
+type Lease struct {
+	IP       net.IP
+	ServerID net.IP
+	Options  dhcpv4.OptionMap
+
+	LeaseTime time.Duration
+	T1        time.Duration
+	T2        time.Duration
+
+	// Acquired is the time the request that resulted in this lease was sent.
+	Acquired time.Time
+}
+
+// Renew returns the time the client moves to the RENEWING state.
+func (l Lease) Renew() time.Time {
+	return l.Acquired.Add(l.T1)
+}
+
+// Rebind returns the time the client moves to the REBINDING state.
+func (l Lease) Rebind() time.Time {
+	return l.Acquired.Add(l.T2)
+}
+
+// Expiry returns the time the lease expires.
+func (l Lease) Expiry() time.Time {
+	return l.Acquired.Add(l.LeaseTime)
+}
+
+func newLease(ack dhcpv4.Packet, acquired time.Time) Lease {
+	l := Lease{
+		IP:       net.IP(append([]byte(nil), ack.GetYIAddr()...)),
+		Options:  ack.OptionMap,
+		Acquired: acquired,
+	}
+
+	l.ServerID, _ = ack.GetIP(dhcpv4.OptionDHCPServerID)
+	l.LeaseTime, _ = ack.GetDuration(dhcpv4.OptionAddressTime)
+
+	// Defaults from RFC2131 section 4.4.5
+	var ok bool
+	if l.T1, ok = ack.GetDuration(dhcpv4.OptionRenewalTime); !ok {
+		l.T1 = l.LeaseTime / 2
+	}
+
+	if l.T2, ok = ack.GetDuration(dhcpv4.OptionRebindingTime); !ok {
+		l.T2 = l.LeaseTime * 7 / 8
+	}
+
+	return l
+}
+
+// Config holds the configuration of a client.
+type Config struct {
+	// HardwareAddr is the hardware address of the interface being configured.
+	HardwareAddr net.HardwareAddr
+
+	// ClientID is the client identifier. If nil, servers identify the client
+	// by its hardware address.
+	ClientID []byte
+
+	// Hostname is sent to servers in the host name option, if not empty.
+	Hostname string
+
+	// ParameterList lists the options the client asks servers for.
+	ParameterList []dhcpv4.Option
+
+	// Broadcast makes the client ask for broadcast replies, for clients that
+	// cannot receive unicast packets before their address is configured.
+	Broadcast bool
+
+	// InterfaceIndex is the index of the interface to send packets on.
+	InterfaceIndex int
+
+	// Retransmit is the initial retransmission timeout in the SELECTING and
+	// REQUESTING states. It doubles on every retransmission up to 64 seconds.
+	// Defaults to 4 seconds.
+	Retransmit time.Duration
+
+	// MaxRetransmissions is the number of times a DHCPDISCOVER or DHCPREQUEST
+	// is sent before starting over. Defaults to 5.
+	MaxRetransmissions int
+
+	// CheckAddress is called with the address that was acknowledged before
+	// the client moves to the BOUND state. If it returns false, for example
+	// because an ARP probe found the address in use, the client sends a
+	// DHCPDECLINE and starts over.
+	CheckAddress func(ip net.IP) bool
+
+	// OnBound is called every time the client acquires or extends a lease.
+	OnBound func(l Lease)
+
+	// OnExpire is called when the client loses its lease, either because the
+	// lease expired or because a server sent a DHCPNAK.
+	OnExpire func(l Lease)
+}
+
+// Client implements the client side of RFC2131 over a dhcpv4.PacketConn.
+type Client struct {
+	pc  dhcpv4.PacketConn
+	cfg Config
+
+	now   func() time.Time
+	after func(d time.Duration) <-chan time.Time
+
+	xid [4]byte
+
+	mu    sync.Mutex
+	state State
+	lease *Lease
+
+	recvOnce sync.Once
+	recv     chan dhcpv4.Packet
+	readErr  error
+}
+
+// New returns a client that sends and receives packets on the specified
+// connection. The connection should be bound to port 68. Closing the
+// connection stops the client.
+func New(pc dhcpv4.PacketConn, cfg Config) *Client {
+	if cfg.Retransmit == 0 {
+		cfg.Retransmit = 4 * time.Second
+	}
+
+	if cfg.MaxRetransmissions == 0 {
+		cfg.MaxRetransmissions = 5
+	}
+
+	c := Client{
+		pc:    pc,
+		cfg:   cfg,
+		now:   time.Now,
+		after: time.After,
+	}
+
+	return &c
+}
+
+// State returns the current state of the client.
+func (c *Client) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+func (c *Client) setState(s State) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state = s
+}
+
+// Lease returns the lease the client is currently bound to.
+func (c *Client) Lease() (Lease, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lease == nil {
+		return Lease{}, false
+	}
+
+	return *c.lease, true
+}
+
+func (c *Client) setLease(l *Lease) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lease = l
+}
+
+// newXID picks a new transaction ID.
+func (c *Client) newXID() {
+	binary.BigEndian.PutUint32(c.xid[:], rand.Uint32())
+}
+
+// receive returns the channel that packets read off the connection are
+// delivered on. The goroutine reading from the connection is started on first
+// use and stops when the connection returns an error, for example because it
+// was closed. The channel is closed when it stops.
+func (c *Client) receive() <-chan dhcpv4.Packet {
+	c.recvOnce.Do(func() {
+		c.recv = make(chan dhcpv4.Packet, 16)
+
+		go func() {
+			defer close(c.recv)
+
+			buf := make([]byte, 65536)
+			for {
+				n, _, _, err := c.pc.ReadFrom(buf)
+				if err != nil {
+					c.readErr = err
+					return
+				}
+
+				p, err := dhcpv4.PacketFromBytes(buf[:n])
+				if err != nil || dhcpv4.OpCode(p.Op()[0]) != dhcpv4.BootReply {
+					continue
+				}
+
+				// Drop the packet if nobody is listening
+				select {
+				case c.recv <- p:
+				default:
+				}
+			}
+		}()
+	})
+
+	return c.recv
+}
+
+// accept returns a function that accepts replies to the current transaction
+// with one of the specified message types.
+func (c *Client) accept(ts ...dhcpv4.MessageType) func(p dhcpv4.Packet) bool {
+	xid := c.xid
+
+	return func(p dhcpv4.Packet) bool {
+		if !bytes.Equal(p.GetXID(), xid[:]) {
+			return false
+		}
+
+		if !bytes.Equal(p.GetCHAddr(), c.cfg.HardwareAddr) {
+			return false
+		}
+
+		for _, t := range ts {
+			if p.GetMessageType() == t {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// message is implemented by the messages a client sends.
+type message interface {
+	Validate() error
+	ToBytes() ([]byte, error)
+	Secs() []byte
+}
+
+func (c *Client) send(m message, dst net.IP) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	b, err := m.ToBytes()
+	if err != nil {
+		return err
+	}
+
+	addr := net.UDPAddr{
+		IP:   dst,
+		Port: 67,
+	}
+
+	_, err = c.pc.WriteTo(b, &addr, c.cfg.InterfaceIndex)
+	return err
+}
+
+// backoff returns the retransmission schedule from RFC2131 section 4.1: the
+// initial timeout, doubling on every retransmission up to 64 seconds and
+// randomized by one second either way. Intervals shorter than two seconds are
+// randomized by half the interval instead, so they stay positive.
+func (c *Client) backoff() func(n int) (time.Duration, bool) {
+	return func(n int) (time.Duration, bool) {
+		if n >= c.cfg.MaxRetransmissions {
+			return 0, false
+		}
+
+		d := c.cfg.Retransmit << uint(n)
+		if d > 64*time.Second || d <= 0 {
+			d = 64 * time.Second
+		}
+
+		jitter := time.Second
+		if jitter > d/2 {
+			jitter = d / 2
+		}
+
+		if jitter > 0 {
+			d += time.Duration(rand.Int63n(int64(2*jitter))) - jitter
+		}
+
+		return d, true
+	}
+}
+
+// until returns the retransmission schedule from RFC2131 section 4.4.5 for
+// the RENEWING and REBINDING states: one half of the time remaining until t,
+// down to a minimum of 60 seconds.
+func (c *Client) until(t time.Time) func(n int) (time.Duration, bool) {
+	return func(n int) (time.Duration, bool) {
+		r := t.Sub(c.now())
+		if r <= 0 {
+			return 0, false
+		}
+
+		d := r / 2
+		if d < 60*time.Second {
+			d = 60 * time.Second
+		}
+
+		if d > r {
+			d = r
+		}
+
+		return d, true
+	}
+}
+
+// exchange sends m to dst and waits for a reply that is accepted by accept.
+// It retransmits m after the intervals returned by next, and returns
+// ErrTimeout when next returns false.
+func (c *Client) exchange(ctx context.Context, recv <-chan dhcpv4.Packet, m message, dst net.IP, next func(n int) (time.Duration, bool), accept func(p dhcpv4.Packet) bool) (dhcpv4.Packet, error) {
+	start := c.now()
+
+	for n := 0; ; n++ {
+		d, ok := next(n)
+		if !ok {
+			return dhcpv4.Packet{}, ErrTimeout
+		}
+
+		// Seconds elapsed since the client began the exchange
+		binary.BigEndian.PutUint16(m.Secs(), uint16(c.now().Sub(start)/time.Second))
+
+		if err := c.send(m, dst); err != nil {
+			return dhcpv4.Packet{}, err
+		}
+
+		timeout := c.after(d)
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return dhcpv4.Packet{}, ctx.Err()
+			case q, ok := <-recv:
+				if !ok {
+					return dhcpv4.Packet{}, c.readErr
+				}
+
+				if accept(q) {
+					return q, nil
+				}
+			case <-timeout:
+				break wait
+			}
+		}
+	}
+}
+
+// sleep waits until t, discarding any packets that are received.
+func (c *Client) sleep(ctx context.Context, recv <-chan dhcpv4.Packet, t time.Time) error {
+	timeout := c.after(t.Sub(c.now()))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-recv:
+			if !ok {
+				return c.readErr
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+// Run runs the client state machine until the context is done or the
+// connection returns an error. The lease held by the client, if any, can be
+// relinquished with Release after Run has returned. Run can be called again
+// to resume the state machine.
+func (c *Client) Run(ctx context.Context) error {
+	var err error
+
+	recv := c.receive()
+
+	for err == nil {
+		switch c.State() {
+		case StateInit, StateSelecting, StateRequesting:
+			err = c.runInit(ctx, recv)
+		case StateBound:
+			err = c.runBound(ctx, recv)
+		case StateRenewing:
+			err = c.runRenewing(ctx, recv)
+		case StateRebinding:
+			err = c.runRebinding(ctx, recv)
+		}
+	}
+
+	return err
+}
+
+// runInit acquires a new lease, moving through the INIT, SELECTING and
+// REQUESTING states.
+func (c *Client) runInit(ctx context.Context, recv <-chan dhcpv4.Packet) error {
+	var prev net.IP
+	if l, ok := c.Lease(); ok {
+		prev = l.IP
+	}
+
+	c.setState(StateInit)
+	c.setLease(nil)
+	c.newXID()
+
+	c.setState(StateSelecting)
+	offer, err := c.exchange(ctx, recv, c.discover(prev), net.IPv4bcast, c.backoff(), c.accept(dhcpv4.MessageTypeDHCPOffer))
+	if err == ErrTimeout {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	c.setState(StateRequesting)
+	acquired := c.now()
+	ack, err := c.exchange(ctx, recv, c.requestSelecting(offer), net.IPv4bcast, c.backoff(), c.accept(dhcpv4.MessageTypeDHCPAck, dhcpv4.MessageTypeDHCPNak))
+	if err == ErrTimeout {
+		c.setState(StateInit)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if ack.GetMessageType() == dhcpv4.MessageTypeDHCPNak {
+		c.setState(StateInit)
+		return nil
+	}
+
+	l := newLease(ack, acquired)
+
+	// From RFC2131 section 3.1: if the client detects that the address is
+	// already in use, it MUST send a DHCPDECLINE message to the server and
+	// restarts the configuration process. The client SHOULD wait a minimum of
+	// ten seconds before restarting the configuration process.
+	if c.cfg.CheckAddress != nil && !c.cfg.CheckAddress(l.IP) {
+		if err = c.send(c.decline(l), net.IPv4bcast); err != nil {
+			return err
+		}
+
+		c.setState(StateInit)
+		return c.sleep(ctx, recv, c.now().Add(10*time.Second))
+	}
+
+	c.bind(l)
+	return nil
+}
+
+func (c *Client) bind(l Lease) {
+	c.setLease(&l)
+	c.setState(StateBound)
+
+	if c.cfg.OnBound != nil {
+		c.cfg.OnBound(l)
+	}
+}
+
+func (c *Client) expire() {
+	l, ok := c.Lease()
+	c.setState(StateInit)
+
+	if ok && c.cfg.OnExpire != nil {
+		c.cfg.OnExpire(l)
+	}
+}
+
+// runBound waits until it is time to renew the lease.
+func (c *Client) runBound(ctx context.Context, recv <-chan dhcpv4.Packet) error {
+	l, _ := c.Lease()
+
+	if err := c.sleep(ctx, recv, l.Renew()); err != nil {
+		return err
+	}
+
+	c.setState(StateRenewing)
+	return nil
+}
+
+// extend tries to extend the lease by sending the DHCPREQUEST message to dst
+// until the deadline passes. It returns whether it got a reply.
+func (c *Client) extend(ctx context.Context, recv <-chan dhcpv4.Packet, req dhcpv4.DHCPRequest, dst net.IP, deadline time.Time) (bool, error) {
+	acquired := c.now()
+	ack, err := c.exchange(ctx, recv, req, dst, c.until(deadline), c.accept(dhcpv4.MessageTypeDHCPAck, dhcpv4.MessageTypeDHCPNak))
+	if err == ErrTimeout {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if ack.GetMessageType() == dhcpv4.MessageTypeDHCPNak {
+		c.expire()
+		return true, nil
+	}
+
+	c.bind(newLease(ack, acquired))
+	return true, nil
+}
+
+// runRenewing tries to extend the lease with the server that granted it,
+// until it is time to rebind.
+func (c *Client) runRenewing(ctx context.Context, recv <-chan dhcpv4.Packet) error {
+	l, _ := c.Lease()
+
+	c.newXID()
+
+	ok, err := c.extend(ctx, recv, c.requestRenewing(l), l.ServerID, l.Rebind())
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		c.setState(StateRebinding)
+	}
+
+	return nil
+}
+
+// runRebinding tries to extend the lease with any server, until the lease
+// expires.
+func (c *Client) runRebinding(ctx context.Context, recv <-chan dhcpv4.Packet) error {
+	l, _ := c.Lease()
+
+	c.newXID()
+
+	ok, err := c.extend(ctx, recv, c.requestRebinding(l), net.IPv4bcast, l.Expiry())
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		c.expire()
+	}
+
+	return nil
+}
+
+// Release relinquishes the lease the client holds by sending a DHCPRELEASE
+// to the server that granted it. It must not be called while Run is running.
+func (c *Client) Release() error {
+	l, ok := c.Lease()
+	if !ok {
+		return ErrNoLease
+	}
+
+	c.newXID()
+	if err := c.send(c.release(l), l.ServerID); err != nil {
+		return err
+	}
+
+	c.setLease(nil)
+	c.setState(StateInit)
+	return nil
+}
+
+// Inform asks servers for local configuration parameters, for a client that
+// has the specified externally configured address. It returns the options in
+// the first DHCPACK it receives. It must not be called while Run is running.
+func (c *Client) Inform(ctx context.Context, ip net.IP) (dhcpv4.OptionMap, error) {
+	c.newXID()
+
+	recv := c.receive()
+	ack, err := c.exchange(ctx, recv, c.inform(ip), net.IPv4bcast, c.backoff(), c.accept(dhcpv4.MessageTypeDHCPAck))
+	if err != nil {
+		return nil, err
+	}
+
+	return ack.OptionMap, nil
+}
@@ -0,0 +1,510 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/godhcpv4"
+	"github.com/vmware/godhcpv4/pool"
+)
+
+// Virtual time passes this many times faster than real time in these tests.
+const testScale = 1000
+
+var testServerID = net.IPv4(192, 168, 1, 1)
+
+type testWrite struct {
+	p    dhcpv4.Packet
+	addr net.UDPAddr
+}
+
+// testConn is one end of an in-memory connection between a client and a
+// server.
+type testConn struct {
+	in   chan []byte
+	peer *testConn
+	addr net.UDPAddr
+
+	done chan struct{}
+	once sync.Once
+
+	mu     sync.Mutex
+	writes []testWrite
+}
+
+func newTestConnPair() (*testConn, *testConn) {
+	c := &testConn{
+		in:   make(chan []byte, 64),
+		addr: net.UDPAddr{IP: net.IPv4zero, Port: 68},
+		done: make(chan struct{}),
+	}
+
+	s := &testConn{
+		in:   make(chan []byte, 64),
+		addr: net.UDPAddr{IP: testServerID, Port: 67},
+		done: make(chan struct{}),
+	}
+
+	c.peer = s
+	s.peer = c
+	return c, s
+}
+
+func (c *testConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	select {
+	case p := <-c.in:
+		addr := c.peer.addr
+		return copy(b, p), &addr, 1, nil
+	case <-c.done:
+		return 0, nil, -1, io.EOF
+	}
+}
+
+func (c *testConn) WriteTo(b []byte, addr net.Addr, ifindex int) (int, error) {
+	p, err := dhcpv4.PacketFromBytes(b)
+	if err != nil {
+		panic(err)
+	}
+
+	c.mu.Lock()
+	c.writes = append(c.writes, testWrite{p, *addr.(*net.UDPAddr)})
+	c.mu.Unlock()
+
+	select {
+	case c.peer.in <- append([]byte(nil), b...):
+	default:
+	}
+
+	return len(b), nil
+}
+
+func (c *testConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *testConn) LocalAddr() net.Addr {
+	return &c.addr
+}
+
+// requests returns the packets of the specified type written to c.
+func (c *testConn) requests(t dhcpv4.MessageType) []testWrite {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var ws []testWrite
+	for _, w := range c.writes {
+		if w.p.GetMessageType() == t {
+			ws = append(ws, w)
+		}
+	}
+
+	return ws
+}
+
+func newTestPool(t *testing.T) *pool.Handler {
+	h, err := pool.NewHandler(testServerID, []pool.Subnet{
+		{
+			Network: net.IPNet{IP: net.IPv4(192, 168, 1, 0), Mask: net.CIDRMask(24, 32)},
+			Ranges: []pool.Range{
+				{Start: net.IPv4(192, 168, 1, 10), End: net.IPv4(192, 168, 1, 20)},
+			},
+			LeaseTime: time.Minute,
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+// testSetup connects a client to a server running the specified handler.
+type testSetup struct {
+	client *Client
+	cc, sc *testConn
+
+	bound   chan Lease
+	expired chan Lease
+}
+
+func newTestSetup(h dhcpv4.Handler, cfg Config) *testSetup {
+	cc, sc := newTestConnPair()
+
+	ts := &testSetup{
+		cc:      cc,
+		sc:      sc,
+		bound:   make(chan Lease, 16),
+		expired: make(chan Lease, 16),
+	}
+
+	if cfg.HardwareAddr == nil {
+		cfg.HardwareAddr = net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	}
+
+	cfg.OnBound = func(l Lease) { ts.bound <- l }
+	cfg.OnExpire = func(l Lease) { ts.expired <- l }
+
+	c := New(cc, cfg)
+
+	start := time.Now()
+	c.now = func() time.Time {
+		return start.Add(time.Since(start) * testScale)
+	}
+	c.after = func(d time.Duration) <-chan time.Time {
+		return time.After(d / testScale)
+	}
+
+	ts.client = c
+
+	go dhcpv4.Serve(sc, h)
+	return ts
+}
+
+func (ts *testSetup) Close() {
+	ts.cc.Close()
+	ts.sc.Close()
+}
+
+func waitLease(t *testing.T, ch chan Lease) (Lease, bool) {
+	select {
+	case l := <-ch:
+		return l, true
+	case <-time.After(5 * time.Second):
+		t.Error("timeout waiting for lease")
+		return Lease{}, false
+	}
+}
+
+func run(ts *testSetup) (context.CancelFunc, chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- ts.client.Run(ctx) }()
+	return cancel, errc
+}
+
+func TestClientAcquireAndRenew(t *testing.T) {
+	ts := newTestSetup(newTestPool(t), Config{})
+	defer ts.Close()
+
+	cancel, errc := run(ts)
+
+	l, ok := waitLease(t, ts.bound)
+	if !ok {
+		return
+	}
+
+	assert.Equal(t, net.IP{192, 168, 1, 10}, l.IP)
+	assert.Equal(t, testServerID, l.ServerID)
+	assert.Equal(t, time.Minute, l.LeaseTime)
+	assert.Equal(t, 30*time.Second, l.T1)
+
+	// Renewal
+	m, ok := waitLease(t, ts.bound)
+	if !ok {
+		return
+	}
+
+	assert.Equal(t, l.IP, m.IP)
+	assert.True(t, m.Acquired.After(l.Acquired))
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-errc)
+
+	// The renewal was unicast to the server
+	reqs := ts.cc.requests(dhcpv4.MessageTypeDHCPRequest)
+	if assert.True(t, len(reqs) >= 2) {
+		assert.Equal(t, net.IPv4bcast, reqs[0].addr.IP)
+		assert.Equal(t, testServerID, reqs[1].addr.IP)
+		assert.Equal(t, l.IP, reqs[1].p.GetCIAddr())
+		_, ok = reqs[1].p.GetOption(dhcpv4.OptionDHCPServerID)
+		assert.False(t, ok)
+	}
+}
+
+func TestClientRebindAndExpire(t *testing.T) {
+	p := newTestPool(t)
+	h := dhcpv4.HandlerFunc(func(req dhcpv4.Request) {
+		// Ignore renewals
+		if !req.GetCIAddr().Equal(net.IPv4zero) {
+			return
+		}
+
+		p.ServeDHCP(req)
+	})
+
+	ts := newTestSetup(h, Config{})
+	defer ts.Close()
+
+	cancel, errc := run(ts)
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	l, ok := waitLease(t, ts.bound)
+	if !ok {
+		return
+	}
+
+	m, ok := waitLease(t, ts.expired)
+	if !ok {
+		return
+	}
+
+	assert.Equal(t, l, m)
+
+	var unicast, broadcast int
+	for _, w := range ts.cc.requests(dhcpv4.MessageTypeDHCPRequest) {
+		if !w.p.GetCIAddr().Equal(l.IP) {
+			continue
+		}
+
+		switch {
+		case w.addr.IP.Equal(testServerID):
+			unicast++
+		case w.addr.IP.Equal(net.IPv4bcast):
+			broadcast++
+		}
+	}
+
+	assert.True(t, unicast > 0, "expected unicast requests in RENEWING state")
+	assert.True(t, broadcast > 0, "expected broadcast requests in REBINDING state")
+
+	// The client starts over and asks for its previous address
+	_, ok = waitLease(t, ts.bound)
+	assert.True(t, ok)
+
+	discovers := ts.cc.requests(dhcpv4.MessageTypeDHCPDiscover)
+	if assert.True(t, len(discovers) >= 2) {
+		ip, ok := discovers[len(discovers)-1].p.GetIP(dhcpv4.OptionAddressRequest)
+		assert.True(t, ok)
+		assert.Equal(t, l.IP.To16(), ip)
+	}
+}
+
+func TestClientNak(t *testing.T) {
+	p := newTestPool(t)
+	h := dhcpv4.HandlerFunc(func(req dhcpv4.Request) {
+		if req, ok := req.(dhcpv4.DHCPRequest); ok {
+			rep := dhcpv4.CreateDHCPNak(req)
+			rep.SetIP(dhcpv4.OptionDHCPServerID, testServerID)
+			req.WriteReply(rep)
+			return
+		}
+
+		p.ServeDHCP(req)
+	})
+
+	ts := newTestSetup(h, Config{})
+	defer ts.Close()
+
+	cancel, errc := run(ts)
+
+	// Give the client some time to go round a few times
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-errc
+
+	assert.Len(t, ts.bound, 0)
+	assert.True(t, len(ts.cc.requests(dhcpv4.MessageTypeDHCPDiscover)) > 1)
+}
+
+func TestClientRetransmit(t *testing.T) {
+	var n int
+
+	p := newTestPool(t)
+	h := dhcpv4.HandlerFunc(func(req dhcpv4.Request) {
+		// Drop the first two packets
+		if n++; n <= 2 {
+			return
+		}
+
+		p.ServeDHCP(req)
+	})
+
+	ts := newTestSetup(h, Config{})
+	defer ts.Close()
+
+	cancel, errc := run(ts)
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	if _, ok := waitLease(t, ts.bound); !ok {
+		return
+	}
+
+	discovers := ts.cc.requests(dhcpv4.MessageTypeDHCPDiscover)
+	if assert.Len(t, discovers, 3) {
+		// Secs reflects the time elapsed since the first attempt
+		assert.Equal(t, []byte{0, 0}, discovers[0].p.Secs())
+		assert.NotEqual(t, []byte{0, 0}, discovers[2].p.Secs())
+		assert.Equal(t, discovers[0].p.GetXID(), discovers[2].p.GetXID())
+	}
+}
+
+func TestClientBackoff(t *testing.T) {
+	testCases := []struct {
+		retransmit time.Duration
+		n          int
+		min, max   time.Duration
+	}{
+		// Randomized by one second either way
+		{4 * time.Second, 0, 3 * time.Second, 5 * time.Second},
+		{4 * time.Second, 1, 7 * time.Second, 9 * time.Second},
+		{4 * time.Second, 5, 63 * time.Second, 65 * time.Second},
+
+		// Short intervals are randomized by half the interval
+		{100 * time.Millisecond, 0, 50 * time.Millisecond, 150 * time.Millisecond},
+		{time.Nanosecond, 0, time.Nanosecond, time.Nanosecond},
+	}
+
+	for _, tc := range testCases {
+		c := Client{cfg: Config{Retransmit: tc.retransmit, MaxRetransmissions: 10}}
+		next := c.backoff()
+
+		for i := 0; i < 100; i++ {
+			d, ok := next(tc.n)
+			assert.True(t, ok)
+			assert.True(t, d >= tc.min && d <= tc.max, "%s not in [%s, %s]", d, tc.min, tc.max)
+		}
+	}
+
+	c := Client{cfg: Config{Retransmit: time.Second, MaxRetransmissions: 2}}
+	_, ok := c.backoff()(2)
+	assert.False(t, ok)
+}
+
+func TestClientDecline(t *testing.T) {
+	cfg := Config{
+		CheckAddress: func(ip net.IP) bool {
+			return !ip.Equal(net.IPv4(192, 168, 1, 10))
+		},
+	}
+
+	ts := newTestSetup(newTestPool(t), cfg)
+	defer ts.Close()
+
+	cancel, errc := run(ts)
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	if l, ok := waitLease(t, ts.bound); ok {
+		assert.Equal(t, net.IP{192, 168, 1, 11}, l.IP)
+	}
+
+	declines := ts.cc.requests(dhcpv4.MessageTypeDHCPDecline)
+	if assert.Len(t, declines, 1) {
+		ip, _ := declines[0].p.GetIP(dhcpv4.OptionAddressRequest)
+		assert.Equal(t, net.IPv4(192, 168, 1, 10), ip)
+	}
+}
+
+func TestClientRelease(t *testing.T) {
+	p := newTestPool(t)
+
+	released := make(chan struct{})
+	ts := newTestSetup(dhcpv4.HandlerFunc(func(req dhcpv4.Request) {
+		p.ServeDHCP(req)
+		if _, ok := req.(dhcpv4.DHCPRelease); ok {
+			close(released)
+		}
+	}), Config{})
+	defer ts.Close()
+
+	cancel, errc := run(ts)
+
+	l, ok := waitLease(t, ts.bound)
+	cancel()
+	<-errc
+
+	if !ok {
+		return
+	}
+
+	assert.Equal(t, ErrNoLease, New(ts.cc, Config{}).Release())
+	assert.NoError(t, ts.client.Release())
+	assert.Equal(t, StateInit, ts.client.State())
+
+	releases := ts.cc.requests(dhcpv4.MessageTypeDHCPRelease)
+	if assert.Len(t, releases, 1) {
+		assert.Equal(t, testServerID, releases[0].addr.IP)
+		assert.Equal(t, l.IP, releases[0].p.GetCIAddr())
+	}
+
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for release")
+	}
+
+	// Another client gets the released address
+	other := newTestSetup(p, Config{HardwareAddr: net.HardwareAddr{0, 0, 0, 0, 0, 1}})
+	defer other.Close()
+
+	cancel, errc = run(other)
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	if m, ok := waitLease(t, other.bound); ok {
+		assert.Equal(t, l.IP, m.IP)
+	}
+}
+
+func TestClientInform(t *testing.T) {
+	h, err := pool.NewHandler(testServerID, []pool.Subnet{
+		{
+			Network: net.IPNet{IP: net.IPv4(192, 168, 1, 0), Mask: net.CIDRMask(24, 32)},
+			Options: dhcpv4.OptionMap{
+				dhcpv4.OptionDomainName: []byte("example.com"),
+			},
+		},
+	})
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ts := newTestSetup(h, Config{})
+	defer ts.Close()
+
+	om, err := ts.client.Inform(context.Background(), net.IPv4(192, 168, 1, 50))
+	if assert.NoError(t, err) {
+		v, _ := om.GetString(dhcpv4.OptionDomainName)
+		assert.Equal(t, "example.com", v)
+	}
+}
+
+func TestClientReadError(t *testing.T) {
+	ts := newTestSetup(newTestPool(t), Config{})
+	ts.Close()
+
+	assert.Equal(t, io.EOF, ts.client.Run(context.Background()))
+}
@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"net"
+
+	"github.com/vmware/godhcpv4"
+)
+
+// prepare fills in the transaction ID and the options that identify the
+// client.
+func (c *Client) prepare(p dhcpv4.Packet) {
+	copy(p.XID(), c.xid[:])
+
+	if c.cfg.ClientID != nil {
+		p.SetOption(dhcpv4.OptionClientID, c.cfg.ClientID)
+	}
+}
+
+// setBroadcast sets the broadcast flag if the client asks for broadcast
+// replies.
+func (c *Client) setBroadcast(p dhcpv4.Packet) {
+	if c.cfg.Broadcast {
+		p.Flags()[0] |= 128
+	}
+}
+
+// setParameters sets the options a client uses to ask for configuration
+// parameters.
+func (c *Client) setParameters(p dhcpv4.Packet) {
+	if c.cfg.Hostname != "" {
+		p.SetString(dhcpv4.OptionHostname, c.cfg.Hostname)
+	}
+
+	if len(c.cfg.ParameterList) > 0 {
+		p.SetOptionList(dhcpv4.OptionParameterList, c.cfg.ParameterList)
+	}
+}
+
+// discover returns a DHCPDISCOVER packet. If the client had a lease before, it
+// asks for the same address again.
+func (c *Client) discover(prev net.IP) dhcpv4.DHCPDiscover {
+	req := dhcpv4.CreateDHCPDiscover(c.cfg.HardwareAddr)
+	c.prepare(req.Packet)
+	c.setBroadcast(req.Packet)
+	c.setParameters(req.Packet)
+
+	if prev != nil {
+		req.SetIP(dhcpv4.OptionAddressRequest, prev)
+	}
+
+	return req
+}
+
+// requestSelecting returns a DHCPREQUEST packet accepting the specified offer.
+func (c *Client) requestSelecting(offer dhcpv4.Packet) dhcpv4.DHCPRequest {
+	sid, _ := offer.GetIP(dhcpv4.OptionDHCPServerID)
+
+	req := dhcpv4.CreateDHCPRequestSelecting(c.cfg.HardwareAddr, offer.GetYIAddr(), sid)
+	c.prepare(req.Packet)
+	c.setBroadcast(req.Packet)
+	c.setParameters(req.Packet)
+	return req
+}
+
+// requestRenewing returns a DHCPREQUEST packet to extend the specified lease,
+// as sent in the RENEWING state.
+func (c *Client) requestRenewing(l Lease) dhcpv4.DHCPRequest {
+	req := dhcpv4.CreateDHCPRequestRenewing(c.cfg.HardwareAddr, l.IP)
+	c.prepare(req.Packet)
+	c.setParameters(req.Packet)
+	return req
+}
+
+// requestRebinding returns a DHCPREQUEST packet to extend the specified lease,
+// as sent in the REBINDING state.
+func (c *Client) requestRebinding(l Lease) dhcpv4.DHCPRequest {
+	req := dhcpv4.CreateDHCPRequestRebinding(c.cfg.HardwareAddr, l.IP)
+	c.prepare(req.Packet)
+	c.setParameters(req.Packet)
+	return req
+}
+
+// release returns a DHCPRELEASE packet for the specified lease.
+func (c *Client) release(l Lease) dhcpv4.DHCPRelease {
+	req := dhcpv4.CreateDHCPRelease(c.cfg.HardwareAddr, l.IP, l.ServerID)
+	c.prepare(req.Packet)
+	return req
+}
+
+// decline returns a DHCPDECLINE packet for the specified lease.
+func (c *Client) decline(l Lease) dhcpv4.DHCPDecline {
+	req := dhcpv4.CreateDHCPDecline(c.cfg.HardwareAddr, l.IP, l.ServerID)
+	c.prepare(req.Packet)
+	return req
+}
+
+// inform returns a DHCPINFORM packet for a client with the specified
+// externally configured address.
+func (c *Client) inform(ip net.IP) dhcpv4.DHCPInform {
+	req := dhcpv4.CreateDHCPInform(c.cfg.HardwareAddr, ip)
+	c.prepare(req.Packet)
+	c.setParameters(req.Packet)
+	return req
+}
@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/godhcpv4"
+)
+
+func newTestPacketClient() *Client {
+	c := New(nil, Config{
+		HardwareAddr:  net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		ClientID:      []byte("client"),
+		Hostname:      "host",
+		ParameterList: []dhcpv4.Option{dhcpv4.OptionRouter, dhcpv4.OptionDomainServer},
+		Broadcast:     true,
+	})
+
+	copy(c.xid[:], []byte{1, 2, 3, 4})
+	return c
+}
+
+func TestPacketDiscover(t *testing.T) {
+	c := newTestPacketClient()
+
+	p := c.discover(nil)
+	assert.Equal(t, dhcpv4.MessageTypeDHCPDiscover, p.GetMessageType())
+	assert.Equal(t, []byte{1, 2, 3, 4}, p.GetXID())
+	assert.Equal(t, []byte{0, 1, 2, 3, 4, 5}, p.GetCHAddr())
+	assert.NoError(t, p.Validate())
+	assert.Equal(t, byte(128), p.GetFlags()[0])
+
+	v, _ := p.GetOption(dhcpv4.OptionClientID)
+	assert.Equal(t, []byte("client"), v)
+	v, _ = p.GetOption(dhcpv4.OptionParameterList)
+	assert.Equal(t, []byte{3, 6}, v)
+	s, _ := p.GetString(dhcpv4.OptionHostname)
+	assert.Equal(t, "host", s)
+
+	_, ok := p.GetOption(dhcpv4.OptionAddressRequest)
+	assert.False(t, ok)
+
+	p = c.discover(net.IPv4(10, 0, 0, 1))
+	ip, ok := p.GetIP(dhcpv4.OptionAddressRequest)
+	assert.True(t, ok)
+	assert.Equal(t, net.IPv4(10, 0, 0, 1), ip)
+}
+
+func TestPacketRequestSelecting(t *testing.T) {
+	c := newTestPacketClient()
+
+	offer := dhcpv4.NewPacket(dhcpv4.BootReply)
+	offer.SetYIAddr(net.IPv4(10, 0, 0, 1))
+	offer.SetIP(dhcpv4.OptionDHCPServerID, net.IPv4(10, 0, 0, 254))
+
+	p := c.requestSelecting(offer)
+	assert.Equal(t, dhcpv4.MessageTypeDHCPRequest, p.GetMessageType())
+	assert.Equal(t, net.IP{0, 0, 0, 0}, p.GetCIAddr())
+
+	ip, _ := p.GetIP(dhcpv4.OptionAddressRequest)
+	assert.Equal(t, net.IPv4(10, 0, 0, 1), ip)
+	ip, _ = p.GetIP(dhcpv4.OptionDHCPServerID)
+	assert.Equal(t, net.IPv4(10, 0, 0, 254), ip)
+}
+
+func TestPacketRequestExtend(t *testing.T) {
+	c := newTestPacketClient()
+	l := Lease{IP: net.IPv4(10, 0, 0, 1), ServerID: net.IPv4(10, 0, 0, 254)}
+
+	for _, p := range []dhcpv4.DHCPRequest{c.requestRenewing(l), c.requestRebinding(l)} {
+		testPacketRequestExtend(t, p)
+	}
+}
+
+func testPacketRequestExtend(t *testing.T, p dhcpv4.DHCPRequest) {
+	assert.Equal(t, dhcpv4.MessageTypeDHCPRequest, p.GetMessageType())
+	assert.Equal(t, net.IP{10, 0, 0, 1}, p.GetCIAddr())
+	assert.Equal(t, byte(0), p.GetFlags()[0])
+
+	_, ok := p.GetOption(dhcpv4.OptionAddressRequest)
+	assert.False(t, ok)
+	_, ok = p.GetOption(dhcpv4.OptionDHCPServerID)
+	assert.False(t, ok)
+}
+
+func TestPacketReleaseDecline(t *testing.T) {
+	c := newTestPacketClient()
+	l := Lease{IP: net.IPv4(10, 0, 0, 1), ServerID: net.IPv4(10, 0, 0, 254)}
+
+	p := c.release(l)
+	assert.Equal(t, dhcpv4.MessageTypeDHCPRelease, p.GetMessageType())
+	assert.Equal(t, net.IP{10, 0, 0, 1}, p.GetCIAddr())
+	ip, _ := p.GetIP(dhcpv4.OptionDHCPServerID)
+	assert.Equal(t, net.IPv4(10, 0, 0, 254), ip)
+
+	q := c.decline(l)
+	assert.Equal(t, dhcpv4.MessageTypeDHCPDecline, q.GetMessageType())
+	assert.Equal(t, net.IP{0, 0, 0, 0}, q.GetCIAddr())
+	ip, _ = q.GetIP(dhcpv4.OptionAddressRequest)
+	assert.Equal(t, net.IPv4(10, 0, 0, 1), ip)
+	ip, _ = q.GetIP(dhcpv4.OptionDHCPServerID)
+	assert.Equal(t, net.IPv4(10, 0, 0, 254), ip)
+}
+
+func TestPacketInform(t *testing.T) {
+	c := newTestPacketClient()
+
+	p := c.inform(net.IPv4(10, 0, 0, 1))
+	assert.Equal(t, dhcpv4.MessageTypeDHCPInform, p.GetMessageType())
+	assert.Equal(t, net.IP{10, 0, 0, 1}, p.GetCIAddr())
+}
@@ -22,6 +22,9 @@ type Request interface {
 
 	// Every request can tell where it came from.
 	InterfaceIndex() int
+
+	// Every request can tell if it is valid, if the server validated it.
+	ValidationError() error
 }
 
 // Reply defines an interface implemented by DHCP replies.
@@ -32,6 +32,10 @@ type replyValidationTestCase struct {
 	mustNot  []Option
 }
 
+// mustValue is a valid value for each of the options that are required by a
+// message type.
+var mustValue = []byte{10, 0, 0, 1}
+
 func (r replyValidationTestCase) Test(t *testing.T) {
 	var err error
 
@@ -45,7 +49,7 @@ func (r replyValidationTestCase) Test(t *testing.T) {
 		// Add options not to be tested here
 		for j, o := range mustOptions {
 			if i != j {
-				reply.SetOption(o, []byte("foo"))
+				reply.SetOption(o, mustValue)
 			}
 		}
 
@@ -54,7 +58,7 @@ func (r replyValidationTestCase) Test(t *testing.T) {
 		assert.Error(t, err)
 
 		// Pass validation with the option
-		reply.SetOption(o, []byte("foo"))
+		reply.SetOption(o, mustValue)
 		err = reply.Validate()
 		assert.NoError(t, err)
 	}
@@ -65,7 +69,7 @@ func (r replyValidationTestCase) Test(t *testing.T) {
 
 		// Add options not to be tested here
 		for _, o := range mustOptions {
-			reply.SetOption(o, []byte("foo"))
+			reply.SetOption(o, mustValue)
 		}
 
 		// Pass validation without the option
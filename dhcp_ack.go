@@ -53,6 +53,9 @@ func CreateDHCPAck(req Request) DHCPAck {
 
 var dhcpAckOnRequestValidation = []Validation{
 	ValidateMust(OptionAddressTime),
+
+	// From RFC2131, table 3: 'yiaddr' is the assigned address.
+	ValidateNonZero(FieldYIAddr),
 }
 
 var dhcpAckOnInformValidation = []Validation{
@@ -65,28 +68,28 @@ var dhcpAckValidation = []Validation{
 	ValidateMustNot(OptionClientID),
 	ValidateMust(OptionDHCPServerID),
 	ValidateMustNot(OptionDHCPMaxMsgSize),
+	optionValueValidation,
 }
 
 func (d DHCPAck) Validate() error {
-	var err error
+	vs := []Validation{validations(dhcpAckValidation)}
 
 	// Validation is subtly different based on type of request
 	switch d.req.GetMessageType() {
 	case MessageTypeDHCPRequest:
-		err = Validate(d.Packet, dhcpAckOnRequestValidation)
+		vs = append(vs, validations(dhcpAckOnRequestValidation))
 	case MessageTypeDHCPInform:
-		err = Validate(d.Packet, dhcpAckOnInformValidation)
-	}
-
-	if err != nil {
-		return err
+		vs = append(vs, validations(dhcpAckOnInformValidation))
 	}
 
-	return Validate(d.Packet, dhcpAckValidation)
+	return Validate(d.Packet, vs)
 }
 
 func (d DHCPAck) ToBytes() ([]byte, error) {
-	opts := packetToBytesOptions{}
+	opts := packetToBytesOptions{
+		// Prioritize the options the client asked for
+		order: requestedOptionOrder(d.Request()),
+	}
 
 	// Copy MaxMsgSize if set in the request
 	if v, ok := d.Request().GetOption(OptionDHCPMaxMsgSize); ok {
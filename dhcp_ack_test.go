@@ -15,17 +15,23 @@ limitations under the License.
 */
 package dhcpv4
 
-import "testing"
+import (
+	"net"
+	"testing"
+)
 
 func TestDHCPAckOnRequestValidation(t *testing.T) {
 	testCase := replyValidationTestCase{
 		newReply: func() ValidatingReply {
 			req := NewPacket(BootRequest)
 			req.SetMessageType(MessageTypeDHCPRequest)
-			return &DHCPAck{
+			rep := DHCPAck{
 				Packet: NewPacket(BootReply),
 				req:    req,
 			}
+
+			rep.SetYIAddr(net.IPv4(10, 0, 0, 2))
+			return &rep
 		},
 		must: []Option{
 			OptionAddressTime,
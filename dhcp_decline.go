@@ -15,8 +15,66 @@ limitations under the License.
 */
 package dhcpv4
 
+import "net"
+
 // DHCPDecline is a client to server packet indicating network address is
 // already in use.
 type DHCPDecline struct {
 	Packet
 }
+
+// CreateDHCPDecline creates a DHCPDECLINE for address ip, which was assigned
+// by the server with the specified server identifier.
+func CreateDHCPDecline(chaddr []byte, ip, serverID net.IP) DHCPDecline {
+	req := DHCPDecline{
+		Packet: NewRequest(chaddr),
+	}
+
+	req.SetMessageType(MessageTypeDHCPDecline)
+	req.SetIP(OptionAddressRequest, ip)
+	req.SetIP(OptionDHCPServerID, serverID)
+	return req
+}
+
+// From RFC2131, table 5:
+//   Option                    DHCPDECLINE
+//   ------                    -----------
+//   Requested IP address      MUST
+//   IP address lease time     MUST NOT
+//   Use 'file'/'sname' fields MAY
+//   DHCP message type         DHCPDECLINE
+//   Client identifier         MAY
+//   Vendor class identifier   MUST NOT
+//   Server identifier         MUST
+//   Parameter request list    MUST NOT
+//   Maximum message size      MUST NOT
+//   Message                   SHOULD
+//   Site-specific             MUST NOT
+//   All others                MUST NOT
+
+var dhcpDeclineAllowedOptions = []Option{
+	OptionAddressRequest,
+	OptionOverload,
+	OptionDHCPMsgType,
+	OptionClientID,
+	OptionDHCPServerID,
+	OptionDHCPMessage,
+
+	// Added by relay agents (RFC3046)
+	OptionRelayAgentInformation,
+}
+
+var dhcpDeclineValidation = []Validation{
+	ValidateMust(OptionAddressRequest),
+	ValidateMust(OptionDHCPServerID),
+	ValidateAllowedOptions(dhcpDeclineAllowedOptions),
+	optionValueValidation,
+}
+
+func (d DHCPDecline) Validate() error {
+	return Validate(d.Packet, dhcpDeclineValidation)
+}
+
+func (d DHCPDecline) ToBytes() ([]byte, error) {
+	return PacketToBytes(d.Packet, nil)
+}
@@ -14,3 +14,43 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 package dhcpv4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateDHCPDecline(t *testing.T) {
+	req := CreateDHCPDecline([]byte{0, 1, 2, 3, 4, 5}, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 254))
+	assert.Equal(t, MessageTypeDHCPDecline, req.GetMessageType())
+	v, _ := req.GetIP(OptionAddressRequest)
+	assert.Equal(t, net.IPv4(10, 0, 0, 1), v)
+	v, _ = req.GetIP(OptionDHCPServerID)
+	assert.Equal(t, net.IPv4(10, 0, 0, 254), v)
+	assert.NoError(t, req.Validate())
+}
+
+func TestDHCPDeclineValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			return &DHCPDecline{
+				Packet: NewPacket(BootRequest),
+			}
+		},
+		must: []Option{
+			OptionAddressRequest,
+			OptionDHCPServerID,
+		},
+		mustNot: []Option{
+			OptionAddressTime,
+			OptionClassID,
+			OptionParameterList,
+			OptionDHCPMaxMsgSize,
+			OptionRouter,
+		},
+	}
+
+	testCase.Test(t)
+}
@@ -20,3 +20,41 @@ type DHCPDiscover struct {
 	Packet
 	ReplyWriter
 }
+
+func CreateDHCPDiscover(chaddr []byte) DHCPDiscover {
+	req := DHCPDiscover{
+		Packet: NewRequest(chaddr),
+	}
+
+	req.SetMessageType(MessageTypeDHCPDiscover)
+	return req
+}
+
+// From RFC2131, table 5:
+//   Option                    DHCPDISCOVER
+//   ------                    ------------
+//   Requested IP address      MAY
+//   IP address lease time     MAY
+//   Use 'file'/'sname' fields MAY
+//   DHCP message type         DHCPDISCOVER
+//   Client identifier         MAY
+//   Vendor class identifier   MAY
+//   Server identifier         MUST NOT
+//   Parameter request list    MAY
+//   Maximum message size      MAY
+//   Message                   SHOULD NOT
+//   Site-specific             MAY
+//   All others                MAY
+
+var dhcpDiscoverValidation = []Validation{
+	ValidateMustNot(OptionDHCPServerID),
+	optionValueValidation,
+}
+
+func (d DHCPDiscover) Validate() error {
+	return Validate(d.Packet, dhcpDiscoverValidation)
+}
+
+func (d DHCPDiscover) ToBytes() ([]byte, error) {
+	return PacketToBytes(d.Packet, nil)
+}
@@ -40,3 +40,25 @@ func TestDHCPDiscoverWriteReply(t *testing.T) {
 		assert.True(t, rw.wrote)
 	}
 }
+
+func TestCreateDHCPDiscover(t *testing.T) {
+	req := CreateDHCPDiscover([]byte{0, 1, 2, 3, 4, 5})
+	assert.Equal(t, MessageTypeDHCPDiscover, req.GetMessageType())
+	assert.Equal(t, []byte{0, 1, 2, 3, 4, 5}, req.GetCHAddr())
+	assert.NoError(t, req.Validate())
+}
+
+func TestDHCPDiscoverValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			return &DHCPDiscover{
+				Packet: NewPacket(BootRequest),
+			}
+		},
+		mustNot: []Option{
+			OptionDHCPServerID,
+		},
+	}
+
+	testCase.Test(t)
+}
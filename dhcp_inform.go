@@ -15,9 +15,54 @@ limitations under the License.
 */
 package dhcpv4
 
+import "net"
+
 // DHCPInform is a client to server packet, asking only for local configuration
 // parameters; client already has externally configured network address.
 type DHCPInform struct {
 	Packet
 	ReplyWriter
 }
+
+// CreateDHCPInform creates a DHCPINFORM for a client with the externally
+// configured address ciaddr.
+func CreateDHCPInform(chaddr []byte, ciaddr net.IP) DHCPInform {
+	req := DHCPInform{
+		Packet: NewRequest(chaddr),
+	}
+
+	req.SetMessageType(MessageTypeDHCPInform)
+	req.SetCIAddr(ciaddr)
+	return req
+}
+
+// From RFC2131, table 5:
+//   Option                    DHCPINFORM
+//   ------                    ----------
+//   Requested IP address      MUST NOT
+//   IP address lease time     MUST NOT
+//   Use 'file'/'sname' fields MAY
+//   DHCP message type         DHCPINFORM
+//   Client identifier         MAY
+//   Vendor class identifier   MAY
+//   Server identifier         MUST NOT
+//   Parameter request list    MAY
+//   Maximum message size      MAY
+//   Message                   SHOULD NOT
+//   Site-specific             MAY
+//   All others                MAY
+
+var dhcpInformValidation = []Validation{
+	ValidateMustNot(OptionAddressRequest),
+	ValidateMustNot(OptionAddressTime),
+	ValidateMustNot(OptionDHCPServerID),
+	optionValueValidation,
+}
+
+func (d DHCPInform) Validate() error {
+	return Validate(d.Packet, dhcpInformValidation)
+}
+
+func (d DHCPInform) ToBytes() ([]byte, error) {
+	return PacketToBytes(d.Packet, nil)
+}
@@ -16,6 +16,7 @@ limitations under the License.
 package dhcpv4
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -40,3 +41,27 @@ func TestDHCPInformWriteReply(t *testing.T) {
 		assert.True(t, rw.wrote)
 	}
 }
+
+func TestCreateDHCPInform(t *testing.T) {
+	req := CreateDHCPInform([]byte{0, 1, 2, 3, 4, 5}, net.IPv4(10, 0, 0, 1))
+	assert.Equal(t, MessageTypeDHCPInform, req.GetMessageType())
+	assert.Equal(t, net.IP{10, 0, 0, 1}, req.GetCIAddr())
+	assert.NoError(t, req.Validate())
+}
+
+func TestDHCPInformValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			return &DHCPInform{
+				Packet: NewPacket(BootRequest),
+			}
+		},
+		mustNot: []Option{
+			OptionAddressRequest,
+			OptionAddressTime,
+			OptionDHCPServerID,
+		},
+	}
+
+	testCase.Test(t)
+}
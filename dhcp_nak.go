@@ -15,7 +15,10 @@ limitations under the License.
 */
 package dhcpv4
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"net"
+)
 
 // DHCPNak is a server to client packet indicating client's notion of network
 // address is incorrect (e.g., client has moved to new subnet) or client's
@@ -33,6 +36,13 @@ func CreateDHCPNak(req Request) DHCPNak {
 	}
 
 	rep.SetMessageType(MessageTypeDHCPNak)
+
+	// From RFC2131, section 4.1: the server sets the broadcast bit in a
+	// DHCPNAK on a relayed request, so the relay agent broadcasts it.
+	if !req.GetGIAddr().Equal(net.IPv4zero) {
+		rep.Flags()[0] |= 128
+	}
+
 	return rep
 }
 
@@ -57,11 +67,21 @@ var dhcpNakAllowedOptions = []Option{
 	OptionClientID,
 	OptionClassID,
 	OptionDHCPServerID,
+
+	// Echoed from the request (RFC3046)
+	OptionRelayAgentInformation,
 }
 
 var dhcpNakValidation = []Validation{
 	ValidateMust(OptionDHCPServerID),
 	ValidateAllowedOptions(dhcpNakAllowedOptions),
+
+	// From RFC2131, table 3: 'ciaddr', 'yiaddr' and 'siaddr' are 0.
+	ValidateZero(FieldCIAddr),
+	ValidateZero(FieldYIAddr),
+	ValidateZero(FieldSIAddr),
+
+	optionValueValidation,
 }
 
 func (d DHCPNak) Validate() error {
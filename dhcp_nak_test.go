@@ -15,7 +15,12 @@ limitations under the License.
 */
 package dhcpv4
 
-import "testing"
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestDHCPNakValidation(t *testing.T) {
 	testCase := replyValidationTestCase{
@@ -41,3 +46,46 @@ func TestDHCPNakValidation(t *testing.T) {
 
 	testCase.Test(t)
 }
+
+func TestDHCPNakEchoesRelayAgentInformation(t *testing.T) {
+	req := NewPacket(BootRequest)
+	req.SetMessageType(MessageTypeDHCPRequest)
+	req.SetOption(OptionRelayAgentInformation, []byte{byte(RelayAgentCircuitID), 1, 'x'})
+
+	rep := CreateDHCPNak(req)
+	rep.SetIP(OptionDHCPServerID, net.IPv4(10, 0, 0, 1))
+	assert.NoError(t, rep.Validate())
+
+	_, ok := rep.GetRelayAgentInformation()
+	assert.True(t, ok)
+}
+
+func TestDHCPNakRelayedSetsBroadcast(t *testing.T) {
+	req := NewPacket(BootRequest)
+	req.SetMessageType(MessageTypeDHCPRequest)
+
+	rep := CreateDHCPNak(req)
+	assert.Equal(t, byte(0), rep.GetFlags()[0]&128)
+
+	req.SetGIAddr(net.IPv4(10, 0, 0, 1))
+	rep = CreateDHCPNak(req)
+	assert.Equal(t, byte(128), rep.GetFlags()[0]&128)
+}
+
+func TestDHCPNakHeaderValidation(t *testing.T) {
+	req := NewPacket(BootRequest)
+	req.SetMessageType(MessageTypeDHCPRequest)
+
+	rep := CreateDHCPNak(req)
+	rep.SetIP(OptionDHCPServerID, net.IPv4(10, 0, 0, 1))
+	rep.SetCIAddr(net.IPv4(10, 0, 0, 2))
+	rep.SetYIAddr(net.IPv4(10, 0, 0, 2))
+
+	err := rep.Validate()
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, []Violation{
+			{Field: "ciaddr", Message: "MUST be zero"},
+			{Field: "yiaddr", Message: "MUST be zero"},
+		}, err.(*ValidationError).Violations)
+	}
+}
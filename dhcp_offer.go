@@ -57,6 +57,13 @@ var dhcpOfferValidation = []Validation{
 	ValidateMustNot(OptionClientID),
 	ValidateMust(OptionDHCPServerID),
 	ValidateMustNot(OptionDHCPMaxMsgSize),
+
+	// From RFC2131, table 3: 'ciaddr' is 0 and 'yiaddr' is the offered
+	// address.
+	ValidateZero(FieldCIAddr),
+	ValidateNonZero(FieldYIAddr),
+
+	optionValueValidation,
 }
 
 func (d DHCPOffer) Validate() error {
@@ -64,7 +71,10 @@ func (d DHCPOffer) Validate() error {
 }
 
 func (d DHCPOffer) ToBytes() ([]byte, error) {
-	opts := packetToBytesOptions{}
+	opts := packetToBytesOptions{
+		// Prioritize the options the client asked for
+		order: requestedOptionOrder(d.Request()),
+	}
 
 	// Copy MaxMsgSize if set in the request
 	if v, ok := d.Request().GetOption(OptionDHCPMaxMsgSize); ok {
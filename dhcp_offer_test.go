@@ -15,15 +15,21 @@ limitations under the License.
 */
 package dhcpv4
 
-import "testing"
+import (
+	"net"
+	"testing"
+)
 
 func TestDHCPOfferValidation(t *testing.T) {
 	testCase := replyValidationTestCase{
 		newReply: func() ValidatingReply {
-			return &DHCPOffer{
+			rep := DHCPOffer{
 				Packet: NewPacket(BootReply),
 				req:    NewPacket(BootRequest),
 			}
+
+			rep.SetYIAddr(net.IPv4(10, 0, 0, 2))
+			return &rep
 		},
 		must: []Option{
 			OptionAddressTime,
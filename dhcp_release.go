@@ -15,8 +15,64 @@ limitations under the License.
 */
 package dhcpv4
 
+import "net"
+
 // DHCPRelease is a client to server packet relinquishing network address and
 // cancelling remaining lease.
 type DHCPRelease struct {
 	Packet
 }
+
+// CreateDHCPRelease creates a DHCPRELEASE for address ciaddr, which was
+// assigned by the server with the specified server identifier.
+func CreateDHCPRelease(chaddr []byte, ciaddr, serverID net.IP) DHCPRelease {
+	req := DHCPRelease{
+		Packet: NewRequest(chaddr),
+	}
+
+	req.SetMessageType(MessageTypeDHCPRelease)
+	req.SetCIAddr(ciaddr)
+	req.SetIP(OptionDHCPServerID, serverID)
+	return req
+}
+
+// From RFC2131, table 5:
+//   Option                    DHCPRELEASE
+//   ------                    -----------
+//   Requested IP address      MUST NOT
+//   IP address lease time     MUST NOT
+//   Use 'file'/'sname' fields MAY
+//   DHCP message type         DHCPRELEASE
+//   Client identifier         MAY
+//   Vendor class identifier   MUST NOT
+//   Server identifier         MUST
+//   Parameter request list    MUST NOT
+//   Maximum message size      MUST NOT
+//   Message                   SHOULD
+//   Site-specific             MUST NOT
+//   All others                MUST NOT
+
+var dhcpReleaseAllowedOptions = []Option{
+	OptionOverload,
+	OptionDHCPMsgType,
+	OptionClientID,
+	OptionDHCPServerID,
+	OptionDHCPMessage,
+
+	// Added by relay agents (RFC3046)
+	OptionRelayAgentInformation,
+}
+
+var dhcpReleaseValidation = []Validation{
+	ValidateMust(OptionDHCPServerID),
+	ValidateAllowedOptions(dhcpReleaseAllowedOptions),
+	optionValueValidation,
+}
+
+func (d DHCPRelease) Validate() error {
+	return Validate(d.Packet, dhcpReleaseValidation)
+}
+
+func (d DHCPRelease) ToBytes() ([]byte, error) {
+	return PacketToBytes(d.Packet, nil)
+}
@@ -14,3 +14,42 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 package dhcpv4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateDHCPRelease(t *testing.T) {
+	req := CreateDHCPRelease([]byte{0, 1, 2, 3, 4, 5}, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 254))
+	assert.Equal(t, MessageTypeDHCPRelease, req.GetMessageType())
+	assert.Equal(t, net.IP{10, 0, 0, 1}, req.GetCIAddr())
+	v, _ := req.GetIP(OptionDHCPServerID)
+	assert.Equal(t, net.IPv4(10, 0, 0, 254), v)
+	assert.NoError(t, req.Validate())
+}
+
+func TestDHCPReleaseValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			return &DHCPRelease{
+				Packet: NewPacket(BootRequest),
+			}
+		},
+		must: []Option{
+			OptionDHCPServerID,
+		},
+		mustNot: []Option{
+			OptionAddressRequest,
+			OptionAddressTime,
+			OptionClassID,
+			OptionParameterList,
+			OptionDHCPMaxMsgSize,
+			OptionRouter,
+		},
+	}
+
+	testCase.Test(t)
+}
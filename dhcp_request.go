@@ -15,6 +15,11 @@ limitations under the License.
 */
 package dhcpv4
 
+import (
+	"net"
+	"strconv"
+)
+
 // DHCPRequest is a client message to servers either (a) requesting offered
 // parameters from one server and implicitly declining offers from all others,
 // (b) confirming correctness of previously allocated address after, e.g.,
@@ -23,3 +28,193 @@ type DHCPRequest struct {
 	Packet
 	ReplyWriter
 }
+
+func createDHCPRequest(chaddr []byte) DHCPRequest {
+	req := DHCPRequest{
+		Packet: NewRequest(chaddr),
+	}
+
+	req.SetMessageType(MessageTypeDHCPRequest)
+	return req
+}
+
+// CreateDHCPRequestSelecting creates a DHCPREQUEST as sent in the SELECTING
+// state, to accept the offer of address ip by the server with the specified
+// server identifier. It MUST be broadcast.
+func CreateDHCPRequestSelecting(chaddr []byte, ip, serverID net.IP) DHCPRequest {
+	req := createDHCPRequest(chaddr)
+	req.SetIP(OptionAddressRequest, ip)
+	req.SetIP(OptionDHCPServerID, serverID)
+	return req
+}
+
+// CreateDHCPRequestInitReboot creates a DHCPREQUEST as sent in the
+// INIT-REBOOT state, to verify the previously allocated address ip. It MUST be
+// broadcast.
+func CreateDHCPRequestInitReboot(chaddr []byte, ip net.IP) DHCPRequest {
+	req := createDHCPRequest(chaddr)
+	req.SetIP(OptionAddressRequest, ip)
+	return req
+}
+
+// CreateDHCPRequestRenewing creates a DHCPREQUEST as sent in the RENEWING
+// state, to extend the lease on address ciaddr. It MUST be unicast to the
+// server that granted the lease.
+func CreateDHCPRequestRenewing(chaddr []byte, ciaddr net.IP) DHCPRequest {
+	req := createDHCPRequest(chaddr)
+	req.SetCIAddr(ciaddr)
+	return req
+}
+
+// CreateDHCPRequestRebinding creates a DHCPREQUEST as sent in the REBINDING
+// state, to extend the lease on address ciaddr. It MUST be broadcast, so its
+// destination is the limited broadcast address.
+func CreateDHCPRequestRebinding(chaddr []byte, ciaddr net.IP) DHCPRequest {
+	req := createDHCPRequest(chaddr)
+	req.SetCIAddr(ciaddr)
+	req.dst = net.IPv4bcast
+	return req
+}
+
+// RequestState is the state of the client that sent a DHCPREQUEST, as
+// described in RFC2131, section 4.3.2.
+type RequestState int
+
+const (
+	RequestStateUnknown RequestState = iota
+	RequestStateSelecting
+	RequestStateInitReboot
+	RequestStateRenewing
+	RequestStateRebinding
+)
+
+var requestStateNames = map[RequestState]string{
+	RequestStateUnknown:    "UNKNOWN",
+	RequestStateSelecting:  "SELECTING",
+	RequestStateInitReboot: "INIT-REBOOT",
+	RequestStateRenewing:   "RENEWING",
+	RequestStateRebinding:  "REBINDING",
+}
+
+func (s RequestState) String() string {
+	if name, ok := requestStateNames[s]; ok {
+		return name
+	}
+
+	return "RequestState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Broadcast returns whether the request was sent to the limited broadcast
+// address. A request with 'giaddr' set was forwarded by a relay agent, which
+// only forwards requests that were broadcast (RFC1542, section 4.1.1), so it is
+// considered broadcast as well. Otherwise, it returns false if the destination
+// of the request is not known.
+func (d DHCPRequest) Broadcast() bool {
+	if giaddr := d.GetGIAddr(); !giaddr.Equal(net.IPv4zero) {
+		return true
+	}
+
+	return d.Destination().Equal(net.IPv4bcast)
+}
+
+// State returns the state of the client that sent the request, and the
+// address it asks for, as described in RFC2131, section 4.3.2:
+//
+//   SELECTING:   'server identifier' is set, the address is in 'requested IP
+//                address'.
+//   INIT-REBOOT: 'requested IP address' is set, the address is in it.
+//   RENEWING:    'ciaddr' is set, and the request was unicast.
+//   REBINDING:   'ciaddr' is set, and the request was broadcast.
+//
+// See Broadcast for how a broadcast request is recognized. If it is not known
+// whether the request was broadcast, a request with 'ciaddr' is classified as
+// RENEWING. The address is nil if the state is unknown, or if a request in the
+// SELECTING state doesn't include it.
+func (d DHCPRequest) State() (RequestState, net.IP) {
+	if _, ok := d.GetOption(OptionDHCPServerID); ok {
+		ip, _ := d.GetIP(OptionAddressRequest)
+		return RequestStateSelecting, ip
+	}
+
+	if ip, ok := d.GetIP(OptionAddressRequest); ok {
+		return RequestStateInitReboot, ip
+	}
+
+	if ciaddr := d.GetCIAddr(); !ciaddr.Equal(net.IPv4zero) {
+		if d.Broadcast() {
+			return RequestStateRebinding, ciaddr
+		}
+
+		return RequestStateRenewing, ciaddr
+	}
+
+	return RequestStateUnknown, nil
+}
+
+// From RFC2131, table 5:
+//   Option                    DHCPREQUEST
+//   ------                    -----------
+//   Requested IP address      MUST (in SELECTING or INIT-REBOOT)
+//                             MUST NOT (in BOUND or RENEWING)
+//   IP address lease time     MAY
+//   Use 'file'/'sname' fields MAY
+//   DHCP message type         DHCPREQUEST
+//   Client identifier         MAY
+//   Vendor class identifier   MAY
+//   Server identifier         MUST (after SELECTING)
+//                             MUST NOT (after INIT-REBOOT, BOUND, RENEWING
+//                                       or REBINDING)
+//   Parameter request list    MAY
+//   Maximum message size      MAY
+//   Message                   SHOULD NOT
+//   Site-specific             MAY
+//   All others                MAY
+//
+// From RFC2131, section 4.3.2: 'ciaddr' MUST be zero in the SELECTING and
+// INIT-REBOOT states, and MUST be filled in with the client's IP address in
+// the RENEWING and REBINDING states. The state is derived with State.
+
+var dhcpRequestSelectingValidation = []Validation{
+	ValidateMust(OptionAddressRequest),
+	ValidateMust(OptionDHCPServerID),
+	ValidateZero(FieldCIAddr),
+	optionValueValidation,
+}
+
+var dhcpRequestInitRebootValidation = []Validation{
+	ValidateMust(OptionAddressRequest),
+	ValidateMustNot(OptionDHCPServerID),
+	ValidateZero(FieldCIAddr),
+	optionValueValidation,
+}
+
+var dhcpRequestWithCIAddrValidation = []Validation{
+	ValidateMustNot(OptionAddressRequest),
+	ValidateMustNot(OptionDHCPServerID),
+	optionValueValidation,
+}
+
+// A request that is in none of the states lacks the requested IP address.
+var dhcpRequestUnknownValidation = []Validation{
+	ValidateMust(OptionAddressRequest),
+	optionValueValidation,
+}
+
+func (d DHCPRequest) Validate() error {
+	state, _ := d.State()
+
+	switch state {
+	case RequestStateSelecting:
+		return Validate(d.Packet, dhcpRequestSelectingValidation)
+	case RequestStateInitReboot:
+		return Validate(d.Packet, dhcpRequestInitRebootValidation)
+	case RequestStateRenewing, RequestStateRebinding:
+		return Validate(d.Packet, dhcpRequestWithCIAddrValidation)
+	}
+
+	return Validate(d.Packet, dhcpRequestUnknownValidation)
+}
+
+func (d DHCPRequest) ToBytes() ([]byte, error) {
+	return PacketToBytes(d.Packet, nil)
+}
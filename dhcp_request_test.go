@@ -16,6 +16,7 @@ limitations under the License.
 package dhcpv4
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -41,3 +42,169 @@ func TestDHCPRequestWriteReply(t *testing.T) {
 		assert.True(t, rw.wrote)
 	}
 }
+
+func TestCreateDHCPRequest(t *testing.T) {
+	chaddr := []byte{0, 1, 2, 3, 4, 5}
+	ip := net.IPv4(10, 0, 0, 1)
+	sid := net.IPv4(10, 0, 0, 254)
+
+	req := CreateDHCPRequestSelecting(chaddr, ip, sid)
+	assert.Equal(t, MessageTypeDHCPRequest, req.GetMessageType())
+	assert.Equal(t, net.IP{0, 0, 0, 0}, req.GetCIAddr())
+	v, _ := req.GetIP(OptionAddressRequest)
+	assert.Equal(t, ip, v)
+	v, _ = req.GetIP(OptionDHCPServerID)
+	assert.Equal(t, sid, v)
+	assert.NoError(t, req.Validate())
+
+	req = CreateDHCPRequestInitReboot(chaddr, ip)
+	assert.Equal(t, net.IP{0, 0, 0, 0}, req.GetCIAddr())
+	v, _ = req.GetIP(OptionAddressRequest)
+	assert.Equal(t, ip, v)
+	_, ok := req.GetOption(OptionDHCPServerID)
+	assert.False(t, ok)
+	assert.NoError(t, req.Validate())
+
+	for _, req = range []DHCPRequest{
+		CreateDHCPRequestRenewing(chaddr, ip),
+		CreateDHCPRequestRebinding(chaddr, ip),
+	} {
+		assert.Equal(t, net.IP{10, 0, 0, 1}, req.GetCIAddr())
+		_, ok = req.GetOption(OptionAddressRequest)
+		assert.False(t, ok)
+		_, ok = req.GetOption(OptionDHCPServerID)
+		assert.False(t, ok)
+		assert.NoError(t, req.Validate())
+	}
+}
+
+func TestDHCPRequestState(t *testing.T) {
+	chaddr := []byte{0, 1, 2, 3, 4, 5}
+	ip := net.IPv4(10, 0, 0, 1)
+	sid := net.IPv4(10, 0, 0, 254)
+
+	rebinding := CreateDHCPRequestRebinding(chaddr, ip)
+
+	renewing := CreateDHCPRequestRenewing(chaddr, ip)
+	renewing.dst = sid
+
+	// Without a destination, a request with 'ciaddr' is renewing
+	unknown := CreateDHCPRequestRenewing(chaddr, ip)
+
+	// Relay agents only forward broadcast requests
+	relayed := CreateDHCPRequestRebinding(chaddr, ip)
+	relayed.dst = sid
+	relayed.SetGIAddr(net.IPv4(10, 0, 0, 253))
+
+	testCases := []struct {
+		req   DHCPRequest
+		state RequestState
+		ip    net.IP
+	}{
+		{CreateDHCPRequestSelecting(chaddr, ip, sid), RequestStateSelecting, ip},
+		{CreateDHCPRequestInitReboot(chaddr, ip), RequestStateInitReboot, ip},
+		{renewing, RequestStateRenewing, net.IP{10, 0, 0, 1}},
+		{rebinding, RequestStateRebinding, net.IP{10, 0, 0, 1}},
+		{relayed, RequestStateRebinding, net.IP{10, 0, 0, 1}},
+
+		{unknown, RequestStateRenewing, net.IP{10, 0, 0, 1}},
+
+		{DHCPRequest{Packet: NewRequest(chaddr)}, RequestStateUnknown, nil},
+	}
+
+	for _, tc := range testCases {
+		state, ip := tc.req.State()
+		assert.Equal(t, tc.state, state)
+		assert.Equal(t, tc.ip, ip)
+	}
+
+	assert.True(t, rebinding.Broadcast())
+	assert.False(t, renewing.Broadcast())
+	assert.True(t, relayed.Broadcast())
+	assert.Equal(t, "INIT-REBOOT", RequestStateInitReboot.String())
+	assert.Equal(t, "RequestState(42)", RequestState(42).String())
+}
+
+func TestDHCPRequestValidation(t *testing.T) {
+	chaddr := []byte{0, 1, 2, 3, 4, 5}
+	ip := net.IPv4(10, 0, 0, 1)
+	sid := net.IPv4(10, 0, 0, 254)
+
+	selectingWithoutIP := CreateDHCPRequestSelecting(chaddr, ip, sid)
+	delete(selectingWithoutIP.OptionMap, OptionAddressRequest)
+
+	selectingWithCIAddr := CreateDHCPRequestSelecting(chaddr, ip, sid)
+	selectingWithCIAddr.SetCIAddr(ip)
+
+	initRebootWithCIAddr := CreateDHCPRequestInitReboot(chaddr, ip)
+	initRebootWithCIAddr.SetCIAddr(ip)
+
+	renewingWithServerID := CreateDHCPRequestRenewing(chaddr, ip)
+	renewingWithServerID.SetIP(OptionDHCPServerID, sid)
+
+	rebindingWithIP := CreateDHCPRequestRebinding(chaddr, ip)
+	rebindingWithIP.SetIP(OptionAddressRequest, ip)
+
+	testCases := []struct {
+		req   DHCPRequest
+		valid bool
+	}{
+		{CreateDHCPRequestSelecting(chaddr, ip, sid), true},
+		{selectingWithoutIP, false},
+		{selectingWithCIAddr, false},
+
+		{CreateDHCPRequestInitReboot(chaddr, ip), true},
+		{initRebootWithCIAddr, false},
+
+		{CreateDHCPRequestRenewing(chaddr, ip), true},
+		{renewingWithServerID, false},
+
+		{CreateDHCPRequestRebinding(chaddr, ip), true},
+		{rebindingWithIP, false},
+
+		{createDHCPRequest(chaddr), false},
+	}
+
+	for i, tc := range testCases {
+		err := tc.req.Validate()
+		if tc.valid {
+			assert.NoError(t, err, "test case %d", i)
+		} else {
+			assert.Error(t, err, "test case %d", i)
+		}
+	}
+}
+
+func TestDHCPRequestWithoutCIAddrValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			return &DHCPRequest{
+				Packet: NewPacket(BootRequest),
+			}
+		},
+		must: []Option{
+			OptionAddressRequest,
+		},
+	}
+
+	testCase.Test(t)
+}
+
+func TestDHCPRequestWithCIAddrValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			req := DHCPRequest{
+				Packet: NewPacket(BootRequest),
+			}
+
+			req.SetCIAddr(net.IPv4(10, 0, 0, 1))
+			return &req
+		},
+		mustNot: []Option{
+			OptionAddressRequest,
+			OptionDHCPServerID,
+		},
+	}
+
+	testCase.Test(t)
+}
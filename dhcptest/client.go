@@ -0,0 +1,185 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcptest
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/vmware/godhcpv4"
+)
+
+var (
+	ErrTimeout = errors.New("dhcptest: timeout waiting for reply")
+	ErrNak     = errors.New("dhcptest: received DHCPNAK")
+)
+
+// DefaultTimeout is the time a Client waits for a reply if Timeout is not set.
+const DefaultTimeout = time.Second
+
+// Lease is the result of a successful DHCPDISCOVER/DHCPOFFER/DHCPREQUEST/DHCPACK
+// exchange.
+type Lease struct {
+	IP       net.IP
+	ServerID net.IP
+	Duration time.Duration
+
+	// The DHCPACK that concluded the exchange.
+	Ack dhcpv4.Packet
+}
+
+// Client sends scripted requests on a Conn and waits for the replies. Unlike
+// the client package, it doesn't retransmit requests or maintain a lease, so
+// tests can control every message that is exchanged.
+type Client struct {
+	// Timeout is the time to wait for a reply. Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	conn *Conn
+}
+
+// NewClient returns a Client that sends requests on conn.
+func NewClient(conn *Conn) *Client {
+	return &Client{conn: conn}
+}
+
+// message is implemented by the request types a Client can send.
+type message interface {
+	ToBytes() ([]byte, error)
+	GetXID() []byte
+}
+
+// Send broadcasts request m without waiting for a reply.
+func (c *Client) Send(m message) error {
+	return c.SendTo(m, net.IPv4bcast)
+}
+
+// SendTo sends request m to the server with the specified address without
+// waiting for a reply.
+func (c *Client) SendTo(m message, ip net.IP) error {
+	b, err := m.ToBytes()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.WriteTo(b, &net.UDPAddr{IP: ip, Port: 67}, c.conn.ifindex)
+	return err
+}
+
+// Receive waits for a reply with the XID of request m and one of the
+// specified message types. Other packets are discarded. It returns ErrTimeout
+// if no such reply arrives in time.
+func (c *Client) Receive(m message, types ...dhcpv4.MessageType) (dhcpv4.Packet, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 65536)
+	for {
+		n, _, _, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if _, ok := err.(timeoutError); ok {
+				return dhcpv4.Packet{}, ErrTimeout
+			}
+
+			return dhcpv4.Packet{}, err
+		}
+
+		p, err := dhcpv4.PacketFromBytes(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		if dhcpv4.OpCode(p.Op()[0]) != dhcpv4.BootReply || !bytes.Equal(p.GetXID(), m.GetXID()) {
+			continue
+		}
+
+		for _, t := range types {
+			if p.GetMessageType() == t {
+				return p, nil
+			}
+		}
+	}
+}
+
+// Exchange broadcasts request m, and waits for a reply with one of the
+// specified message types.
+func (c *Client) Exchange(m message, types ...dhcpv4.MessageType) (dhcpv4.Packet, error) {
+	if err := c.Send(m); err != nil {
+		return dhcpv4.Packet{}, err
+	}
+
+	return c.Receive(m, types...)
+}
+
+// Discover broadcasts a DHCPDISCOVER for the client with hardware address mac,
+// and returns the first DHCPOFFER.
+func (c *Client) Discover(mac net.HardwareAddr) (dhcpv4.DHCPDiscover, dhcpv4.Packet, error) {
+	discover := dhcpv4.CreateDHCPDiscover(mac)
+	offer, err := c.Exchange(discover, dhcpv4.MessageTypeDHCPOffer)
+	return discover, offer, err
+}
+
+// DoDORA runs a DHCPDISCOVER/DHCPOFFER/DHCPREQUEST/DHCPACK exchange for the
+// client with hardware address mac, and returns the resulting lease. It
+// returns ErrNak if the server answers the DHCPREQUEST with a DHCPNAK.
+func (c *Client) DoDORA(mac net.HardwareAddr) (Lease, error) {
+	discover, offer, err := c.Discover(mac)
+	if err != nil {
+		return Lease{}, err
+	}
+
+	serverID, _ := offer.GetIP(dhcpv4.OptionDHCPServerID)
+
+	req := dhcpv4.CreateDHCPRequestSelecting(mac, offer.GetYIAddr(), serverID)
+	copy(req.XID(), discover.XID())
+
+	ack, err := c.Exchange(req, dhcpv4.MessageTypeDHCPAck, dhcpv4.MessageTypeDHCPNak)
+	if err != nil {
+		return Lease{}, err
+	}
+
+	if ack.GetMessageType() == dhcpv4.MessageTypeDHCPNak {
+		return Lease{}, ErrNak
+	}
+
+	l := Lease{
+		IP:  ack.GetYIAddr(),
+		Ack: ack,
+	}
+
+	l.ServerID, _ = ack.GetIP(dhcpv4.OptionDHCPServerID)
+	l.Duration, _ = ack.GetDuration(dhcpv4.OptionAddressTime)
+	return l, nil
+}
+
+// Release sends a DHCPRELEASE for lease l of the client with hardware address
+// mac to the server that granted it.
+func (c *Client) Release(mac net.HardwareAddr, l Lease) error {
+	return c.SendTo(dhcpv4.CreateDHCPRelease(mac, l.IP, l.ServerID), l.ServerID)
+}
+
+// Inform sends a DHCPINFORM for the client with hardware address mac and
+// address ip, and returns the DHCPACK.
+func (c *Client) Inform(mac net.HardwareAddr, ip net.IP) (dhcpv4.Packet, error) {
+	return c.Exchange(dhcpv4.CreateDHCPInform(mac, ip), dhcpv4.MessageTypeDHCPAck)
+}
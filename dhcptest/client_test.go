@@ -0,0 +1,138 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcptest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/godhcpv4"
+	"github.com/vmware/godhcpv4/pool"
+)
+
+var testServerID = net.IPv4(10, 0, 0, 1)
+
+func newTestPool(t *testing.T) *pool.Handler {
+	h, err := pool.NewHandler(testServerID, []pool.Subnet{
+		{
+			Network:        net.IPNet{IP: net.IPv4(192, 168, 1, 0), Mask: net.CIDRMask(24, 32)},
+			Ranges:         []pool.Range{{Start: net.IPv4(192, 168, 1, 10), End: net.IPv4(192, 168, 1, 20)}},
+			LeaseTime:      time.Hour,
+			InterfaceIndex: 1,
+		},
+		{
+			Network:        net.IPNet{IP: net.IPv4(192, 168, 2, 0), Mask: net.CIDRMask(24, 32)},
+			Ranges:         []pool.Range{{Start: net.IPv4(192, 168, 2, 10), End: net.IPv4(192, 168, 2, 20)}},
+			LeaseTime:      time.Hour,
+			InterfaceIndex: 2,
+		},
+	})
+
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return h
+}
+
+func TestClientDoDORA(t *testing.T) {
+	n := NewNetwork()
+	stop := n.Serve(newTestPool(t))
+	defer stop()
+
+	c1 := NewClient(n.Attach(1, anyClient))
+	c2 := NewClient(n.Attach(2, anyClient))
+
+	l, err := c1.DoDORA(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+	if assert.NoError(t, err) {
+		assert.Equal(t, net.IPv4(192, 168, 1, 10).To4(), l.IP)
+		assert.Equal(t, testServerID.To4(), l.ServerID.To4())
+		assert.Equal(t, time.Hour, l.Duration)
+		assert.Equal(t, dhcpv4.MessageTypeDHCPAck, l.Ack.GetMessageType())
+	}
+
+	l, err = c2.DoDORA(net.HardwareAddr{0, 0, 0, 0, 0, 2})
+	if assert.NoError(t, err) {
+		assert.Equal(t, net.IPv4(192, 168, 2, 10).To4(), l.IP)
+	}
+}
+
+func TestClientReleaseAndInform(t *testing.T) {
+	n := NewNetwork()
+	stop := n.Serve(newTestPool(t))
+	defer stop()
+
+	c := NewClient(n.Attach(1, anyClient))
+	mac := net.HardwareAddr{0, 0, 0, 0, 0, 1}
+
+	l, err := c.DoDORA(mac)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ack, err := c.Inform(mac, l.IP)
+	if assert.NoError(t, err) {
+		_, ok := ack.GetOption(dhcpv4.OptionAddressTime)
+		assert.False(t, ok)
+	}
+
+	assert.NoError(t, c.Release(mac, l))
+
+	// The server handles packets in order, so the released address is
+	// offered to the next client.
+	m, err := c.DoDORA(net.HardwareAddr{0, 0, 0, 0, 0, 2})
+	if assert.NoError(t, err) {
+		assert.Equal(t, l.IP, m.IP)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	n := NewNetwork()
+	stop := n.Serve(dhcpv4.HandlerFunc(func(req dhcpv4.Request) {}))
+	defer stop()
+
+	c := NewClient(n.Attach(1, anyClient))
+	c.Timeout = 10 * time.Millisecond
+
+	_, err := c.DoDORA(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+	assert.Equal(t, ErrTimeout, err)
+}
+
+func TestClientNak(t *testing.T) {
+	n := NewNetwork()
+	stop := n.Serve(dhcpv4.HandlerFunc(func(req dhcpv4.Request) {
+		switch req := req.(type) {
+		case dhcpv4.DHCPDiscover:
+			rep := dhcpv4.CreateDHCPOffer(req)
+			rep.SetYIAddr(net.IPv4(192, 168, 1, 10))
+			rep.SetIP(dhcpv4.OptionDHCPServerID, testServerID)
+			rep.SetDuration(dhcpv4.OptionAddressTime, time.Hour)
+			req.WriteReply(rep)
+		case dhcpv4.DHCPRequest:
+			rep := dhcpv4.CreateDHCPNak(req)
+			rep.SetIP(dhcpv4.OptionDHCPServerID, testServerID)
+			req.WriteReply(rep)
+		}
+	}))
+	defer stop()
+
+	c := NewClient(n.Attach(1, anyClient))
+
+	_, err := c.DoDORA(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+	assert.Equal(t, ErrNak, err)
+}
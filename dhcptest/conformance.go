@@ -0,0 +1,344 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcptest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vmware/godhcpv4"
+)
+
+// Violation describes behavior of a handler that doesn't conform to RFC2131.
+type Violation struct {
+	// The scenario that was run, such as "init-reboot on wrong subnet".
+	Scenario string
+
+	// The section of RFC2131 that describes the expected behavior.
+	Section string
+
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: RFC2131 section %s: %s", v.Scenario, v.Section, v.Message)
+}
+
+// ConformanceConfig describes the network a handler serves.
+type ConformanceConfig struct {
+	// InterfaceIndex is the index of the interface the handler assigns
+	// addresses on. Defaults to 1.
+	InterfaceIndex int
+
+	// RelayAddr is the address of a relay agent the handler serves. If set,
+	// requests are also relayed through this address.
+	RelayAddr net.IP
+
+	// WrongSubnetIP is an address outside of the subnet the handler assigns
+	// addresses from. If set, a client requests it in the INIT-REBOOT state.
+	WrongSubnetIP net.IP
+
+	// Timeout is the time to wait for a reply, and to wait for replies that
+	// should not be sent. Defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// otherServerID is the server identifier of a server other than the one
+// being tested (from the TEST-NET-1 block).
+var otherServerID = net.IPv4(192, 0, 2, 1)
+
+type conformance struct {
+	cfg        ConformanceConfig
+	n          *Network
+	c          *Client
+	violations []Violation
+}
+
+// CheckConformance runs scripted scenarios against handler h on an in-memory
+// network, and returns the ways in which the replies of h don't conform to
+// RFC2131. Every scenario uses a different client hardware address, and
+// releases the addresses it was assigned.
+func CheckConformance(h dhcpv4.Handler, cfg ConformanceConfig) []Violation {
+	if cfg.InterfaceIndex == 0 {
+		cfg.InterfaceIndex = 1
+	}
+
+	ck := conformance{
+		cfg: cfg,
+		n:   NewNetwork(),
+	}
+
+	stop := ck.n.Serve(h)
+	defer stop()
+
+	ck.c = ck.newClient(net.UDPAddr{IP: net.IPv4zero, Port: 68})
+
+	ck.checkSelecting(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	ck.checkOtherServer(net.HardwareAddr{0x02, 0, 0, 0, 0, 2})
+	ck.checkInform(net.HardwareAddr{0x02, 0, 0, 0, 0, 3})
+
+	if cfg.WrongSubnetIP != nil {
+		ck.checkWrongSubnet(net.HardwareAddr{0x02, 0, 0, 0, 0, 4})
+	}
+
+	if cfg.RelayAddr != nil {
+		ck.checkRelayed(net.HardwareAddr{0x02, 0, 0, 0, 0, 5})
+	}
+
+	return ck.violations
+}
+
+func (ck *conformance) newClient(addr net.UDPAddr) *Client {
+	c := NewClient(ck.n.Attach(ck.cfg.InterfaceIndex, addr))
+	c.Timeout = ck.cfg.Timeout
+	return c
+}
+
+func (ck *conformance) fail(scenario, section, format string, args ...interface{}) {
+	ck.violations = append(ck.violations, Violation{
+		Scenario: scenario,
+		Section:  section,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkReply checks the fields that a server copies from request req into
+// reply rep, as listed in table 3, and validates the options of the reply.
+func (ck *conformance) checkReply(scenario string, req dhcpv4.Packet, rep dhcpv4.Packet, validate func() error) {
+	const section = "4.3.1, table 3"
+
+	if dhcpv4.OpCode(rep.Op()[0]) != dhcpv4.BootReply {
+		ck.fail(scenario, section, "'op' is %d, expected BOOTREPLY", rep.Op()[0])
+	}
+
+	// A DHCPNAK to a relayed request has the broadcast bit set (section 4.1)
+	flags := req.GetFlags()
+	if rep.GetMessageType() == dhcpv4.MessageTypeDHCPNak && !req.GetGIAddr().Equal(net.IPv4zero) {
+		flags[0] |= 128
+	}
+
+	// The XID is already matched when receiving the reply
+	fields := []struct {
+		name     string
+		req, rep []byte
+	}{
+		{"flags", flags, rep.GetFlags()},
+		{"giaddr", req.GIAddr(), rep.GIAddr()},
+		{"chaddr", req.CHAddr(), rep.CHAddr()},
+	}
+
+	for _, f := range fields {
+		if !bytes.Equal(f.req, f.rep) {
+			ck.fail(scenario, section, "'%s' is %x, expected %x from the request", f.name, f.rep, f.req)
+		}
+	}
+
+	if err := validate(); err != nil {
+		ck.fail(scenario, section, "invalid %s: %s", rep.GetMessageType(), err)
+	}
+}
+
+// expectReply waits for a reply of type t to request m. It reports a
+// violation if a reply of another type, or no reply, is received.
+func (ck *conformance) expectReply(c *Client, scenario, section string, m message, t dhcpv4.MessageType, sent error) (dhcpv4.Packet, bool) {
+	if sent != nil {
+		ck.fail(scenario, section, "sending request: %s", sent)
+		return dhcpv4.Packet{}, false
+	}
+
+	all := []dhcpv4.MessageType{
+		dhcpv4.MessageTypeDHCPOffer,
+		dhcpv4.MessageTypeDHCPAck,
+		dhcpv4.MessageTypeDHCPNak,
+	}
+
+	p, err := c.Receive(m, all...)
+	if err == ErrTimeout {
+		ck.fail(scenario, section, "no reply, expected %s", t)
+		return p, false
+	}
+
+	if err != nil {
+		ck.fail(scenario, section, "receiving reply: %s", err)
+		return p, false
+	}
+
+	if p.GetMessageType() != t {
+		ck.fail(scenario, section, "received %s, expected %s", p.GetMessageType(), t)
+		return p, false
+	}
+
+	return p, true
+}
+
+// expectSilence reports a violation if a reply to request m is received.
+func (ck *conformance) expectSilence(c *Client, scenario, section string, m message) {
+	p, err := c.Receive(m,
+		dhcpv4.MessageTypeDHCPOffer,
+		dhcpv4.MessageTypeDHCPAck,
+		dhcpv4.MessageTypeDHCPNak)
+
+	if err == nil {
+		ck.fail(scenario, section, "received %s, expected no reply", p.GetMessageType())
+	}
+}
+
+// acquire runs a DHCPDISCOVER/DHCPOFFER/DHCPREQUEST/DHCPACK exchange on c for
+// the client with hardware address mac, and checks the replies. It returns the
+// DHCPACK if the exchange succeeded.
+func (ck *conformance) acquire(c *Client, scenario string, mac net.HardwareAddr, giaddr net.IP) (dhcpv4.Packet, bool) {
+	discover := dhcpv4.CreateDHCPDiscover(mac)
+	if giaddr != nil {
+		discover.SetGIAddr(giaddr)
+	}
+
+	offer, ok := ck.expectReply(c, scenario, "4.3.1", discover, dhcpv4.MessageTypeDHCPOffer, c.Send(discover))
+	if !ok {
+		return offer, false
+	}
+
+	ck.checkReply(scenario, discover.Packet, offer, func() error {
+		rep := dhcpv4.CreateDHCPOffer(discover)
+		rep.Packet = offer
+		return rep.Validate()
+	})
+
+	serverID, ok := offer.GetIP(dhcpv4.OptionDHCPServerID)
+	if !ok {
+		return offer, false
+	}
+
+	req := dhcpv4.CreateDHCPRequestSelecting(mac, offer.GetYIAddr(), serverID)
+	copy(req.XID(), discover.XID())
+	if giaddr != nil {
+		req.SetGIAddr(giaddr)
+	}
+
+	ack, ok := ck.expectReply(c, scenario, "4.3.2", req, dhcpv4.MessageTypeDHCPAck, c.Send(req))
+	if !ok {
+		return ack, false
+	}
+
+	ck.checkReply(scenario, req.Packet, ack, func() error {
+		rep := dhcpv4.CreateDHCPAck(req)
+		rep.Packet = ack
+		return rep.Validate()
+	})
+
+	if !ack.GetYIAddr().Equal(offer.GetYIAddr()) {
+		ck.fail(scenario, "4.3.2", "'yiaddr' is %s, expected %s from the DHCPOFFER",
+			ack.GetYIAddr(), offer.GetYIAddr())
+	}
+
+	return ack, true
+}
+
+// release releases the address in ack.
+func (ck *conformance) release(c *Client, mac net.HardwareAddr, ack dhcpv4.Packet) {
+	serverID, _ := ack.GetIP(dhcpv4.OptionDHCPServerID)
+	c.Release(mac, Lease{IP: ack.GetYIAddr(), ServerID: serverID})
+}
+
+// checkSelecting checks the replies to a client in the SELECTING state.
+func (ck *conformance) checkSelecting(mac net.HardwareAddr) {
+	if ack, ok := ck.acquire(ck.c, "selecting", mac, nil); ok {
+		ck.release(ck.c, mac, ack)
+	}
+}
+
+// checkOtherServer checks that a server doesn't reply to a DHCPREQUEST that
+// selects the offer of another server.
+func (ck *conformance) checkOtherServer(mac net.HardwareAddr) {
+	const scenario = "selecting another server"
+
+	discover, offer, err := ck.c.Discover(mac)
+	if err != nil {
+		ck.fail(scenario, "4.3.1", "no DHCPOFFER in reply to DHCPDISCOVER: %s", err)
+		return
+	}
+
+	req := dhcpv4.CreateDHCPRequestSelecting(mac, offer.GetYIAddr(), otherServerID)
+	copy(req.XID(), discover.XID())
+
+	if err := ck.c.Send(req); err != nil {
+		ck.fail(scenario, "4.3.2", "sending request: %s", err)
+		return
+	}
+
+	ck.expectSilence(ck.c, scenario, "4.3.2", req)
+}
+
+// checkInform checks the reply to a DHCPINFORM from a client with an address
+// it was assigned by the server.
+func (ck *conformance) checkInform(mac net.HardwareAddr) {
+	const scenario = "inform"
+
+	ack, ok := ck.acquire(ck.c, scenario, mac, nil)
+	if !ok {
+		return
+	}
+
+	defer ck.release(ck.c, mac, ack)
+
+	inform := dhcpv4.CreateDHCPInform(mac, ack.GetYIAddr())
+	rep, ok := ck.expectReply(ck.c, scenario, "4.3.5", inform, dhcpv4.MessageTypeDHCPAck, ck.c.Send(inform))
+	if !ok {
+		return
+	}
+
+	ck.checkReply(scenario, inform.Packet, rep, func() error {
+		a := dhcpv4.CreateDHCPAck(inform)
+		a.Packet = rep
+		return a.Validate()
+	})
+
+	if _, ok := rep.GetOption(dhcpv4.OptionAddressTime); ok {
+		ck.fail(scenario, "4.3.5", "DHCPACK includes a lease time")
+	}
+}
+
+// checkWrongSubnet checks that a client in the INIT-REBOOT state that
+// requests an address on another subnet receives a DHCPNAK.
+func (ck *conformance) checkWrongSubnet(mac net.HardwareAddr) {
+	const scenario = "init-reboot on wrong subnet"
+
+	req := dhcpv4.CreateDHCPRequestInitReboot(mac, ck.cfg.WrongSubnetIP)
+	nak, ok := ck.expectReply(ck.c, scenario, "4.3.2", req, dhcpv4.MessageTypeDHCPNak, ck.c.Send(req))
+	if !ok {
+		return
+	}
+
+	ck.checkReply(scenario, req.Packet, nak, func() error {
+		rep := dhcpv4.CreateDHCPNak(req)
+		rep.Packet = nak
+		return rep.Validate()
+	})
+}
+
+// checkRelayed checks the replies to a client whose requests are relayed.
+// Replies are sent to the relay agent rather than to the client.
+func (ck *conformance) checkRelayed(mac net.HardwareAddr) {
+	const scenario = "relayed"
+
+	relay := ck.newClient(net.UDPAddr{IP: ck.cfg.RelayAddr, Port: 67})
+	defer relay.conn.Close()
+
+	if ack, ok := ck.acquire(relay, scenario, mac, ck.cfg.RelayAddr); ok {
+		ck.release(relay, mac, ack)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcptest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/godhcpv4"
+	"github.com/vmware/godhcpv4/pool"
+)
+
+var testConformanceConfig = ConformanceConfig{
+	InterfaceIndex: 1,
+	RelayAddr:      net.IPv4(192, 168, 3, 1),
+	WrongSubnetIP:  net.IPv4(172, 16, 0, 10),
+	Timeout:        50 * time.Millisecond,
+}
+
+func newConformancePool(t *testing.T) *pool.Handler {
+	h, err := pool.NewHandler(testServerID, []pool.Subnet{
+		{
+			Network:        net.IPNet{IP: net.IPv4(192, 168, 1, 0), Mask: net.CIDRMask(24, 32)},
+			Ranges:         []pool.Range{{Start: net.IPv4(192, 168, 1, 10), End: net.IPv4(192, 168, 1, 20)}},
+			LeaseTime:      time.Hour,
+			InterfaceIndex: 1,
+		},
+		{
+			Network:   net.IPNet{IP: net.IPv4(192, 168, 3, 0), Mask: net.CIDRMask(24, 32)},
+			Ranges:    []pool.Range{{Start: net.IPv4(192, 168, 3, 10), End: net.IPv4(192, 168, 3, 20)}},
+			LeaseTime: time.Hour,
+		},
+	})
+
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return h
+}
+
+func violationStrings(vs []Violation) []string {
+	var s []string
+	for _, v := range vs {
+		s = append(s, v.String())
+	}
+
+	return s
+}
+
+func TestCheckConformancePool(t *testing.T) {
+	vs := CheckConformance(newConformancePool(t), testConformanceConfig)
+	assert.Empty(t, violationStrings(vs))
+}
+
+func TestCheckConformanceSilentHandler(t *testing.T) {
+	h := dhcpv4.HandlerFunc(func(req dhcpv4.Request) {})
+
+	vs := CheckConformance(h, testConformanceConfig)
+	assert.Equal(t, []string{
+		"selecting: RFC2131 section 4.3.1: no reply, expected DHCPOFFER",
+		"selecting another server: RFC2131 section 4.3.1: no DHCPOFFER in reply to DHCPDISCOVER: dhcptest: timeout waiting for reply",
+		"inform: RFC2131 section 4.3.1: no reply, expected DHCPOFFER",
+		"init-reboot on wrong subnet: RFC2131 section 4.3.2: no reply, expected DHCPNAK",
+		"relayed: RFC2131 section 4.3.1: no reply, expected DHCPOFFER",
+	}, violationStrings(vs))
+}
+
+func TestCheckConformanceBrokenReplies(t *testing.T) {
+	// Set the broadcast bit and clobber the hardware address in every reply
+	h := dhcpv4.WrapReplyWriter(func(req dhcpv4.Request, rw dhcpv4.ReplyWriter) dhcpv4.ReplyWriter {
+		return dhcpv4.ReplyWriterFunc(func(r dhcpv4.Reply) error {
+			if p, ok := r.(interface {
+				Flags() []byte
+				CHAddr() []byte
+			}); ok {
+				p.Flags()[0] = 128
+				p.CHAddr()[5] = 0xff
+			}
+
+			return rw.WriteReply(r)
+		})
+	})(newConformancePool(t))
+
+	cfg := testConformanceConfig
+	cfg.RelayAddr = nil
+	cfg.WrongSubnetIP = nil
+
+	vs := violationStrings(CheckConformance(h, cfg))
+	assert.Contains(t, vs, "selecting: RFC2131 section 4.3.1, table 3: 'flags' is 8000, expected 0000 from the request")
+	assert.Contains(t, vs, "inform: RFC2131 section 4.3.1, table 3: 'chaddr' is 0200000000ff00000000000000000000, expected 02000000000300000000000000000000 from the request")
+}
+
+func TestCheckConformanceAnswersOtherServer(t *testing.T) {
+	p := newConformancePool(t)
+
+	// Acknowledge requests for any server
+	h := dhcpv4.HandlerFunc(func(req dhcpv4.Request) {
+		if r, ok := req.(dhcpv4.DHCPRequest); ok {
+			if id, ok := r.GetIP(dhcpv4.OptionDHCPServerID); ok && !id.Equal(testServerID) {
+				rep := dhcpv4.CreateDHCPAck(r)
+				rep.SetYIAddr(net.IPv4(192, 168, 1, 99))
+				rep.SetIP(dhcpv4.OptionDHCPServerID, testServerID)
+				rep.SetDuration(dhcpv4.OptionAddressTime, time.Hour)
+				r.WriteReply(rep)
+				return
+			}
+		}
+
+		p.ServeDHCP(req)
+	})
+
+	vs := CheckConformance(h, testConformanceConfig)
+	assert.Equal(t, []string{
+		"selecting another server: RFC2131 section 4.3.2: received DHCPACK, expected no reply",
+	}, violationStrings(vs))
+}
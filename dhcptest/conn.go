@@ -0,0 +1,274 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcptest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/vmware/godhcpv4"
+)
+
+var ErrClosed = errors.New("dhcptest: use of closed connection")
+
+// timeoutError is returned by ReadFrom when the read deadline expires.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "dhcptest: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// queueSize is the number of packets that can wait to be read from a Conn.
+// Like on a real network, packets are dropped when a reader falls behind.
+const queueSize = 64
+
+type packet struct {
+	b       []byte
+	src     net.UDPAddr
+	dst     net.IP
+	ifindex int
+}
+
+// Network is an in-memory network of Conns. Every interface index is a
+// separate broadcast domain, and a Conn is attached to one of them, or to all
+// of them like a server listening on every interface.
+type Network struct {
+	mu    sync.Mutex
+	conns []*Conn
+}
+
+// NewNetwork returns an empty Network.
+func NewNetwork() *Network {
+	return &Network{}
+}
+
+// Attach returns a Conn bound to addr on the broadcast domain with index
+// ifindex. If ifindex is zero, the Conn is attached to every broadcast domain,
+// and ReadFrom returns the index of the domain a packet was sent on.
+func (n *Network) Attach(ifindex int, addr net.UDPAddr) *Conn {
+	c := &Conn{
+		n:       n,
+		ifindex: ifindex,
+		addr:    addr,
+		in:      make(chan packet, queueSize),
+		done:    make(chan struct{}),
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.conns = append(n.conns, c)
+	return c
+}
+
+func (n *Network) detach(c *Conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for i, x := range n.conns {
+		if x == c {
+			n.conns = append(n.conns[:i], n.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+// deliver sends a copy of b to every Conn other than src that is attached to
+// the broadcast domain with index ifindex and is bound to dst. A Conn bound to
+// an unspecified address receives packets sent to any address on its port.
+func (n *Network) deliver(src *Conn, b []byte, dst *net.UDPAddr, ifindex int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, c := range n.conns {
+		if c == src || c.addr.Port != dst.Port {
+			continue
+		}
+
+		if c.ifindex != 0 && c.ifindex != ifindex {
+			continue
+		}
+
+		if !dst.IP.Equal(net.IPv4bcast) && !c.addr.IP.Equal(dst.IP) &&
+			c.addr.IP != nil && !c.addr.IP.IsUnspecified() {
+			continue
+		}
+
+		p := packet{
+			b:       append([]byte(nil), b...),
+			src:     src.addr,
+			dst:     append(net.IP(nil), dst.IP...),
+			ifindex: ifindex,
+		}
+
+		select {
+		case c.in <- p:
+		default:
+		}
+	}
+}
+
+// Serve attaches a Conn bound to port 67 on every broadcast domain, and
+// serves h on it. The returned function stops the server and waits for
+// requests in progress to complete.
+func (n *Network) Serve(h dhcpv4.Handler) (stop func()) {
+	c := n.Attach(0, net.UDPAddr{IP: net.IPv4zero, Port: 67})
+	s := dhcpv4.Server{Handler: h}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Serve(c)
+	}()
+
+	return func() {
+		s.Shutdown(context.Background())
+		<-errc
+		c.Close()
+	}
+}
+
+// Conn is an in-memory dhcpv4.PacketConn attached to a Network.
+type Conn struct {
+	n       *Network
+	ifindex int
+	addr    net.UDPAddr
+	in      chan packet
+
+	mu       sync.Mutex
+	deadline time.Time
+	wake     chan struct{}
+
+	done chan struct{}
+	once sync.Once
+}
+
+// ReadFrom reads the next packet sent to c. The interface index it returns is
+// the index of the broadcast domain the packet was sent on.
+func (c *Conn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	n, addr, _, ifindex, err := c.ReadFromDestination(b)
+	return n, addr, ifindex, err
+}
+
+// ReadFromDestination is like ReadFrom, but also returns the address the
+// packet was sent to.
+func (c *Conn) ReadFromDestination(b []byte) (int, net.Addr, net.IP, int, error) {
+	for {
+		c.mu.Lock()
+		deadline := c.deadline
+		if c.wake == nil {
+			c.wake = make(chan struct{})
+		}
+		wake := c.wake
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, nil, nil, -1, timeoutError{}
+			}
+
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		p, ok, err := c.read(timeout, wake)
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if err != nil {
+			return 0, nil, nil, -1, err
+		}
+
+		if ok {
+			src := p.src
+			return copy(b, p.b), &src, p.dst, p.ifindex, nil
+		}
+	}
+}
+
+// read waits for a packet, the read deadline to expire, or c to be closed. It
+// returns false without an error if the read deadline changed while waiting.
+func (c *Conn) read(timeout <-chan time.Time, wake chan struct{}) (packet, bool, error) {
+	select {
+	case p := <-c.in:
+		return p, true, nil
+	case <-timeout:
+		return packet{}, false, timeoutError{}
+	case <-wake:
+		return packet{}, false, nil
+	case <-c.done:
+		return packet{}, false, ErrClosed
+	}
+}
+
+// WriteTo sends b to addr, which must be a *net.UDPAddr. If c is attached to
+// every broadcast domain, the packet is sent on the domain with index ifindex.
+// Otherwise ifindex is ignored.
+func (c *Conn) WriteTo(b []byte, addr net.Addr, ifindex int) (int, error) {
+	select {
+	case <-c.done:
+		return 0, ErrClosed
+	default:
+	}
+
+	dst, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errors.New("dhcptest: unsupported address type")
+	}
+
+	if c.ifindex != 0 {
+		ifindex = c.ifindex
+	}
+
+	c.n.deliver(c, b, dst, ifindex)
+	return len(b), nil
+}
+
+// SetReadDeadline sets the deadline for calls to ReadFrom. A zero value for t
+// means ReadFrom will not time out.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	if c.wake != nil {
+		close(c.wake)
+		c.wake = nil
+	}
+
+	return nil
+}
+
+// Close detaches c from its network.
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		c.n.detach(c)
+		close(c.done)
+	})
+
+	return nil
+}
+
+// LocalAddr returns the address c is bound to.
+func (c *Conn) LocalAddr() net.Addr {
+	addr := c.addr
+	return &addr
+}
@@ -0,0 +1,152 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcptest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	anyServer = net.UDPAddr{IP: net.IPv4zero, Port: 67}
+	anyClient = net.UDPAddr{IP: net.IPv4zero, Port: 68}
+)
+
+// readAll returns the packets that are ready to be read from c.
+func readAll(c *Conn) []packet {
+	var ps []packet
+	for {
+		select {
+		case p := <-c.in:
+			ps = append(ps, p)
+		default:
+			return ps
+		}
+	}
+}
+
+func TestNetworkBroadcastDomains(t *testing.T) {
+	n := NewNetwork()
+
+	server := n.Attach(0, anyServer)
+	c1 := n.Attach(1, anyClient)
+	c2 := n.Attach(2, anyClient)
+	c2x := n.Attach(2, anyClient)
+
+	// Requests from a client reach the server on the client's domain
+	c2.WriteTo([]byte("request"), &net.UDPAddr{IP: net.IPv4bcast, Port: 67}, 5)
+
+	b := make([]byte, 16)
+	size, addr, ifindex, err := server.ReadFrom(b)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "request", string(b[:size]))
+		assert.Equal(t, &anyClient, addr)
+		assert.Equal(t, 2, ifindex)
+	}
+
+	// Clients don't receive packets for servers, or their own packets
+	assert.Empty(t, readAll(c1))
+	assert.Empty(t, readAll(c2))
+	assert.Empty(t, readAll(c2x))
+
+	// Replies from the server reach the clients on the specified domain
+	server.WriteTo([]byte("reply"), &net.UDPAddr{IP: net.IPv4bcast, Port: 68}, 2)
+
+	assert.Empty(t, readAll(c1))
+	assert.Len(t, readAll(c2), 1)
+	assert.Len(t, readAll(c2x), 1)
+}
+
+func TestNetworkUnicast(t *testing.T) {
+	n := NewNetwork()
+
+	relay := n.Attach(1, net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 67})
+	server := n.Attach(1, net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 67})
+
+	relay.WriteTo([]byte("request"), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 67}, 0)
+
+	ps := readAll(server)
+	if assert.Len(t, ps, 1) {
+		assert.Equal(t, net.IPv4(10, 0, 0, 1), ps[0].src.IP)
+		assert.Equal(t, net.IPv4(10, 0, 0, 2), ps[0].dst)
+	}
+
+	server.WriteTo([]byte("reply"), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 67}, 0)
+	assert.Empty(t, readAll(relay))
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	n := NewNetwork()
+	c := n.Attach(1, anyClient)
+
+	c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, _, err := c.ReadFrom(make([]byte, 16))
+	assert.Equal(t, timeoutError{}, err)
+
+	// Changing the deadline affects a pending read
+	c.SetReadDeadline(time.Time{})
+
+	errc := make(chan error)
+	go func() {
+		_, _, _, err := c.ReadFrom(make([]byte, 16))
+		errc <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	c.SetReadDeadline(time.Unix(1, 0))
+	assert.Equal(t, timeoutError{}, <-errc)
+}
+
+func TestConnClose(t *testing.T) {
+	n := NewNetwork()
+	c := n.Attach(1, anyClient)
+	server := n.Attach(0, anyServer)
+
+	errc := make(chan error)
+	go func() {
+		_, _, _, err := c.ReadFrom(make([]byte, 16))
+		errc <- err
+	}()
+
+	c.Close()
+	assert.Equal(t, ErrClosed, <-errc)
+
+	_, err := c.WriteTo([]byte("request"), &net.UDPAddr{IP: net.IPv4bcast, Port: 67}, 0)
+	assert.Equal(t, ErrClosed, err)
+
+	// A closed conn is detached from the network
+	server.WriteTo([]byte("reply"), &net.UDPAddr{IP: net.IPv4bcast, Port: 68}, 1)
+	assert.Empty(t, readAll(c))
+}
+
+func TestConnReadFromDestination(t *testing.T) {
+	n := NewNetwork()
+	server := n.Attach(0, anyServer)
+	c := n.Attach(1, anyClient)
+
+	for _, ip := range []net.IP{net.IPv4bcast, net.IPv4(10, 0, 0, 1)} {
+		c.WriteTo([]byte("request"), &net.UDPAddr{IP: ip, Port: 67}, 0)
+
+		_, _, dst, ifindex, err := server.ReadFromDestination(make([]byte, 16))
+		if assert.NoError(t, err) {
+			assert.Equal(t, ip, dst)
+			assert.Equal(t, 1, ifindex)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidDomainName = errors.New("dhcpv4: invalid domain name")
+
+// GetDomainSearch gets the list of domain names in the domain search option
+// (RFC3397). Names are encoded as described in RFC1035, section 3.1, and may
+// be compressed with pointers into the option value as described in RFC1035,
+// section 4.1.4. It returns false if the option is not present or malformed.
+func (om OptionMap) GetDomainSearch() ([]string, bool) {
+	v, ok := om.GetOption(OptionDomainSearch)
+	if !ok {
+		return nil, false
+	}
+
+	var names []string
+	for i := 0; i < len(v); {
+		name, n, ok := readDomainName(v, i)
+		if !ok {
+			return nil, false
+		}
+
+		names = append(names, name)
+		i += n
+	}
+
+	return names, true
+}
+
+// readDomainName reads the domain name at offset i in b. It returns the name
+// and the number of bytes it occupies at offset i.
+func readDomainName(b []byte, i int) (string, int, bool) {
+	var labels []string
+	n := 0
+	jumped := false
+
+	// Every jump must go backwards, which guarantees termination.
+	limit := i
+
+	for {
+		if i >= len(b) {
+			return "", 0, false
+		}
+
+		l := int(b[i])
+		switch {
+		case l == 0:
+			if !jumped {
+				n++
+			}
+			return strings.Join(labels, "."), n, true
+		case l&0xc0 == 0xc0:
+			if i+1 >= len(b) {
+				return "", 0, false
+			}
+
+			ptr := (l&0x3f)<<8 | int(b[i+1])
+			if ptr >= limit {
+				return "", 0, false
+			}
+
+			if !jumped {
+				n += 2
+				jumped = true
+			}
+
+			i = ptr
+			limit = ptr
+		case l&0xc0 == 0:
+			if i+1+l > len(b) {
+				return "", 0, false
+			}
+
+			labels = append(labels, string(b[i+1:i+1+l]))
+			if !jumped {
+				n += 1 + l
+			}
+
+			i += 1 + l
+		default:
+			return "", 0, false
+		}
+	}
+}
+
+// SetDomainSearch sets the list of domain names in the domain search option
+// (RFC3397). Suffixes shared between names are compressed. It returns
+// ErrInvalidDomainName, and leaves the option untouched, if a name has an
+// empty label or a label longer than 63 bytes, or if it is longer than 255
+// bytes when encoded (RFC1035, section 2.3.4).
+func (om OptionMap) SetDomainSearch(names []string) error {
+	var b []byte
+
+	// Offsets of the suffixes written so far
+	offsets := make(map[string]int)
+
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+
+		var labels []string
+		if name != "" {
+			labels = strings.Split(name, ".")
+		}
+
+		// Every label is preceded by its length, and the name is
+		// terminated by the empty root label
+		n := 1
+		for _, l := range labels {
+			if len(l) == 0 || len(l) > 63 {
+				return ErrInvalidDomainName
+			}
+
+			n += 1 + len(l)
+		}
+
+		if n > 255 {
+			return ErrInvalidDomainName
+		}
+
+		for i := range labels {
+			suffix := strings.Join(labels[i:], ".")
+			if off, ok := offsets[suffix]; ok {
+				b = append(b, byte(0xc0|off>>8), byte(off))
+				break
+			}
+
+			// Pointers can only address the first 16K of the option
+			if len(b) <= 0x3fff {
+				offsets[suffix] = len(b)
+			}
+
+			b = append(b, byte(len(labels[i])))
+			b = append(b, labels[i]...)
+
+			if i == len(labels)-1 {
+				b = append(b, 0)
+			}
+		}
+
+		if len(labels) == 0 {
+			b = append(b, 0)
+		}
+	}
+
+	om.SetOption(OptionDomainSearch, b)
+	return nil
+}
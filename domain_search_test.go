@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionMapDomainSearch(t *testing.T) {
+	om := make(OptionMap)
+
+	_, ok := om.GetDomainSearch()
+	assert.False(t, ok)
+
+	names := []string{"eng.apple.com", "marketing.apple.com"}
+	assert.NoError(t, om.SetDomainSearch(names))
+
+	// Example from RFC3397, section 3
+	v, _ := om.GetOption(OptionDomainSearch)
+	assert.Equal(t, []byte{
+		3, 'e', 'n', 'g', 5, 'a', 'p', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		9, 'm', 'a', 'r', 'k', 'e', 't', 'i', 'n', 'g', 0xc0, 4,
+	}, v)
+
+	namesX, ok := om.GetDomainSearch()
+	assert.True(t, ok)
+	assert.Equal(t, names, namesX)
+}
+
+func TestOptionMapDomainSearchInvalidNames(t *testing.T) {
+	label := func(n int) string {
+		return strings.Repeat("a", n)
+	}
+
+	// Four labels of 63 bytes make a name of 4*64+1 bytes
+	long := strings.Join([]string{label(63), label(63), label(63), label(63)}, ".")
+
+	testCases := []string{
+		"a..b",
+		".example.com",
+		label(64) + ".com",
+		long,
+	}
+
+	for _, tc := range testCases {
+		om := make(OptionMap)
+		assert.Equal(t, ErrInvalidDomainName, om.SetDomainSearch([]string{"example.com", tc}), tc)
+
+		_, ok := om.GetOption(OptionDomainSearch)
+		assert.False(t, ok)
+	}
+
+	// The longest name that can be encoded
+	om := make(OptionMap)
+	assert.NoError(t, om.SetDomainSearch([]string{long[:len(long)-2]}))
+}
+
+func TestOptionMapDomainSearchMalformed(t *testing.T) {
+	tests := [][]byte{
+		// Truncated label
+		{3, 'c', 'o'},
+		// Missing terminating zero
+		{3, 'c', 'o', 'm'},
+		// Forward pointer
+		{0xc0, 2, 0},
+		// Pointer loop
+		{3, 'c', 'o', 'm', 0xc0, 0},
+		// Reserved label type
+		{0x40, 0},
+	}
+
+	for _, v := range tests {
+		om := make(OptionMap)
+		om.SetOption(OptionDomainSearch, v)
+		_, ok := om.GetDomainSearch()
+		assert.False(t, ok, "%v", v)
+	}
+}
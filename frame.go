@@ -0,0 +1,280 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+var (
+	ErrInvalidFrame    = errors.New("dhcpv4: invalid frame")
+	ErrInvalidChecksum = errors.New("dhcpv4: invalid checksum")
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeVLAN = 0x8100
+
+	ipProtocolUDP = 17
+)
+
+// RawAddr is the address of a peer on a PacketConn that reads and writes
+// Ethernet frames. Next to the peer's IP address and port, it holds its
+// hardware address and the VLAN the frame was tagged with, if any.
+type RawAddr struct {
+	net.UDPAddr
+
+	HardwareAddr net.HardwareAddr
+	VLAN         int
+}
+
+// Network returns the address's network name, "raw".
+func (a *RawAddr) Network() string {
+	return "raw"
+}
+
+// Frame holds the contents of an Ethernet frame carrying a UDP datagram over
+// IPv4, optionally tagged with an 802.1Q VLAN header.
+type Frame struct {
+	Src     RawAddr
+	Dst     RawAddr
+	Payload []byte
+}
+
+// ipChecksum computes the internet checksum of b as defined in RFC1071,
+// starting from the partial sum in sum.
+func ipChecksum(sum uint32, b []byte) uint16 {
+	for ; len(b) >= 2; b = b[2:] {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+	}
+
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
+
+// udpPseudoHeaderSum returns the partial checksum of the IPv4 pseudo header
+// that is included in the UDP checksum (RFC768).
+func udpPseudoHeaderSum(src, dst net.IP, length int) uint32 {
+	var sum uint32
+
+	for _, ip := range []net.IP{src.To4(), dst.To4()} {
+		sum += uint32(ip[0])<<8 | uint32(ip[1])
+		sum += uint32(ip[2])<<8 | uint32(ip[3])
+	}
+
+	return sum + ipProtocolUDP + uint32(length)
+}
+
+// FrameToBytes serializes the frame pointed to by f into its wire-level
+// representation. The frame is tagged if the VLAN of the destination address
+// is set. The IPv4 header and UDP checksums are filled in.
+func FrameToBytes(f Frame) []byte {
+	src := f.Src.IP.To4()
+	if src == nil {
+		src = net.IPv4zero.To4()
+	}
+
+	dst := f.Dst.IP.To4()
+	if dst == nil {
+		dst = net.IPv4zero.To4()
+	}
+
+	b := make([]byte, 0, 14+4+20+8+len(f.Payload))
+
+	// Ethernet header
+	b = append(b, hardwareAddr(f.Dst.HardwareAddr)...)
+	b = append(b, hardwareAddr(f.Src.HardwareAddr)...)
+	if f.Dst.VLAN != 0 {
+		b = append(b, etherTypeVLAN>>8, etherTypeVLAN&0xff)
+		b = append(b, byte(f.Dst.VLAN>>8)&0xf, byte(f.Dst.VLAN))
+	}
+	b = append(b, etherTypeIPv4>>8, etherTypeIPv4&0xff)
+
+	// IPv4 header
+	ip := make([]byte, 20)
+	ip[0] = 0x45 // Version 4, header length of 5 words
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+8+len(f.Payload)))
+	ip[8] = 64 // TTL
+	ip[9] = ipProtocolUDP
+	copy(ip[12:16], src)
+	copy(ip[16:20], dst)
+	binary.BigEndian.PutUint16(ip[10:12], ipChecksum(0, ip))
+	b = append(b, ip...)
+
+	// UDP header and payload
+	udp := make([]byte, 8, 8+len(f.Payload))
+	binary.BigEndian.PutUint16(udp[0:2], uint16(f.Src.Port))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(f.Dst.Port))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(f.Payload)))
+	udp = append(udp, f.Payload...)
+
+	// A computed checksum of zero is transmitted as all ones
+	c := ipChecksum(udpPseudoHeaderSum(src, dst, len(udp)), udp)
+	if c == 0 {
+		c = 0xffff
+	}
+
+	binary.BigEndian.PutUint16(udp[6:8], c)
+
+	return append(b, udp...)
+}
+
+// hardwareAddr returns a as a 6 byte MAC-48 address.
+func hardwareAddr(a net.HardwareAddr) []byte {
+	b := make([]byte, 6)
+	copy(b, a)
+	return b
+}
+
+// FrameFromBytes deserializes the Ethernet frame contained in the []byte b.
+// The function returns an error if the frame does not carry a UDP datagram
+// over IPv4, or if its checksums don't match. The payload of the returned
+// frame points into b.
+func FrameFromBytes(b []byte) (Frame, error) {
+	return frameFromBytes(b, true)
+}
+
+// FrameFromBytesUnverified is like FrameFromBytes, but doesn't verify the UDP
+// checksum. It is meant for frames that were captured before their checksum
+// was filled in, such as frames captured on the host that sends them when
+// checksums are offloaded to the network card.
+func FrameFromBytesUnverified(b []byte) (Frame, error) {
+	return frameFromBytes(b, false)
+}
+
+func frameFromBytes(b []byte, verify bool) (Frame, error) {
+	var f Frame
+
+	// Ethernet header
+	if len(b) < 14 {
+		return f, ErrInvalidFrame
+	}
+
+	f.Dst.HardwareAddr = net.HardwareAddr(b[0:6])
+	f.Src.HardwareAddr = net.HardwareAddr(b[6:12])
+	etherType := binary.BigEndian.Uint16(b[12:14])
+	b = b[14:]
+
+	if etherType == etherTypeVLAN {
+		if len(b) < 4 {
+			return f, ErrInvalidFrame
+		}
+
+		f.Src.VLAN = int(binary.BigEndian.Uint16(b[0:2]) & 0xfff)
+		f.Dst.VLAN = f.Src.VLAN
+		etherType = binary.BigEndian.Uint16(b[2:4])
+		b = b[4:]
+	}
+
+	if etherType != etherTypeIPv4 {
+		return f, ErrInvalidFrame
+	}
+
+	// IPv4 header
+	if len(b) < 20 || b[0]>>4 != 4 {
+		return f, ErrInvalidFrame
+	}
+
+	ihl := int(b[0]&0xf) * 4
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if ihl < 20 || length < ihl || len(b) < length {
+		return f, ErrInvalidFrame
+	}
+
+	// Fragments are not supported
+	if binary.BigEndian.Uint16(b[6:8])&0x3fff != 0 {
+		return f, ErrInvalidFrame
+	}
+
+	if b[9] != ipProtocolUDP {
+		return f, ErrInvalidFrame
+	}
+
+	if ipChecksum(0, b[:ihl]) != 0 {
+		return f, ErrInvalidChecksum
+	}
+
+	f.Src.IP = net.IPv4(b[12], b[13], b[14], b[15])
+	f.Dst.IP = net.IPv4(b[16], b[17], b[18], b[19])
+	b = b[ihl:length]
+
+	// UDP header
+	if len(b) < 8 {
+		return f, ErrInvalidFrame
+	}
+
+	f.Src.Port = int(binary.BigEndian.Uint16(b[0:2]))
+	f.Dst.Port = int(binary.BigEndian.Uint16(b[2:4]))
+	length = int(binary.BigEndian.Uint16(b[4:6]))
+	if length < 8 || len(b) < length {
+		return f, ErrInvalidFrame
+	}
+
+	// A checksum of zero means the sender didn't compute one
+	if verify && binary.BigEndian.Uint16(b[6:8]) != 0 {
+		if ipChecksum(udpPseudoHeaderSum(f.Src.IP, f.Dst.IP, length), b[:length]) != 0 {
+			return f, ErrInvalidChecksum
+		}
+	}
+
+	f.Payload = b[8:length]
+	return f, nil
+}
+
+// PacketToFrame serializes the DHCP packet pointed to by p and wraps it in an
+// Ethernet frame from src to dst.
+func PacketToFrame(p Packet, src, dst RawAddr) ([]byte, error) {
+	b, err := PacketToBytes(p, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	f := Frame{
+		Src:     src,
+		Dst:     dst,
+		Payload: b,
+	}
+
+	return FrameToBytes(f), nil
+}
+
+// PacketFromFrame deserializes the DHCP packet carried by the Ethernet frame
+// contained in the []byte b. Next to the packet, it returns the address of the
+// sender of the frame.
+func PacketFromFrame(b []byte) (Packet, *RawAddr, error) {
+	f, err := FrameFromBytes(b)
+	if err != nil {
+		return Packet{}, nil, err
+	}
+
+	p, err := PacketFromBytes(f.Payload)
+	if err != nil {
+		return Packet{}, nil, err
+	}
+
+	src := f.Src
+	src.HardwareAddr = append(net.HardwareAddr(nil), src.HardwareAddr...)
+	return p, &src, nil
+}
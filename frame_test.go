@@ -0,0 +1,170 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFrame(vlan int) Frame {
+	return Frame{
+		Src: RawAddr{
+			UDPAddr:      net.UDPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 67},
+			HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		},
+		Dst: RawAddr{
+			UDPAddr:      net.UDPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 68},
+			HardwareAddr: net.HardwareAddr{6, 7, 8, 9, 10, 11},
+			VLAN:         vlan,
+		},
+		Payload: []byte("payload"),
+	}
+}
+
+func TestFrameToBytes(t *testing.T) {
+	f := testFrame(0)
+
+	b := FrameToBytes(f)
+	if !assert.Len(t, b, 14+20+8+len(f.Payload)) {
+		return
+	}
+
+	// The IPv4 header checksum verifies
+	assert.Equal(t, uint16(0), ipChecksum(0, b[14:34]))
+
+	g, err := FrameFromBytes(b)
+	if assert.NoError(t, err) {
+		assert.Equal(t, f.Src.HardwareAddr, g.Src.HardwareAddr)
+		assert.Equal(t, f.Dst.HardwareAddr, g.Dst.HardwareAddr)
+		assert.True(t, f.Src.IP.Equal(g.Src.IP))
+		assert.True(t, f.Dst.IP.Equal(g.Dst.IP))
+		assert.Equal(t, 67, g.Src.Port)
+		assert.Equal(t, 68, g.Dst.Port)
+		assert.Equal(t, 0, g.Src.VLAN)
+		assert.Equal(t, f.Payload, g.Payload)
+	}
+}
+
+func TestFrameToBytesVLAN(t *testing.T) {
+	f := testFrame(100)
+
+	b := FrameToBytes(f)
+	if !assert.Len(t, b, 14+4+20+8+len(f.Payload)) {
+		return
+	}
+
+	assert.Equal(t, []byte{0x81, 0x00, 0x00, 100, 0x08, 0x00}, b[12:18])
+
+	g, err := FrameFromBytes(b)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 100, g.Src.VLAN)
+		assert.Equal(t, f.Payload, g.Payload)
+	}
+}
+
+func TestFrameFromBytesInvalid(t *testing.T) {
+	b := FrameToBytes(testFrame(0))
+
+	// Truncated at every possible length
+	for i := 0; i < len(b); i++ {
+		_, err := FrameFromBytes(b[:i])
+		assert.Equal(t, ErrInvalidFrame, err, "%d", i)
+	}
+
+	mutate := func(fn func(c []byte)) []byte {
+		c := make([]byte, len(b))
+		copy(c, b)
+		fn(c)
+		return c
+	}
+
+	tests := [][]byte{
+		// Not IPv4
+		mutate(func(c []byte) { c[12] = 0x86; c[13] = 0xdd }),
+		// Not UDP
+		mutate(func(c []byte) { c[14+9] = 6 }),
+		// Fragment
+		mutate(func(c []byte) { c[14+6] = 0x20 }),
+		// UDP length exceeds IP length
+		mutate(func(c []byte) { c[14+20+4] = 0xff }),
+	}
+
+	for _, c := range tests {
+		_, err := FrameFromBytes(c)
+		assert.Equal(t, ErrInvalidFrame, err)
+	}
+}
+
+func TestFrameFromBytesChecksum(t *testing.T) {
+	b := FrameToBytes(testFrame(0))
+
+	// IPv4 header checksum
+	c := append([]byte(nil), b...)
+	c[14+8]--
+	_, err := FrameFromBytes(c)
+	assert.Equal(t, ErrInvalidChecksum, err)
+
+	// UDP checksum
+	c = append([]byte(nil), b...)
+	c[len(c)-1]++
+	_, err = FrameFromBytes(c)
+	assert.Equal(t, ErrInvalidChecksum, err)
+
+	// No UDP checksum
+	c[14+20+6] = 0
+	c[14+20+7] = 0
+	_, err = FrameFromBytes(c)
+	assert.NoError(t, err)
+}
+
+func TestUDPChecksum(t *testing.T) {
+	// Summing the pseudo header and the datagram, including its checksum,
+	// yields zero
+	f := Frame{
+		Src: RawAddr{UDPAddr: net.UDPAddr{IP: net.IPv4zero, Port: 68}},
+		Dst: RawAddr{UDPAddr: net.UDPAddr{IP: net.IPv4bcast, Port: 67}},
+	}
+
+	b := FrameToBytes(f)
+	sum := udpPseudoHeaderSum(f.Src.IP, f.Dst.IP, 8)
+	assert.Equal(t, uint16(0), ipChecksum(sum, b[14+20:]))
+}
+
+func TestPacketToFromFrame(t *testing.T) {
+	p := CreateDHCPDiscover([]byte{0, 1, 2, 3, 4, 5})
+	f := testFrame(0)
+
+	b, err := PacketToFrame(p.Packet, f.Src, f.Dst)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	q, src, err := PacketFromFrame(b)
+	if assert.NoError(t, err) {
+		assert.Equal(t, p.GetXID(), q.GetXID())
+		assert.Equal(t, MessageTypeDHCPDiscover, q.GetMessageType())
+		assert.Equal(t, f.Src.HardwareAddr, src.HardwareAddr)
+		assert.Equal(t, 67, src.Port)
+	}
+
+	// Not a DHCP packet
+	b = FrameToBytes(f)
+	_, _, err = PacketFromFrame(b)
+	assert.Equal(t, ErrShortPacket, err)
+}
@@ -34,6 +34,14 @@ type PacketWriter interface {
 	WriteTo(b []byte, addr net.Addr, ifindex int) (n int, err error)
 }
 
+// DestinationReader is implemented by PacketConns that can tell the address a
+// packet was sent to, in addition to the values returned by ReadFrom. A Server
+// uses it to record whether a request was broadcast or unicast. The address is
+// nil if it can't be determined for a packet.
+type DestinationReader interface {
+	ReadFromDestination(b []byte) (n int, addr net.Addr, dst net.IP, ifindex int, err error)
+}
+
 // PacketConn groups PacketReader and PacketWriter to form a subset of net.PacketConn.
 type PacketConn interface {
 	PacketReader
@@ -46,9 +54,52 @@ type PacketConn interface {
 type replyWriter struct {
 	pw PacketWriter
 
-	// The client address, if any
-	addr    net.UDPAddr
+	// The address the request came from
+	addr    net.Addr
 	ifindex int
+
+	metrics *Metrics
+}
+
+// replyDestination returns the address a reply should be sent to, as described
+// in RFC2131, section 4.1. A reply is only unicast to the address being
+// assigned to a client if hw is set, meaning the reply can be sent to the
+// hardware address of the client directly. Otherwise the IP stack would have
+// to resolve the address with ARP, which the client can't answer until it is
+// configured.
+func replyDestination(r Reply, hw bool) net.UDPAddr {
+	req := r.Request()
+
+	// Replies to relayed requests are sent to the relay agent
+	if giaddr := req.GetGIAddr(); !giaddr.Equal(net.IPv4zero) {
+		return net.UDPAddr{IP: giaddr, Port: 67}
+	}
+
+	// A DHCPNAK is always broadcast if the request was not relayed
+	if og, ok := r.(OptionGetter); ok && og.GetMessageType() == MessageTypeDHCPNak {
+		return net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+	}
+
+	// Clients that have an address, such as a client that is renewing its
+	// lease or sent a DHCPINFORM, are sent a unicast reply
+	if ciaddr := req.GetCIAddr(); !ciaddr.Equal(net.IPv4zero) {
+		return net.UDPAddr{IP: ciaddr, Port: 68}
+	}
+
+	// Broadcast the reply if the client explicitly asks for a broadcast
+	// reply, if there is no address to send it to, or if it can't be sent to
+	// the hardware address of the client.
+	yiaddr := net.IPv4zero
+	if pg, ok := r.(PacketGetter); ok {
+		yiaddr = pg.GetYIAddr()
+	}
+
+	if !hw || req.GetFlags()[0]&128 > 0 || yiaddr.Equal(net.IPv4zero) {
+		return net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+	}
+
+	// Otherwise the reply is sent to the address being assigned to the client
+	return net.UDPAddr{IP: yiaddr, Port: 68}
 }
 
 func (rw *replyWriter) WriteReply(r Reply) error {
@@ -56,6 +107,7 @@ func (rw *replyWriter) WriteReply(r Reply) error {
 
 	err = r.Validate()
 	if err != nil {
+		rw.metrics.replyInvalid(replyType(r))
 		return err
 	}
 
@@ -64,21 +116,27 @@ func (rw *replyWriter) WriteReply(r Reply) error {
 		return err
 	}
 
-	req := r.Request()
-	addr := rw.addr
-	bcast := req.GetFlags()[0] & 128
+	// On a connection that deals in frames, the reply is sent to the hardware
+	// address the request came from, unless it is broadcast.
+	src, hw := rw.addr.(*RawAddr)
 
-	// Broadcast the reply if the request packet has no address associated with
-	// it, or if the client explicitly asks for a broadcast reply.
-	if addr.IP.Equal(net.IPv4zero) || bcast > 0 {
-		addr.IP = net.IPv4bcast
+	dst := replyDestination(r, hw)
+
+	var addr net.Addr = &dst
+	if hw {
+		addr = &RawAddr{
+			UDPAddr:      dst,
+			HardwareAddr: src.HardwareAddr,
+			VLAN:         src.VLAN,
+		}
 	}
 
-	_, err = rw.pw.WriteTo(bytes, &addr, rw.ifindex)
+	_, err = rw.pw.WriteTo(bytes, addr, rw.ifindex)
 	if err != nil {
 		return err
 	}
 
+	rw.metrics.replyWritten(replyType(r))
 	return nil
 }
 
@@ -90,62 +148,43 @@ func (rw *replyWriter) WriteReply(r Reply) error {
 // blocking, it is not encouraged. Rather, the handler should return as soon as
 // possible to avoid blocking the serve loop. If blocking operations need to be
 // executed to determine if the request packet needs a reply, and if so, what
-// kind of reply, it is recommended to handle this in separate goroutines. The
-// WriteReply function can be called from multiple goroutines without needing
-// extra synchronization.
+// kind of reply, it is recommended to serve requests with a Server that has
+// workers, or to handle this in separate goroutines. The WriteReply function
+// can be called from multiple goroutines without needing extra
+// synchronization.
 type Handler interface {
 	ServeDHCP(req Request)
 }
 
-// Serve reads packets off the network and calls the specified handler.
+// Serve reads packets off the network and calls the specified handler. It is
+// a shorthand for calling Serve on a Server with the specified handler.
 func Serve(pc PacketConn, h Handler) error {
-	buf := make([]byte, 65536)
-
-	for {
-		n, addr, ifindex, err := pc.ReadFrom(buf)
-		if err != nil {
-			return err
-		}
-
-		p, err := PacketFromBytes(buf[:n])
-		if err != nil {
-			continue
-		}
-
-		// Stash interface index in packet structure
-		p.ifindex = ifindex
-
-		// Filter everything but requests
-		if OpCode(p.Op()[0]) != BootRequest {
-			continue
-		}
-
-		rw := replyWriter{
-			pw: pc,
-
-			addr:    *addr.(*net.UDPAddr),
-			ifindex: ifindex,
-		}
+	s := Server{Handler: h}
+	return s.Serve(pc)
+}
 
-		var req Request
-
-		switch p.GetMessageType() {
-		case MessageTypeDHCPDiscover:
-			req = DHCPDiscover{p, &rw}
-		case MessageTypeDHCPRequest:
-			req = DHCPRequest{p, &rw}
-		case MessageTypeDHCPDecline:
-			req = DHCPDecline{p}
-		case MessageTypeDHCPRelease:
-			req = DHCPRelease{p}
-		case MessageTypeDHCPInform:
-			req = DHCPInform{p, &rw}
-		}
+// newRequest wraps packet p in the request type matching its message type. It
+// returns nil if p is not a request, or has an unknown message type.
+func newRequest(p Packet, rw ReplyWriter) Request {
+	// Filter everything but requests
+	if OpCode(p.Op()[0]) != BootRequest {
+		return nil
+	}
 
-		if req != nil {
-			h.ServeDHCP(req)
-		}
+	switch p.GetMessageType() {
+	case MessageTypeDHCPDiscover:
+		return DHCPDiscover{p, rw}
+	case MessageTypeDHCPRequest:
+		return DHCPRequest{p, rw}
+	case MessageTypeDHCPDecline:
+		return DHCPDecline{p}
+	case MessageTypeDHCPRelease:
+		return DHCPRelease{p}
+	case MessageTypeDHCPInform:
+		return DHCPInform{p, rw}
 	}
+
+	return nil
 }
 
 type packetConn struct {
@@ -158,7 +197,7 @@ type packetConn struct {
 // and include the interface index argument in calls to WriteTo.
 func NewPacketConn(pc net.PacketConn) (PacketConn, error) {
 	ipv4pc := ipv4.NewPacketConn(pc)
-	if err := ipv4pc.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+	if err := ipv4pc.SetControlMessage(ipv4.FlagInterface|ipv4.FlagDst, true); err != nil {
 		return nil, err
 	}
 
@@ -174,12 +213,23 @@ func NewPacketConn(pc net.PacketConn) (PacketConn, error) {
 // returns the network interface index the packet arrived on in addition to the
 // default return values of the ReadFrom function.
 func (p *packetConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	n, src, _, ifindex, err := p.ReadFromDestination(b)
+	return n, src, ifindex, err
+}
+
+// ReadFromDestination is like ReadFrom, but also returns the destination
+// address of the packet.
+func (p *packetConn) ReadFromDestination(b []byte) (int, net.Addr, net.IP, int, error) {
 	n, cm, src, err := p.ipv4pc.ReadFrom(b)
 	if err != nil {
-		return n, src, -1, err
+		return n, src, nil, -1, err
+	}
+
+	if cm == nil {
+		return n, src, nil, 0, nil
 	}
 
-	return n, src, cm.IfIndex, err
+	return n, src, cm.Dst, cm.IfIndex, nil
 }
 
 // WriteTo writes a packet with payload b to addr. It explicitly sends the
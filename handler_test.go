@@ -20,6 +20,7 @@ import (
 	"io"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -146,50 +147,158 @@ func TestReplyWriterReturnsSerializationError(t *testing.T) {
 	assert.Equal(t, serializationError, err)
 }
 
-func TestReplyWriterDestinationAddress(t *testing.T) {
-	withBcast := NewPacket(BootRequest)
-	withBcast.Flags()[0] |= 128 // Set MSB
-
-	withoutBcast := NewPacket(BootRequest)
-	withoutBcast.Flags()[0] &= 127 // Clear MSB
-
+func TestReplyDestination(t *testing.T) {
 	zeroIP := net.IP{0, 0, 0, 0}
 	someIP := net.IP{1, 2, 3, 4}
+	relayIP := net.IP{10, 0, 0, 1}
+
+	newRequest := func(bcast bool, ciaddr, giaddr net.IP) Packet {
+		p := NewPacket(BootRequest)
+		if bcast {
+			p.Flags()[0] |= 128 // Set MSB
+		}
+		p.SetCIAddr(ciaddr)
+		p.SetGIAddr(giaddr)
+		return p
+	}
+
+	newOffer := func(req Packet, yiaddr net.IP) Reply {
+		rep := CreateDHCPOffer(req)
+		rep.SetYIAddr(yiaddr)
+		return rep
+	}
+
+	newNak := func(req Packet) Reply {
+		return CreateDHCPNak(req)
+	}
 
 	testCases := []struct {
-		req Packet
-		src net.UDPAddr
-		dst net.IP
+		rep Reply
+		hw  bool
+		dst net.UDPAddr
 	}{
-		// Broadcast flag trumps everything
-		{withBcast, net.UDPAddr{IP: zeroIP}, net.IPv4bcast},
-		{withBcast, net.UDPAddr{IP: someIP}, net.IPv4bcast},
+		// Relayed requests are replied to via the relay agent
+		{newOffer(newRequest(true, zeroIP, relayIP), someIP), false, net.UDPAddr{IP: relayIP, Port: 67}},
+		{newOffer(newRequest(false, someIP, relayIP), someIP), false, net.UDPAddr{IP: relayIP, Port: 67}},
+		{newNak(newRequest(false, someIP, relayIP)), false, net.UDPAddr{IP: relayIP, Port: 67}},
+
+		// A DHCPNAK is always broadcast
+		{newNak(newRequest(false, someIP, zeroIP)), true, net.UDPAddr{IP: net.IPv4bcast, Port: 68}},
+
+		// Clients with an address are sent a unicast
+		{newOffer(newRequest(true, someIP, zeroIP), zeroIP), false, net.UDPAddr{IP: someIP, Port: 68}},
 
-		// Without broadcast flag, only broadcast without a destination IP
-		{withoutBcast, net.UDPAddr{IP: zeroIP}, net.IPv4bcast},
-		{withoutBcast, net.UDPAddr{IP: someIP}, someIP},
+		// Broadcast flag
+		{newOffer(newRequest(true, zeroIP, zeroIP), someIP), true, net.UDPAddr{IP: net.IPv4bcast, Port: 68}},
+
+		// Without broadcast flag, unicast to the assigned address if the
+		// reply can be sent to the hardware address of the client
+		{newOffer(newRequest(false, zeroIP, zeroIP), someIP), true, net.UDPAddr{IP: someIP, Port: 68}},
+		{newOffer(newRequest(false, zeroIP, zeroIP), zeroIP), true, net.UDPAddr{IP: net.IPv4bcast, Port: 68}},
+
+		// Otherwise broadcast, since the client can't answer ARP
+		{newOffer(newRequest(false, zeroIP, zeroIP), someIP), false, net.UDPAddr{IP: net.IPv4bcast, Port: 68}},
 	}
 
-	for _, testCase := range testCases {
-		r := testReply{}
-		r.On("Validate").Return(nil)
-		r.On("ToBytes").Return([]byte("xyz"), nil)
-		r.On("Request").Return(testCase.req)
+	for i, testCase := range testCases {
+		actual := replyDestination(testCase.rep, testCase.hw)
+		assert.True(t, testCase.dst.IP.Equal(actual.IP), "%d: %s", i, actual.IP)
+		assert.Equal(t, testCase.dst.Port, actual.Port, "%d", i)
+	}
+}
 
-		pw := &testPacketConn{}
-		pw.On("WriteTo", mock.Anything, mock.Anything, mock.Anything).Return(3, nil)
+func TestReplyWriterDestinationAddress(t *testing.T) {
+	req := NewPacket(BootRequest)
+	req.SetCIAddr(net.IP{1, 2, 3, 4})
 
-		rw := replyWriter{
-			pw:   pw,
-			addr: testCase.src,
-		}
+	r := testReply{}
+	r.On("Validate").Return(nil)
+	r.On("ToBytes").Return([]byte("xyz"), nil)
+	r.On("Request").Return(req)
+
+	pw := &testPacketConn{}
+	pw.On("WriteTo", mock.Anything, mock.Anything, mock.Anything).Return(3, nil)
+
+	rw := replyWriter{
+		pw:      pw,
+		ifindex: 2,
+	}
 
-		err := rw.WriteReply(&r)
-		assert.NoError(t, err)
+	err := rw.WriteReply(&r)
+	assert.NoError(t, err)
+
+	expected := net.UDPAddr{IP: net.IP{1, 2, 3, 4}, Port: 68}
+	actual := *pw.Calls[0].Arguments[1].(*net.UDPAddr)
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, 2, pw.Calls[0].Arguments[2])
+}
+
+func TestReplyWriterBroadcastsWithoutHardwareAddr(t *testing.T) {
+	req := NewPacket(BootRequest)
+
+	rep := CreateDHCPOffer(req)
+	rep.SetYIAddr(net.IP{10, 0, 0, 2})
+	rep.SetIP(OptionDHCPServerID, net.IP{10, 0, 0, 1})
+	rep.SetDuration(OptionAddressTime, time.Hour)
+
+	pw := &testPacketConn{}
+	pw.On("WriteTo", mock.Anything, mock.Anything, mock.Anything).Return(3, nil)
+
+	// A plain UDP connection can't address the client before it has an
+	// address, so the reply is broadcast even if the flag is clear
+	rw := replyWriter{
+		pw:   pw,
+		addr: &net.UDPAddr{IP: net.IPv4zero, Port: 68},
+	}
+
+	assert.NoError(t, rw.WriteReply(rep))
+	assert.Equal(t, &net.UDPAddr{IP: net.IPv4bcast, Port: 68}, pw.Calls[0].Arguments[1])
+
+	// On a connection that deals in frames it is unicast
+	rw.addr = &RawAddr{
+		UDPAddr:      net.UDPAddr{IP: net.IPv4zero, Port: 68},
+		HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+	}
+
+	assert.NoError(t, rw.WriteReply(rep))
+	if actual, ok := pw.Calls[1].Arguments[1].(*RawAddr); assert.True(t, ok) {
+		assert.Equal(t, net.IP{10, 0, 0, 2}, actual.IP.To4())
+		assert.Equal(t, rw.addr.(*RawAddr).HardwareAddr, actual.HardwareAddr)
+	}
+}
+
+func TestReplyWriterRawAddr(t *testing.T) {
+	req := NewPacket(BootRequest)
+	req.Flags()[0] |= 128
+
+	r := testReply{}
+	r.On("Validate").Return(nil)
+	r.On("ToBytes").Return([]byte("xyz"), nil)
+	r.On("Request").Return(req)
+
+	pw := &testPacketConn{}
+	pw.On("WriteTo", mock.Anything, mock.Anything, mock.Anything).Return(3, nil)
 
-		expected := net.UDPAddr{IP: testCase.dst}
-		actual := *pw.Calls[0].Arguments[1].(*net.UDPAddr)
-		assert.Equal(t, expected, actual)
+	src := &RawAddr{
+		UDPAddr:      net.UDPAddr{IP: net.IPv4zero, Port: 68},
+		HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		VLAN:         100,
+	}
+
+	rw := replyWriter{
+		pw:   pw,
+		addr: src,
+	}
+
+	err := rw.WriteReply(&r)
+	assert.NoError(t, err)
+
+	// The reply goes out on the VLAN the request came in on
+	if actual, ok := pw.Calls[0].Arguments[1].(*RawAddr); assert.True(t, ok) {
+		assert.Equal(t, net.IPv4bcast, actual.IP)
+		assert.Equal(t, 68, actual.Port)
+		assert.Equal(t, src.HardwareAddr, actual.HardwareAddr)
+		assert.Equal(t, 100, actual.VLAN)
 	}
 }
 
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+)
+
+// Lease records the binding of an address to a client.
+type Lease struct {
+	ClientID     []byte
+	HardwareAddr net.HardwareAddr
+
+	IP        net.IP
+	ServerID  net.IP
+	Hostname  string
+	LeaseTime time.Duration
+	Expiry    time.Time
+}
+
+// ClientKey returns the key a client is known by. As per RFC2131 section 4.2,
+// this is the client identifier if the client supplied one, and the hardware
+// address otherwise.
+func ClientKey(clientID []byte, hardwareAddr []byte) string {
+	if clientID != nil {
+		return "id:" + hex.EncodeToString(clientID)
+	}
+
+	return "hw:" + hex.EncodeToString(hardwareAddr)
+}
+
+// ClientKey returns the key of the client holding the lease.
+func (l Lease) ClientKey() string {
+	return ClientKey(l.ClientID, l.HardwareAddr)
+}
+
+// LeaseFromRequest returns a lease populated with the fields a client sends
+// in DHCPREQUEST and DHCPRELEASE messages. The address is taken from the
+// requested IP address option, or from the client address if the option is
+// not present. The expiry is left unset.
+func LeaseFromRequest(req Request) Lease {
+	l := Lease{
+		HardwareAddr: net.HardwareAddr(req.GetCHAddr()),
+	}
+
+	if v, ok := req.GetOption(OptionClientID); ok {
+		l.ClientID = v
+	}
+
+	if v, ok := req.GetIP(OptionAddressRequest); ok {
+		l.IP = v.To4()
+	} else {
+		l.IP = req.GetCIAddr().To4()
+	}
+
+	if v, ok := req.GetIP(OptionDHCPServerID); ok {
+		l.ServerID = v.To4()
+	}
+
+	if v, ok := req.GetString(OptionHostname); ok {
+		l.Hostname = v
+	}
+
+	if v, ok := req.GetDuration(OptionAddressTime); ok {
+		l.LeaseTime = v
+	}
+
+	return l
+}
+
+// LeaseStore defines the interface for storage of leases. Leases are indexed
+// both by the key of the client holding them (see ClientKey) and by their
+// address. A client holds at most one lease, and an address is held by at
+// most one client. Implementations must be safe for concurrent use.
+type LeaseStore interface {
+	// GetByClient returns the lease held by the client with the specified key.
+	GetByClient(key string) (Lease, bool)
+
+	// GetByIP returns the lease for the specified address.
+	GetByIP(ip net.IP) (Lease, bool)
+
+	// Put stores a lease, replacing the lease previously held by the same
+	// client and the lease previously held on the same address.
+	Put(l Lease) error
+
+	// Expire ends the lease for the specified address and removes it from the
+	// store.
+	Expire(ip net.IP) error
+
+	// Iterate calls fn for every lease in the store until fn returns false.
+	Iterate(fn func(l Lease) bool)
+}
+
+// MemoryLeaseStore is a LeaseStore that keeps leases in memory.
+type MemoryLeaseStore struct {
+	mu sync.RWMutex
+
+	clients map[string]Lease
+	addrs   map[string]Lease
+}
+
+// NewMemoryLeaseStore returns an empty MemoryLeaseStore.
+func NewMemoryLeaseStore() *MemoryLeaseStore {
+	return &MemoryLeaseStore{
+		clients: make(map[string]Lease),
+		addrs:   make(map[string]Lease),
+	}
+}
+
+func ipKey(ip net.IP) string {
+	return string(ip.To4())
+}
+
+// GetByClient implements the LeaseStore interface.
+func (s *MemoryLeaseStore) GetByClient(key string) (Lease, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, ok := s.clients[key]
+	return l, ok
+}
+
+// GetByIP implements the LeaseStore interface.
+func (s *MemoryLeaseStore) GetByIP(ip net.IP) (Lease, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, ok := s.addrs[ipKey(ip)]
+	return l, ok
+}
+
+// Put implements the LeaseStore interface.
+func (s *MemoryLeaseStore) Put(l Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(l)
+	return nil
+}
+
+func (s *MemoryLeaseStore) put(l Lease) {
+	if m, ok := s.clients[l.ClientKey()]; ok {
+		s.expire(m.IP)
+	}
+
+	if _, ok := s.addrs[ipKey(l.IP)]; ok {
+		s.expire(l.IP)
+	}
+
+	s.clients[l.ClientKey()] = l
+	s.addrs[ipKey(l.IP)] = l
+}
+
+// Expire implements the LeaseStore interface.
+func (s *MemoryLeaseStore) Expire(ip net.IP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(ip)
+	return nil
+}
+
+func (s *MemoryLeaseStore) expire(ip net.IP) {
+	l, ok := s.addrs[ipKey(ip)]
+	if !ok {
+		return
+	}
+
+	delete(s.addrs, ipKey(ip))
+	delete(s.clients, l.ClientKey())
+}
+
+// Iterate implements the LeaseStore interface. It iterates over a snapshot of
+// the store, so fn may modify the store.
+func (s *MemoryLeaseStore) Iterate(fn func(l Lease) bool) {
+	s.mu.RLock()
+	ls := make([]Lease, 0, len(s.addrs))
+	for _, l := range s.addrs {
+		ls = append(ls, l)
+	}
+	s.mu.RUnlock()
+
+	for _, l := range ls {
+		if !fn(l) {
+			return
+		}
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrCorruptLeaseFile = errors.New("dhcpv4: corrupt lease file")
+
+const (
+	leaseRecordPut    = "put"
+	leaseRecordExpire = "expire"
+)
+
+// leaseRecord is the on-disk representation of a change to the lease store.
+type leaseRecord struct {
+	Op           string    `json:"op"`
+	ClientID     []byte    `json:"client_id,omitempty"`
+	HardwareAddr string    `json:"hardware_addr,omitempty"`
+	IP           net.IP    `json:"ip"`
+	ServerID     net.IP    `json:"server_id,omitempty"`
+	Hostname     string    `json:"hostname,omitempty"`
+	LeaseTime    int64     `json:"lease_time,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+func newPutRecord(l Lease) leaseRecord {
+	r := leaseRecord{
+		Op:        leaseRecordPut,
+		ClientID:  l.ClientID,
+		IP:        l.IP.To4(),
+		ServerID:  l.ServerID.To4(),
+		Hostname:  l.Hostname,
+		LeaseTime: int64(l.LeaseTime / time.Second),
+		Expiry:    l.Expiry,
+	}
+
+	if l.HardwareAddr != nil {
+		r.HardwareAddr = l.HardwareAddr.String()
+	}
+
+	return r
+}
+
+func (r leaseRecord) lease() (Lease, error) {
+	var err error
+
+	l := Lease{
+		ClientID:  r.ClientID,
+		IP:        r.IP.To4(),
+		ServerID:  r.ServerID.To4(),
+		Hostname:  r.Hostname,
+		LeaseTime: time.Duration(r.LeaseTime) * time.Second,
+		Expiry:    r.Expiry,
+	}
+
+	if r.HardwareAddr != "" {
+		if l.HardwareAddr, err = net.ParseMAC(r.HardwareAddr); err != nil {
+			return Lease{}, err
+		}
+	}
+
+	return l, nil
+}
+
+// leaseFile is the subset of *os.File used by FileLeaseStore.
+type leaseFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// FileLeaseStore is a LeaseStore that keeps leases in memory and records every
+// change in an append-only file. Every change is synced to disk before Put or
+// Expire returns. When the file is opened, its records are replayed. A
+// partially written record at the end of the file, as left behind by a crash,
+// is discarded. A record that fails to be written is removed from the file
+// again; if that fails as well, every following Put and Expire returns the
+// error. Compact can be used to rewrite the file so that it only holds the
+// current set of leases.
+type FileLeaseStore struct {
+	*MemoryLeaseStore
+
+	path string
+
+	mu   sync.Mutex
+	f    leaseFile
+	size int64 // End of the last complete record
+	err  error // Set if a partially written record couldn't be removed
+}
+
+// NewFileLeaseStore opens the lease file at the specified path, creating it if
+// it doesn't exist, and returns a FileLeaseStore holding its leases.
+func NewFileLeaseStore(path string) (*FileLeaseStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := FileLeaseStore{
+		MemoryLeaseStore: NewMemoryLeaseStore(),
+		path:             path,
+		f:                f,
+	}
+
+	n, err := s.replay(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// Drop partially written record, if any
+	if err = f.Truncate(n); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	s.size = n
+	return &s, nil
+}
+
+// replay reads the records in the lease file and applies them to the store.
+// It returns the offset of the end of the last complete record.
+func (s *FileLeaseStore) replay(f io.Reader) (int64, error) {
+	var n int64
+
+	r := bufio.NewReader(f)
+	for {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return n, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		var rec leaseRecord
+		if err = json.Unmarshal(b, &rec); err != nil {
+			return 0, ErrCorruptLeaseFile
+		}
+
+		if err = s.apply(rec); err != nil {
+			return 0, err
+		}
+
+		n += int64(len(b))
+	}
+}
+
+func (s *FileLeaseStore) apply(rec leaseRecord) error {
+	switch rec.Op {
+	case leaseRecordPut:
+		l, err := rec.lease()
+		if err != nil {
+			return ErrCorruptLeaseFile
+		}
+
+		s.MemoryLeaseStore.Put(l)
+	case leaseRecordExpire:
+		s.MemoryLeaseStore.Expire(rec.IP)
+	default:
+		return ErrCorruptLeaseFile
+	}
+
+	return nil
+}
+
+func (s *FileLeaseStore) write(rec leaseRecord) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	b = append(b, '\n')
+
+	_, err = s.f.Write(b)
+	if err == nil {
+		err = s.f.Sync()
+	}
+
+	if err != nil {
+		// Don't leave a partial record behind for the next one to be
+		// appended to, which would corrupt the file.
+		if terr := s.f.Truncate(s.size); terr != nil {
+			s.err = terr
+		}
+
+		return err
+	}
+
+	s.size += int64(len(b))
+	return nil
+}
+
+// Put implements the LeaseStore interface.
+func (s *FileLeaseStore) Put(l Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec := newPutRecord(l)
+	if err := s.write(rec); err != nil {
+		return err
+	}
+
+	return s.apply(rec)
+}
+
+// Expire implements the LeaseStore interface.
+func (s *FileLeaseStore) Expire(ip net.IP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec := leaseRecord{
+		Op: leaseRecordExpire,
+		IP: ip.To4(),
+	}
+
+	if err := s.write(rec); err != nil {
+		return err
+	}
+
+	return s.apply(rec)
+}
+
+// Compact rewrites the lease file so that it only holds the current set of
+// leases. The new file is written next to the existing one and atomically
+// renamed over it, so the lease file is intact if Compact is interrupted.
+func (s *FileLeaseStore) Compact() error {
+	var b bytes.Buffer
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enc := json.NewEncoder(&b)
+	s.MemoryLeaseStore.Iterate(func(l Lease) bool {
+		enc.Encode(newPutRecord(l))
+		return true
+	})
+
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(b.Bytes()); err == nil {
+		err = f.Sync()
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, s.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	// Make the rename durable
+	if d, err := os.Open(filepath.Dir(s.path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	s.f.Close()
+	s.f = f
+	s.size = int64(b.Len())
+	s.err = nil
+	return nil
+}
+
+// Close closes the lease file.
+func (s *FileLeaseStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.f.Close()
+}
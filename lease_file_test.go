@@ -0,0 +1,249 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tempLeaseFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "dhcpv4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "leases")
+}
+
+func TestFileLeaseStore(t *testing.T) {
+	path := tempLeaseFile(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	s, err := NewFileLeaseStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testLeaseStore(t, s)
+	assert.NoError(t, s.Close())
+}
+
+func TestFileLeaseStoreReopen(t *testing.T) {
+	path := tempLeaseFile(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	l := Lease{
+		ClientID:     []byte("client"),
+		HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		IP:           net.IP{10, 0, 0, 1},
+		ServerID:     net.IP{10, 0, 0, 254},
+		Hostname:     "host",
+		LeaseTime:    time.Hour,
+		Expiry:       time.Unix(1000000, 0).UTC(),
+	}
+
+	s, err := NewFileLeaseStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, s.Put(l))
+	assert.NoError(t, s.Put(Lease{ClientID: []byte("other"), IP: net.IP{10, 0, 0, 2}}))
+	assert.NoError(t, s.Expire(net.IP{10, 0, 0, 2}))
+	assert.NoError(t, s.Close())
+
+	s, err = NewFileLeaseStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	defer s.Close()
+
+	if m, ok := s.GetByIP(l.IP); assert.True(t, ok) {
+		assert.Equal(t, l, m)
+	}
+
+	_, ok := s.GetByIP(net.IP{10, 0, 0, 2})
+	assert.False(t, ok)
+}
+
+func TestFileLeaseStoreTornWrite(t *testing.T) {
+	path := tempLeaseFile(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	s, err := NewFileLeaseStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, s.Put(Lease{ClientID: []byte("a"), IP: net.IP{10, 0, 0, 1}}))
+	assert.NoError(t, s.Close())
+
+	fi, err := os.Stat(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Simulate a crash halfway through writing a record
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	f.Write([]byte(`{"op":"put","client_id":"Yg==","ip":"10.0.`))
+	f.Close()
+
+	s, err = NewFileLeaseStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	defer s.Close()
+
+	_, ok := s.GetByIP(net.IP{10, 0, 0, 1})
+	assert.True(t, ok)
+
+	// The partial record is discarded
+	fj, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, fi.Size(), fj.Size())
+	}
+}
+
+// shortWriteFile writes half of what it is given and then fails.
+type shortWriteFile struct {
+	leaseFile
+}
+
+func (f shortWriteFile) Write(b []byte) (int, error) {
+	n, _ := f.leaseFile.Write(b[:len(b)/2])
+	return n, errors.New("disk full")
+}
+
+// failTruncateFile is a shortWriteFile that can't be truncated either.
+type failTruncateFile struct {
+	shortWriteFile
+}
+
+func (f failTruncateFile) Truncate(size int64) error {
+	return errors.New("truncate failed")
+}
+
+func TestFileLeaseStoreFailedWrite(t *testing.T) {
+	path := tempLeaseFile(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	s, err := NewFileLeaseStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, s.Put(Lease{ClientID: []byte("a"), IP: net.IP{10, 0, 0, 1}}))
+
+	f := s.f
+	s.f = shortWriteFile{f}
+	assert.Error(t, s.Put(Lease{ClientID: []byte("b"), IP: net.IP{10, 0, 0, 2}}))
+
+	_, ok := s.GetByIP(net.IP{10, 0, 0, 2})
+	assert.False(t, ok)
+
+	// The partial record was removed, so the next one can be appended
+	s.f = f
+	assert.NoError(t, s.Put(Lease{ClientID: []byte("c"), IP: net.IP{10, 0, 0, 3}}))
+
+	// If the partial record can't be removed, later writes fail as well
+	s.f = failTruncateFile{shortWriteFile{f}}
+	assert.Error(t, s.Put(Lease{ClientID: []byte("d"), IP: net.IP{10, 0, 0, 4}}))
+	s.f = f
+	assert.Error(t, s.Put(Lease{ClientID: []byte("e"), IP: net.IP{10, 0, 0, 5}}))
+	assert.NoError(t, s.Close())
+
+	s, err = NewFileLeaseStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	defer s.Close()
+
+	_, ok = s.GetByIP(net.IP{10, 0, 0, 1})
+	assert.True(t, ok)
+	_, ok = s.GetByIP(net.IP{10, 0, 0, 2})
+	assert.False(t, ok)
+	_, ok = s.GetByIP(net.IP{10, 0, 0, 3})
+	assert.True(t, ok)
+}
+
+func TestFileLeaseStoreCorrupt(t *testing.T) {
+	path := tempLeaseFile(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	err := ioutil.WriteFile(path, []byte("garbage\n"), 0644)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = NewFileLeaseStore(path)
+	assert.Equal(t, ErrCorruptLeaseFile, err)
+}
+
+func TestFileLeaseStoreCompact(t *testing.T) {
+	path := tempLeaseFile(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	s, err := NewFileLeaseStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, s.Put(Lease{ClientID: []byte("a"), IP: net.IP{10, 0, 0, byte(i)}}))
+	}
+
+	before, _ := os.Stat(path)
+	assert.NoError(t, s.Compact())
+	after, _ := os.Stat(path)
+	assert.True(t, after.Size() < before.Size())
+
+	// Writes after compaction end up in the new file
+	assert.NoError(t, s.Put(Lease{ClientID: []byte("b"), IP: net.IP{10, 0, 0, 20}}))
+	assert.NoError(t, s.Close())
+
+	s, err = NewFileLeaseStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	defer s.Close()
+
+	n := 0
+	s.Iterate(func(l Lease) bool {
+		n++
+		return true
+	})
+	assert.Equal(t, 2, n)
+
+	if l, ok := s.GetByClient(ClientKey([]byte("a"), nil)); assert.True(t, ok) {
+		assert.Equal(t, net.IP{10, 0, 0, 9}, l.IP)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientKey(t *testing.T) {
+	hw := []byte{0, 1, 2, 3, 4, 5}
+
+	assert.Equal(t, "hw:000102030405", ClientKey(nil, hw))
+	assert.Equal(t, "id:0102", ClientKey([]byte{1, 2}, hw))
+	assert.Equal(t, "id:0102", Lease{ClientID: []byte{1, 2}, HardwareAddr: hw}.ClientKey())
+}
+
+func TestLeaseFromRequest(t *testing.T) {
+	p := NewPacket(BootRequest)
+	p.HLen()[0] = 6
+	copy(p.CHAddr(), []byte{0, 1, 2, 3, 4, 5})
+	p.SetCIAddr(net.IPv4(10, 0, 0, 1))
+
+	l := LeaseFromRequest(p)
+	assert.Equal(t, net.HardwareAddr{0, 1, 2, 3, 4, 5}, l.HardwareAddr)
+	assert.Nil(t, l.ClientID)
+	assert.Equal(t, net.IP{10, 0, 0, 1}, l.IP)
+	assert.Nil(t, l.ServerID)
+
+	p.SetOption(OptionClientID, []byte("client"))
+	p.SetIP(OptionAddressRequest, net.IPv4(10, 0, 0, 2))
+	p.SetIP(OptionDHCPServerID, net.IPv4(10, 0, 0, 254))
+	p.SetString(OptionHostname, "host")
+	p.SetDuration(OptionAddressTime, time.Hour)
+
+	l = LeaseFromRequest(p)
+	assert.Equal(t, []byte("client"), l.ClientID)
+	assert.Equal(t, net.IP{10, 0, 0, 2}, l.IP)
+	assert.Equal(t, net.IP{10, 0, 0, 254}, l.ServerID)
+	assert.Equal(t, "host", l.Hostname)
+	assert.Equal(t, time.Hour, l.LeaseTime)
+}
+
+func testLeaseStore(t *testing.T, s LeaseStore) {
+	a := Lease{
+		HardwareAddr: net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		IP:           net.IP{10, 0, 0, 1},
+	}
+
+	b := Lease{
+		ClientID: []byte("b"),
+		IP:       net.IP{10, 0, 0, 2},
+	}
+
+	assert.NoError(t, s.Put(a))
+	assert.NoError(t, s.Put(b))
+
+	if l, ok := s.GetByClient(a.ClientKey()); assert.True(t, ok) {
+		assert.Equal(t, a, l)
+	}
+
+	if l, ok := s.GetByIP(net.IPv4(10, 0, 0, 2)); assert.True(t, ok) {
+		assert.Equal(t, b, l)
+	}
+
+	// Moving a client to another address drops its previous lease
+	a.IP = net.IP{10, 0, 0, 3}
+	assert.NoError(t, s.Put(a))
+	_, ok := s.GetByIP(net.IP{10, 0, 0, 1})
+	assert.False(t, ok)
+
+	// Handing an address to another client drops that client's lease
+	b.IP = a.IP
+	assert.NoError(t, s.Put(b))
+	_, ok = s.GetByClient(a.ClientKey())
+	assert.False(t, ok)
+
+	n := 0
+	s.Iterate(func(l Lease) bool {
+		assert.Equal(t, b, l)
+		n++
+		return true
+	})
+	assert.Equal(t, 1, n)
+
+	assert.NoError(t, s.Expire(b.IP))
+	_, ok = s.GetByClient(b.ClientKey())
+	assert.False(t, ok)
+	_, ok = s.GetByIP(b.IP)
+	assert.False(t, ok)
+}
+
+func TestMemoryLeaseStore(t *testing.T) {
+	testLeaseStore(t, NewMemoryLeaseStore())
+}
@@ -0,0 +1,268 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the buckets of
+// the handler latency histogram.
+var DefaultLatencyBuckets = []float64{
+	0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5,
+}
+
+type receivedKey struct {
+	typ     MessageType
+	ifindex int
+}
+
+// Metrics collects counters about the packets a Server reads and the replies
+// it writes, as well as a histogram of the time its handler takes. It
+// implements http.Handler to expose them in the Prometheus text format.
+// Metrics can be shared between servers. The zero value is an empty Metrics
+// that uses DefaultLatencyBuckets.
+type Metrics struct {
+	mu sync.Mutex
+
+	received       map[receivedKey]uint64
+	parseErrors    map[string]uint64
+	dropped        map[DropReason]uint64
+	replies        map[MessageType]uint64
+	invalidReplies map[MessageType]uint64
+
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// NewMetrics returns an empty Metrics that uses DefaultLatencyBuckets.
+func NewMetrics() *Metrics {
+	m := &Metrics{}
+	m.lazyInit()
+	return m
+}
+
+// lazyInit allocates the maps and the histogram of a zero value Metrics. It must
+// be called with m.mu held.
+func (m *Metrics) lazyInit() {
+	if m.received != nil {
+		return
+	}
+
+	m.received = make(map[receivedKey]uint64)
+	m.parseErrors = make(map[string]uint64)
+	m.dropped = make(map[DropReason]uint64)
+	m.replies = make(map[MessageType]uint64)
+	m.invalidReplies = make(map[MessageType]uint64)
+
+	m.buckets = DefaultLatencyBuckets
+	m.counts = make([]uint64, len(DefaultLatencyBuckets))
+}
+
+// The functions below are called by the server, and do nothing if m is nil.
+
+func (m *Metrics) packetReceived(typ MessageType, ifindex int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+	m.received[receivedKey{typ, ifindex}]++
+}
+
+func (m *Metrics) parseError(err error) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+	m.parseErrors[err.Error()]++
+}
+
+func (m *Metrics) packetDropped(reason DropReason) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+	m.dropped[reason]++
+}
+
+func (m *Metrics) replyWritten(typ MessageType) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+	m.replies[typ]++
+}
+
+func (m *Metrics) replyInvalid(typ MessageType) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+	m.invalidReplies[typ]++
+}
+
+func (m *Metrics) handled(d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	s := d.Seconds()
+	for i, b := range m.buckets {
+		if s <= b {
+			m.counts[i]++
+		}
+	}
+
+	m.sum += s
+	m.count++
+}
+
+// replyType returns the message type of r, if it has one.
+func replyType(r Reply) MessageType {
+	if og, ok := r.(OptionGetter); ok {
+		return og.GetMessageType()
+	}
+
+	return 0
+}
+
+// escapeLabel escapes a label value as required by the Prometheus text
+// format.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type sample struct {
+	labels string
+	value  uint64
+}
+
+// writeCounter writes a counter with the specified samples, sorted by their
+// labels.
+func writeCounter(w *bytes.Buffer, name, help string, samples []sample) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].labels < samples[j].labels
+	})
+
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, s.labels, s.value)
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	// Don't hold the lock while writing to a slow client
+	var w bytes.Buffer
+	m.render(&w)
+	rw.Write(w.Bytes())
+}
+
+func (m *Metrics) render(w *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	var samples []sample
+
+	for k, v := range m.received {
+		labels := fmt.Sprintf(`interface="%d",type="%s"`, k.ifindex, escapeLabel(k.typ.String()))
+		samples = append(samples, sample{labels, v})
+	}
+
+	writeCounter(w, "dhcpv4_received_packets_total",
+		"Packets received, by message type and interface.", samples)
+
+	samples = samples[:0]
+	for k, v := range m.parseErrors {
+		samples = append(samples, sample{fmt.Sprintf(`error="%s"`, escapeLabel(k)), v})
+	}
+
+	writeCounter(w, "dhcpv4_parse_errors_total",
+		"Packets that could not be parsed, by error.", samples)
+
+	samples = samples[:0]
+	for k, v := range m.dropped {
+		samples = append(samples, sample{fmt.Sprintf(`reason="%s"`, k), v})
+	}
+
+	writeCounter(w, "dhcpv4_dropped_packets_total",
+		"Packets dropped without being handled, by reason.", samples)
+
+	samples = samples[:0]
+	for k, v := range m.replies {
+		samples = append(samples, sample{fmt.Sprintf(`type="%s"`, escapeLabel(k.String())), v})
+	}
+
+	writeCounter(w, "dhcpv4_replies_total",
+		"Replies written, by message type.", samples)
+
+	samples = samples[:0]
+	for k, v := range m.invalidReplies {
+		samples = append(samples, sample{fmt.Sprintf(`type="%s"`, escapeLabel(k.String())), v})
+	}
+
+	writeCounter(w, "dhcpv4_invalid_replies_total",
+		"Replies that failed validation, by message type.", samples)
+
+	name := "dhcpv4_handler_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Time spent in the handler per request.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	for i, b := range m.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(b), m.counts[i])
+	}
+
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, m.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(m.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, m.count)
+}
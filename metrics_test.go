@@ -0,0 +1,200 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServerMetrics(t *testing.T) {
+	chaddr := []byte{0, 1, 2, 3, 4, 5}
+	discover := CreateDHCPDiscover(chaddr)
+
+	// A request with a message type the server doesn't handle
+	unknown := NewRequest(chaddr)
+	unknown.SetMessageType(MessageTypeDHCPOffer)
+
+	offer := CreateDHCPOffer(discover)
+
+	pc := &testPacketConn{}
+	for _, p := range []Packet{discover.Packet, unknown, offer.Packet} {
+		b, err := PacketToBytes(p, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		pc.On("ReadFrom", mock.Anything).Return(b, nil, 2, nil).Once()
+	}
+
+	pc.On("ReadFrom", mock.Anything).Return([]byte{1, 2, 3}, nil, 2, nil).Once()
+	pc.On("ReadFrom", mock.Anything).Return(nil, nil, -1, io.EOF).Once()
+	pc.On("WriteTo", mock.Anything, mock.Anything, mock.Anything).Return(0, nil)
+
+	m := NewMetrics()
+	s := Server{
+		Handler: HandlerFunc(func(req Request) {
+			d := req.(DHCPDiscover)
+
+			// An invalid reply
+			d.WriteReply(CreateDHCPOffer(d))
+
+			o := CreateDHCPOffer(d)
+			o.SetYIAddr(net.IPv4(10, 0, 0, 2))
+			o.SetIP(OptionDHCPServerID, net.IPv4(10, 0, 0, 1))
+			o.SetDuration(OptionAddressTime, time.Hour)
+			assert.NoError(t, d.WriteReply(o))
+		}),
+		Metrics: m,
+	}
+
+	assert.Equal(t, io.EOF, s.Serve(pc))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`# TYPE dhcpv4_received_packets_total counter`,
+		`dhcpv4_received_packets_total{interface="2",type="DHCPDISCOVER"} 1`,
+		`dhcpv4_received_packets_total{interface="2",type="DHCPOFFER"} 2`,
+		`dhcpv4_parse_errors_total{error="dhcpv4: short packet"} 1`,
+		`dhcpv4_dropped_packets_total{reason="malformed"} 1`,
+		`dhcpv4_dropped_packets_total{reason="not_request"} 1`,
+		`dhcpv4_dropped_packets_total{reason="unknown_message_type"} 1`,
+		`dhcpv4_replies_total{type="DHCPOFFER"} 1`,
+		`dhcpv4_invalid_replies_total{type="DHCPOFFER"} 1`,
+		`# TYPE dhcpv4_handler_duration_seconds histogram`,
+		`dhcpv4_handler_duration_seconds_bucket{le="+Inf"} 1`,
+		`dhcpv4_handler_duration_seconds_count 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestMetricsDrop(t *testing.T) {
+	m := NewMetrics()
+	s := Server{Metrics: m}
+
+	s.drop(Drop{Reason: DropQueueFull})
+	assert.Equal(t, uint64(1), m.dropped[DropQueueFull])
+}
+
+func TestMetricsHistogram(t *testing.T) {
+	m := NewMetrics()
+	m.handled(2 * time.Millisecond)
+	m.handled(2 * time.Second)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`dhcpv4_handler_duration_seconds_bucket{le="0.001"} 0`,
+		`dhcpv4_handler_duration_seconds_bucket{le="0.005"} 1`,
+		`dhcpv4_handler_duration_seconds_bucket{le="1"} 1`,
+		`dhcpv4_handler_duration_seconds_bucket{le="5"} 2`,
+		`dhcpv4_handler_duration_seconds_bucket{le="+Inf"} 2`,
+		`dhcpv4_handler_duration_seconds_sum 2.002`,
+		`dhcpv4_handler_duration_seconds_count 2`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestMetricsEscapesLabels(t *testing.T) {
+	m := NewMetrics()
+	m.parseError(errors.New("bad \"value\"\n"))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Contains(t, rec.Body.String(), `dhcpv4_parse_errors_total{error="bad \"value\"\n"} 1`)
+}
+
+func TestMetricsNil(t *testing.T) {
+	var m *Metrics
+
+	assert.NotPanics(t, func() {
+		m.packetReceived(MessageTypeDHCPDiscover, 1)
+		m.parseError(ErrShortPacket)
+		m.packetDropped(DropMalformed)
+		m.replyWritten(MessageTypeDHCPOffer)
+		m.replyInvalid(MessageTypeDHCPOffer)
+		m.handled(time.Second)
+	})
+}
+
+func TestMetricsZeroValue(t *testing.T) {
+	m := &Metrics{}
+
+	assert.NotPanics(t, func() {
+		m.packetReceived(MessageTypeDHCPDiscover, 1)
+		m.parseError(ErrShortPacket)
+		m.packetDropped(DropMalformed)
+		m.replyWritten(MessageTypeDHCPOffer)
+		m.replyInvalid(MessageTypeDHCPOffer)
+		m.handled(time.Second)
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `dhcpv4_replies_total{type="DHCPOFFER"} 1`+"\n")
+	assert.Contains(t, body, `dhcpv4_handler_duration_seconds_bucket{le="1"} 1`+"\n")
+}
+
+// hookResponseWriter calls fn before every write.
+type hookResponseWriter struct {
+	*httptest.ResponseRecorder
+	fn func()
+}
+
+func (rw hookResponseWriter) Write(b []byte) (int, error) {
+	rw.fn()
+	return rw.ResponseRecorder.Write(b)
+}
+
+func TestMetricsServeHTTPUnlocked(t *testing.T) {
+	m := NewMetrics()
+
+	// Updating the metrics while the response is written must not block
+	rw := hookResponseWriter{httptest.NewRecorder(), func() {
+		m.handled(time.Second)
+	}}
+
+	done := make(chan struct{})
+	go func() {
+		m.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ServeHTTP holds the lock while writing")
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"time"
+)
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(req Request)
+
+// ServeDHCP calls f(req).
+func (f HandlerFunc) ServeDHCP(req Request) {
+	f(req)
+}
+
+// ReplyWriterFunc adapts an ordinary function to the ReplyWriter interface.
+type ReplyWriterFunc func(r Reply) error
+
+// WriteReply calls f(r).
+func (f ReplyWriterFunc) WriteReply(r Reply) error {
+	return f(r)
+}
+
+// Middleware wraps a handler to add behavior to it.
+type Middleware func(h Handler) Handler
+
+// Chain wraps handler h in the specified middleware. The first middleware is
+// the outermost one, and sees a request before the others do.
+func Chain(h Handler, m ...Middleware) Handler {
+	for i := len(m) - 1; i >= 0; i-- {
+		h = m[i](h)
+	}
+
+	return h
+}
+
+// WithReplyWriter returns a copy of req of which the reply writer is replaced
+// by the one returned by wrap. Requests that cannot be replied to, such as a
+// DHCPDecline or DHCPRelease, are returned as is.
+func WithReplyWriter(req Request, wrap func(rw ReplyWriter) ReplyWriter) Request {
+	switch r := req.(type) {
+	case DHCPDiscover:
+		r.ReplyWriter = wrap(r.ReplyWriter)
+		return r
+	case DHCPRequest:
+		r.ReplyWriter = wrap(r.ReplyWriter)
+		return r
+	case DHCPInform:
+		r.ReplyWriter = wrap(r.ReplyWriter)
+		return r
+	}
+
+	return req
+}
+
+// WrapReplyWriter returns middleware that replaces the reply writer of every
+// request with the one returned by wrap. It can be used to inspect or modify
+// replies before they are written, or to act on the error of writing them.
+func WrapReplyWriter(wrap func(req Request, rw ReplyWriter) ReplyWriter) Middleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(req Request) {
+			h.ServeDHCP(WithReplyWriter(req, func(rw ReplyWriter) ReplyWriter {
+				return wrap(req, rw)
+			}))
+		})
+	}
+}
+
+// Recover returns middleware that recovers from panics in the handler, and
+// passes the request and the value the handler panicked with to f. The
+// function f may be nil.
+func Recover(f func(req Request, v interface{})) Middleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(req Request) {
+			defer func() {
+				if v := recover(); v != nil && f != nil {
+					f(req, v)
+				}
+			}()
+
+			h.ServeDHCP(req)
+		})
+	}
+}
+
+// Logger returns middleware that logs every request, and every reply that is
+// written in response to it, to l.
+func Logger(l *log.Logger) Middleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(req Request) {
+			l.Printf("%s from %s xid %x on interface %d",
+				req.GetMessageType(),
+				net.HardwareAddr(req.GetCHAddr()),
+				req.GetXID(),
+				req.InterfaceIndex())
+
+			h.ServeDHCP(WithReplyWriter(req, func(rw ReplyWriter) ReplyWriter {
+				return ReplyWriterFunc(func(r Reply) error {
+					err := rw.WriteReply(r)
+
+					typ := replyType(r)
+					if err != nil {
+						l.Printf("%s to %s xid %x: %s",
+							typ, net.HardwareAddr(req.GetCHAddr()), req.GetXID(), err)
+					} else {
+						l.Printf("%s to %s xid %x",
+							typ, net.HardwareAddr(req.GetCHAddr()), req.GetXID())
+					}
+
+					return err
+				})
+			}))
+		})
+	}
+}
+
+// AllowInterfaces returns middleware that drops requests that did not arrive
+// on one of the interfaces with the specified indices.
+func AllowInterfaces(ifindices ...int) Middleware {
+	allowed := make(map[int]bool)
+	for _, i := range ifindices {
+		allowed[i] = true
+	}
+
+	return func(h Handler) Handler {
+		return HandlerFunc(func(req Request) {
+			if allowed[req.InterfaceIndex()] {
+				h.ServeDHCP(req)
+			}
+		})
+	}
+}
+
+// AllowHardwareAddrs returns middleware that drops requests from clients with
+// a hardware address that is not one of the specified addresses.
+func AllowHardwareAddrs(addrs ...net.HardwareAddr) Middleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(req Request) {
+			chaddr := req.GetCHAddr()
+			for _, addr := range addrs {
+				if bytes.Equal(addr, chaddr) {
+					h.ServeDHCP(req)
+					return
+				}
+			}
+		})
+	}
+}
+
+// Timing returns middleware that measures how long the handler takes to
+// handle a request, and passes the request and the duration to f.
+func Timing(f func(req Request, d time.Duration)) Middleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(req Request) {
+			start := time.Now()
+			defer func() {
+				f(req, time.Since(start))
+			}()
+
+			h.ServeDHCP(req)
+		})
+	}
+}
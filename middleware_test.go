@@ -0,0 +1,192 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testMiddlewareRequest(ifindex int) DHCPDiscover {
+	req := CreateDHCPDiscover([]byte{0, 1, 2, 3, 4, 5})
+	req.ifindex = ifindex
+	req.ReplyWriter = ReplyWriterFunc(func(r Reply) error {
+		return nil
+	})
+
+	return req
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+
+	m := func(name string) Middleware {
+		return func(h Handler) Handler {
+			return HandlerFunc(func(req Request) {
+				calls = append(calls, name)
+				h.ServeDHCP(req)
+			})
+		}
+	}
+
+	h := Chain(HandlerFunc(func(req Request) {
+		calls = append(calls, "handler")
+	}), m("a"), m("b"))
+
+	h.ServeDHCP(testMiddlewareRequest(1))
+	assert.Equal(t, []string{"a", "b", "handler"}, calls)
+}
+
+func TestWithReplyWriter(t *testing.T) {
+	var wrapped int
+	wrap := func(rw ReplyWriter) ReplyWriter {
+		wrapped++
+		return rw
+	}
+
+	p := NewRequest([]byte{0, 1, 2, 3, 4, 5})
+	requests := []Request{
+		DHCPDiscover{p, nil},
+		DHCPRequest{p, nil},
+		DHCPInform{p, nil},
+		DHCPDecline{p},
+		DHCPRelease{p},
+	}
+
+	for _, req := range requests {
+		assert.IsType(t, req, WithReplyWriter(req, wrap))
+	}
+
+	assert.Equal(t, 3, wrapped)
+}
+
+func TestWrapReplyWriter(t *testing.T) {
+	var written []net.IP
+
+	m := WrapReplyWriter(func(req Request, rw ReplyWriter) ReplyWriter {
+		return ReplyWriterFunc(func(r Reply) error {
+			r.SetYIAddr(net.IPv4(10, 0, 0, 2))
+			written = append(written, r.(DHCPOffer).GetYIAddr())
+			return rw.WriteReply(r)
+		})
+	})
+
+	h := m(HandlerFunc(func(req Request) {
+		d := req.(DHCPDiscover)
+		o := CreateDHCPOffer(d)
+		o.SetYIAddr(net.IPv4(10, 0, 0, 1))
+		assert.NoError(t, d.WriteReply(o))
+	}))
+
+	h.ServeDHCP(testMiddlewareRequest(1))
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 2).To4()}, written)
+}
+
+func TestRecover(t *testing.T) {
+	var v interface{}
+
+	h := Recover(func(req Request, x interface{}) {
+		v = x
+	})(HandlerFunc(func(req Request) {
+		panic("boom")
+	}))
+
+	assert.NotPanics(t, func() {
+		h.ServeDHCP(testMiddlewareRequest(1))
+	})
+
+	assert.Equal(t, "boom", v)
+
+	// A nil function is allowed
+	h = Recover(nil)(HandlerFunc(func(req Request) {
+		panic("boom")
+	}))
+
+	assert.NotPanics(t, func() {
+		h.ServeDHCP(testMiddlewareRequest(1))
+	})
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := log.New(&buf, "", 0)
+
+	h := Logger(l)(HandlerFunc(func(req Request) {
+		d := req.(DHCPDiscover)
+		d.WriteReply(CreateDHCPOffer(d))
+	}))
+
+	req := testMiddlewareRequest(3)
+	copy(req.XID(), []byte{0xde, 0xad, 0xbe, 0xef})
+	h.ServeDHCP(req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "DHCPDISCOVER from 00:01:02:03:04:05 xid deadbeef on interface 3", lines[0])
+		assert.Equal(t, "DHCPOFFER to 00:01:02:03:04:05 xid deadbeef", lines[1])
+	}
+
+	// Errors are logged
+	buf.Reset()
+	req.ReplyWriter = ReplyWriterFunc(func(r Reply) error {
+		return errors.New("write failed")
+	})
+
+	h.ServeDHCP(req)
+	assert.Contains(t, buf.String(), "DHCPOFFER to 00:01:02:03:04:05 xid deadbeef: write failed")
+}
+
+func TestAllowInterfaces(t *testing.T) {
+	var called int
+	h := AllowInterfaces(1, 2)(HandlerFunc(func(req Request) {
+		called++
+	}))
+
+	h.ServeDHCP(testMiddlewareRequest(1))
+	h.ServeDHCP(testMiddlewareRequest(2))
+	h.ServeDHCP(testMiddlewareRequest(3))
+	assert.Equal(t, 2, called)
+}
+
+func TestAllowHardwareAddrs(t *testing.T) {
+	var called int
+	h := AllowHardwareAddrs(net.HardwareAddr{0, 1, 2, 3, 4, 5})(HandlerFunc(func(req Request) {
+		called++
+	}))
+
+	h.ServeDHCP(testMiddlewareRequest(1))
+	h.ServeDHCP(CreateDHCPDiscover([]byte{0, 1, 2, 3, 4, 6}))
+	assert.Equal(t, 1, called)
+}
+
+func TestTiming(t *testing.T) {
+	var d time.Duration
+	h := Timing(func(req Request, x time.Duration) {
+		d = x
+	})(HandlerFunc(func(req Request) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+
+	h.ServeDHCP(testMiddlewareRequest(1))
+	assert.True(t, d >= 10*time.Millisecond)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"bytes"
+	"net"
+	"sync"
+)
+
+type muxRoute struct {
+	match func(req Request) bool
+	h     Handler
+}
+
+// ServeMux is a handler that routes requests to other handlers. A request is
+// first matched against the routes registered with the Handle* functions, in
+// the order they were registered, and passed to the handler of the first
+// route it matches. Requests that don't match any route are passed to the
+// function registered for their message type with the On* functions, or to
+// the fallback handler if there is no such function.
+//
+// The zero value is an empty ServeMux that drops every request.
+type ServeMux struct {
+	mu sync.RWMutex
+
+	routes   []muxRoute
+	fallback Handler
+
+	discover func(req DHCPDiscover)
+	request  func(req DHCPRequest)
+	decline  func(req DHCPDecline)
+	release  func(req DHCPRelease)
+	inform   func(req DHCPInform)
+}
+
+// NewServeMux returns an empty ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+// OnDiscover registers the function that handles DHCPDISCOVER messages.
+func (m *ServeMux) OnDiscover(f func(req DHCPDiscover)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.discover = f
+}
+
+// OnRequest registers the function that handles DHCPREQUEST messages.
+func (m *ServeMux) OnRequest(f func(req DHCPRequest)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.request = f
+}
+
+// OnDecline registers the function that handles DHCPDECLINE messages.
+func (m *ServeMux) OnDecline(f func(req DHCPDecline)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decline = f
+}
+
+// OnRelease registers the function that handles DHCPRELEASE messages.
+func (m *ServeMux) OnRelease(f func(req DHCPRelease)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.release = f
+}
+
+// OnInform registers the function that handles DHCPINFORM messages.
+func (m *ServeMux) OnInform(f func(req DHCPInform)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inform = f
+}
+
+// Fallback registers the handler for requests that don't match any route,
+// and for which no function is registered for their message type.
+func (m *ServeMux) Fallback(h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fallback = h
+}
+
+// Handle registers handler h for requests for which match returns true.
+func (m *ServeMux) Handle(match func(req Request) bool, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.routes = append(m.routes, muxRoute{match: match, h: h})
+}
+
+// HandleInterface registers handler h for requests that arrived on the
+// interface with the specified index.
+func (m *ServeMux) HandleInterface(ifindex int, h Handler) {
+	m.Handle(func(req Request) bool {
+		return req.InterfaceIndex() == ifindex
+	}, h)
+}
+
+// HandleSubnet registers handler h for requests that were relayed by a relay
+// agent with an address (GIAddr) in the specified subnet.
+func (m *ServeMux) HandleSubnet(subnet net.IPNet, h Handler) {
+	m.Handle(func(req Request) bool {
+		giaddr := req.GetGIAddr()
+		return !giaddr.Equal(net.IPv4zero) && subnet.Contains(giaddr)
+	}, h)
+}
+
+// HandleCircuitID registers handler h for requests that were relayed by a
+// relay agent that added the specified circuit ID to the relay agent
+// information option.
+func (m *ServeMux) HandleCircuitID(id []byte, h Handler) {
+	m.Handle(func(req Request) bool {
+		ri, ok := req.GetRelayAgentInformation()
+		if !ok {
+			return false
+		}
+
+		v, ok := ri.GetCircuitID()
+		return ok && bytes.Equal(v, id)
+	}, h)
+}
+
+// handler returns the handler that req is routed to, or nil if it isn't
+// routed anywhere.
+func (m *ServeMux) handler(req Request) Handler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, r := range m.routes {
+		if r.match(req) {
+			return r.h
+		}
+	}
+
+	switch r := req.(type) {
+	case DHCPDiscover:
+		if f := m.discover; f != nil {
+			return HandlerFunc(func(Request) { f(r) })
+		}
+	case DHCPRequest:
+		if f := m.request; f != nil {
+			return HandlerFunc(func(Request) { f(r) })
+		}
+	case DHCPDecline:
+		if f := m.decline; f != nil {
+			return HandlerFunc(func(Request) { f(r) })
+		}
+	case DHCPRelease:
+		if f := m.release; f != nil {
+			return HandlerFunc(func(Request) { f(r) })
+		}
+	case DHCPInform:
+		if f := m.inform; f != nil {
+			return HandlerFunc(func(Request) { f(r) })
+		}
+	}
+
+	return m.fallback
+}
+
+// ServeDHCP passes req to the handler or function it is routed to.
+func (m *ServeMux) ServeDHCP(req Request) {
+	if h := m.handler(req); h != nil {
+		h.ServeDHCP(req)
+	}
+}
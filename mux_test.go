@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMuxMessageType(t *testing.T) {
+	var calls []string
+
+	m := NewServeMux()
+	m.OnDiscover(func(req DHCPDiscover) { calls = append(calls, "discover") })
+	m.OnRequest(func(req DHCPRequest) { calls = append(calls, "request") })
+	m.OnDecline(func(req DHCPDecline) { calls = append(calls, "decline") })
+	m.OnRelease(func(req DHCPRelease) { calls = append(calls, "release") })
+	m.OnInform(func(req DHCPInform) { calls = append(calls, "inform") })
+
+	chaddr := []byte{0, 1, 2, 3, 4, 5}
+	ip := net.IPv4(10, 0, 0, 1)
+
+	m.ServeDHCP(CreateDHCPDiscover(chaddr))
+	m.ServeDHCP(CreateDHCPRequestRenewing(chaddr, ip))
+	m.ServeDHCP(CreateDHCPDecline(chaddr, ip, ip))
+	m.ServeDHCP(CreateDHCPRelease(chaddr, ip, ip))
+	m.ServeDHCP(CreateDHCPInform(chaddr, ip))
+
+	assert.Equal(t, []string{"discover", "request", "decline", "release", "inform"}, calls)
+}
+
+func TestServeMuxFallback(t *testing.T) {
+	var calls []string
+
+	m := NewServeMux()
+	m.OnDiscover(func(req DHCPDiscover) { calls = append(calls, "discover") })
+
+	// Requests are dropped without a fallback handler
+	m.ServeDHCP(CreateDHCPInform([]byte{0, 1, 2, 3, 4, 5}, net.IPv4(10, 0, 0, 1)))
+
+	m.Fallback(HandlerFunc(func(req Request) { calls = append(calls, "fallback") }))
+	m.ServeDHCP(CreateDHCPDiscover([]byte{0, 1, 2, 3, 4, 5}))
+	m.ServeDHCP(CreateDHCPInform([]byte{0, 1, 2, 3, 4, 5}, net.IPv4(10, 0, 0, 1)))
+
+	assert.Equal(t, []string{"discover", "fallback"}, calls)
+}
+
+func TestServeMuxZeroValue(t *testing.T) {
+	var m ServeMux
+
+	assert.NotPanics(t, func() {
+		m.ServeDHCP(CreateDHCPDiscover([]byte{0, 1, 2, 3, 4, 5}))
+	})
+}
+
+func TestServeMuxRoutes(t *testing.T) {
+	var calls []string
+
+	record := func(name string) Handler {
+		return HandlerFunc(func(req Request) { calls = append(calls, name) })
+	}
+
+	m := NewServeMux()
+	m.HandleCircuitID([]byte("eth0/1"), record("circuit"))
+	m.HandleSubnet(net.IPNet{IP: net.IPv4(10, 1, 0, 0), Mask: net.CIDRMask(16, 32)}, record("subnet"))
+	m.HandleInterface(2, record("interface"))
+	m.OnDiscover(func(req DHCPDiscover) { calls = append(calls, "discover") })
+
+	newDiscover := func(ifindex int, giaddr net.IP, circuitID []byte) DHCPDiscover {
+		req := CreateDHCPDiscover([]byte{0, 1, 2, 3, 4, 5})
+		req.ifindex = ifindex
+		req.SetGIAddr(giaddr)
+
+		if circuitID != nil {
+			ri := make(RelayAgentInformation)
+			ri.SetCircuitID(circuitID)
+			assert.NoError(t, req.SetRelayAgentInformation(ri))
+		}
+
+		return req
+	}
+
+	testCases := []struct {
+		req      Request
+		expected string
+	}{
+		// Matches the circuit ID before the subnet
+		{newDiscover(1, net.IPv4(10, 1, 2, 3), []byte("eth0/1")), "circuit"},
+		{newDiscover(1, net.IPv4(10, 1, 2, 3), []byte("eth0/2")), "subnet"},
+		{newDiscover(2, net.IPv4(10, 2, 2, 3), nil), "interface"},
+		{newDiscover(2, net.IPv4zero, nil), "interface"},
+		{newDiscover(1, net.IPv4zero, nil), "discover"},
+	}
+
+	for _, tc := range testCases {
+		calls = nil
+		m.ServeDHCP(tc.req)
+		assert.Equal(t, []string{tc.expected}, calls)
+	}
+}
+
+func TestServeMuxNoSubnetMatchWithoutGIAddr(t *testing.T) {
+	var called bool
+
+	m := NewServeMux()
+	m.HandleSubnet(net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}, HandlerFunc(func(req Request) {
+		called = true
+	}))
+
+	m.ServeDHCP(CreateDHCPDiscover([]byte{0, 1, 2, 3, 4, 5}))
+	assert.False(t, called)
+}
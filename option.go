@@ -39,6 +39,26 @@ const (
 	MessageTypeDHCPInform   = MessageType(8)
 )
 
+var messageTypeNames = map[MessageType]string{
+	MessageTypeDHCPDiscover: "DHCPDISCOVER",
+	MessageTypeDHCPOffer:    "DHCPOFFER",
+	MessageTypeDHCPRequest:  "DHCPREQUEST",
+	MessageTypeDHCPDecline:  "DHCPDECLINE",
+	MessageTypeDHCPAck:      "DHCPACK",
+	MessageTypeDHCPNak:      "DHCPNAK",
+	MessageTypeDHCPRelease:  "DHCPRELEASE",
+	MessageTypeDHCPInform:   "DHCPINFORM",
+}
+
+// String returns the name of the message type as used in RFC2131.
+func (m MessageType) String() string {
+	if s, ok := messageTypeNames[m]; ok {
+		return s
+	}
+
+	return "MessageType(" + strconv.Itoa(int(m)) + ")"
+}
+
 // OptionGetter defines a bag of functions that can be used to get options.
 type OptionGetter interface {
 	GetOption(Option) ([]byte, bool)
@@ -48,7 +68,12 @@ type OptionGetter interface {
 	GetUint32(Option) (uint32, bool)
 	GetString(Option) (string, bool)
 	GetIP(Option) (net.IP, bool)
+	GetIPs(Option) ([]net.IP, bool)
+	GetOptionList(Option) ([]Option, bool)
 	GetDuration(Option) (time.Duration, bool)
+	GetRelayAgentInformation() (RelayAgentInformation, bool)
+	GetClasslessRoutes() ([]ClasslessRoute, bool)
+	GetDomainSearch() ([]string, bool)
 }
 
 // OptionSetter defines a bag of functions that can be used to set options.
@@ -60,7 +85,12 @@ type OptionSetter interface {
 	SetUint32(Option, uint32)
 	SetString(Option, string)
 	SetIP(Option, net.IP)
+	SetIPs(Option, []net.IP)
+	SetOptionList(Option, []Option)
 	SetDuration(Option, time.Duration)
+	SetRelayAgentInformation(RelayAgentInformation) error
+	SetClasslessRoutes([]ClasslessRoute) error
+	SetDomainSearch([]string) error
 }
 
 // Option is the type for DHCP option tags.
@@ -197,6 +227,57 @@ func (om OptionMap) SetIP(o Option, v net.IP) {
 	om.SetOption(o, []byte(v.To4()))
 }
 
+// GetIPs gets the list of IPs value of an option.
+func (om OptionMap) GetIPs(o Option) ([]net.IP, bool) {
+	v, ok := om.GetOption(o)
+	if !ok || len(v) == 0 || len(v)%4 != 0 {
+		return nil, false
+	}
+
+	ips := make([]net.IP, 0, len(v)/4)
+	for ; len(v) > 0; v = v[4:] {
+		ips = append(ips, net.IPv4(v[0], v[1], v[2], v[3]))
+	}
+
+	return ips, true
+}
+
+// SetIPs sets the list of IPs value of an option.
+func (om OptionMap) SetIPs(o Option, v []net.IP) {
+	b := make([]byte, 0, 4*len(v))
+	for _, ip := range v {
+		b = append(b, ip.To4()...)
+	}
+
+	om.SetOption(o, b)
+}
+
+// GetOptionList gets the list of option tags value of an option, such as the
+// parameter request list.
+func (om OptionMap) GetOptionList(o Option) ([]Option, bool) {
+	v, ok := om.GetOption(o)
+	if !ok {
+		return nil, false
+	}
+
+	l := make([]Option, len(v))
+	for i := range v {
+		l[i] = Option(v[i])
+	}
+
+	return l, true
+}
+
+// SetOptionList sets the list of option tags value of an option.
+func (om OptionMap) SetOptionList(o Option, v []Option) {
+	b := make([]byte, len(v))
+	for i := range v {
+		b[i] = byte(v[i])
+	}
+
+	om.SetOption(o, b)
+}
+
 // GetDuration gets the duration value of an option, stored as a 32 bit unsigned integer.
 func (om OptionMap) GetDuration(o Option) (time.Duration, bool) {
 	if v, ok := om.GetUint32(o); ok {
@@ -248,14 +329,19 @@ func (om OptionMap) Deserialize(x []byte, opts *OptionMapDeserializeOptions) err
 			return ErrShortPacket
 		}
 
-		_, ok := om[tag]
-		if ok {
-			// We've got a bad client here; duplicate options are not allowed.
-			// Let it slide instead of throwing a fit, for the sake of robustness.
+		// From RFC3396: options that appear more than once are concatenated, in
+		// the order in which they appear. Copy the value before appending so the
+		// underlying packet buffer is never written to.
+		if v, ok := om[tag]; ok {
+			w := make([]byte, 0, len(v)+length)
+			w = append(w, v...)
+			w = append(w, x[0:length]...)
+			om[tag] = w
+		} else {
+			om[tag] = x[0:length]
 		}
 
-		// Capture option and move to the next one
-		om[tag] = x[0:length]
+		// Move to the next option
 		x = x[length:]
 	}
 
@@ -267,20 +353,27 @@ func (om OptionMap) Serialize() []byte {
 	b := bytes.Buffer{}
 
 	for k, v := range om {
-		if len(v) > 255 {
-			continue
-		}
+		// Options longer than 255 bytes are split into multiple instances of the
+		// same option (see RFC3396). Empty options are written exactly once.
+		for first := true; first || len(v) > 0; first = false {
+			n := len(v)
+			if n > 255 {
+				n = 255
+			}
 
-		if err := b.WriteByte(byte(k)); err != nil {
-			panic(err)
-		}
+			if err := b.WriteByte(byte(k)); err != nil {
+				panic(err)
+			}
 
-		if err := b.WriteByte(byte(len(v))); err != nil {
-			panic(err)
-		}
+			if err := b.WriteByte(byte(n)); err != nil {
+				panic(err)
+			}
+
+			if _, err := b.Write(v[:n]); err != nil {
+				panic(err)
+			}
 
-		if _, err := b.Write(v); err != nil {
-			panic(err)
+			v = v[n:]
 		}
 	}
 
@@ -181,6 +181,45 @@ func TestOptionMapDurationTruncateSubSecond(t *testing.T) {
 	assert.Equal(t, 100*time.Second, b)
 }
 
+func TestOptionMapDeserializeConcatenates(t *testing.T) {
+	b := []byte{
+		byte(OptionDomainName), 3, 'f', 'o', 'o',
+		byte(OptionRouter), 1, 0x1,
+		byte(OptionDomainName), 4, '.', 'c', 'o', 'm',
+		byte(OptionEnd),
+	}
+
+	om := make(OptionMap)
+	if assert.NoError(t, om.Deserialize(b, nil)) {
+		assert.Equal(t, []byte("foo.com"), om[OptionDomainName])
+		assert.Equal(t, []byte{0x1}, om[OptionRouter])
+	}
+
+	// The source buffer must not be modified
+	assert.Equal(t, byte(OptionRouter), b[5])
+}
+
+func TestOptionMapSerializeSplitsLongOptions(t *testing.T) {
+	v := make([]byte, 300)
+	for i := range v {
+		v[i] = byte(i)
+	}
+
+	om := make(OptionMap)
+	om.SetOption(OptionVendorSpecific, v)
+
+	b := om.Serialize()
+	if assert.Equal(t, 2+255+2+45+1, len(b)) {
+		assert.Equal(t, byte(255), b[1])
+		assert.Equal(t, byte(45), b[258])
+	}
+
+	omX := make(OptionMap)
+	if assert.NoError(t, omX.Deserialize(b, nil)) {
+		assert.Equal(t, om, omX)
+	}
+}
+
 // Keep this function here until we have a generic option getter/setter for any
 // type that the option map supports.
 func encodeInteger(src interface{}) []byte {
@@ -274,3 +313,49 @@ func TestOptionMapDecodeEncodeWithPtr(t *testing.T) {
 	omX.Encode(&s)
 	assert.Equal(t, om, omX)
 }
+
+func TestOptionMapIPs(t *testing.T) {
+	var o = Option(1)
+	var ok bool
+	var a, b []net.IP
+
+	om := make(OptionMap)
+
+	_, ok = om.GetIPs(o)
+	assert.False(t, ok)
+
+	a = []net.IP{net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8)}
+	om.SetIPs(o, a)
+
+	b, ok = om.GetIPs(o)
+	assert.True(t, ok)
+	assert.Equal(t, a, b)
+
+	om.SetOption(o, []byte{1, 2, 3})
+	_, ok = om.GetIPs(o)
+	assert.False(t, ok)
+}
+
+func TestOptionMapOptionList(t *testing.T) {
+	var o = OptionParameterList
+	var ok bool
+	var a, b []Option
+
+	om := make(OptionMap)
+
+	_, ok = om.GetOptionList(o)
+	assert.False(t, ok)
+
+	a = []Option{OptionSubnetMask, OptionRouter, OptionDomainServer}
+	om.SetOptionList(o, a)
+
+	b, ok = om.GetOptionList(o)
+	assert.True(t, ok)
+	assert.Equal(t, a, b)
+}
+
+func TestMessageTypeString(t *testing.T) {
+	assert.Equal(t, "DHCPDISCOVER", MessageTypeDHCPDiscover.String())
+	assert.Equal(t, "DHCPNAK", MessageTypeDHCPNak.String())
+	assert.Equal(t, "MessageType(0)", MessageType(0).String())
+}
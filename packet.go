@@ -16,13 +16,16 @@ limitations under the License.
 package dhcpv4
 
 import (
+	"encoding/binary"
 	"errors"
+	"math/rand"
 	"net"
 )
 
 var (
-	ErrShortPacket   = errors.New("dhcpv4: short packet")
-	ErrInvalidPacket = errors.New("dhcpv4: invalid packet")
+	ErrShortPacket    = errors.New("dhcpv4: short packet")
+	ErrInvalidPacket  = errors.New("dhcpv4: invalid packet")
+	ErrOptionsTooLong = errors.New("dhcpv4: options don't fit in packet")
 )
 
 type OpCode byte
@@ -143,7 +146,7 @@ func (p RawPacket) GetCIAddr() net.IP {
 // The client fills in the 'ciaddr' field only when correctly configured with
 // an IP address in BOUND, RENEWING or REBINDING state.
 func (p RawPacket) SetCIAddr(ip net.IP) {
-	copy(p.CIAddr(), ip)
+	copy(p.CIAddr(), ip.To4())
 }
 
 // GetYIAddr gets the IP address offered or assigned to the client.
@@ -157,7 +160,7 @@ func (p RawPacket) GetYIAddr() net.IP {
 // Each server may respond with a DHCPOFFER message that includes an available
 // network address in the 'yiaddr' field.
 func (p RawPacket) SetYIAddr(ip net.IP) {
-	copy(p.YIAddr(), ip)
+	copy(p.YIAddr(), ip.To4())
 }
 
 // GetSIAddr gets the IP address of the next server to use in bootstrap.
@@ -171,7 +174,7 @@ func (p RawPacket) GetSIAddr() net.IP {
 // field as the address of the server to use in the next step of the client's
 // bootstrap process. Returned in DHCPOFFER, DHCPACK by server.
 func (p RawPacket) SetSIAddr(ip net.IP) {
-	copy(p.SIAddr(), ip)
+	copy(p.SIAddr(), ip.To4())
 }
 
 // GetGIAddr gets the IP address of the relay agent.
@@ -184,7 +187,7 @@ func (p RawPacket) GetGIAddr() net.IP {
 // From RFC2131 section 2: Relay agent IP address, used in booting via a relay
 // agent.
 func (p RawPacket) SetGIAddr(ip net.IP) {
-	copy(p.GIAddr(), ip)
+	copy(p.GIAddr(), ip.To4())
 }
 
 func (p RawPacket) ParseOptions() (OptionMap, error) {
@@ -220,6 +223,13 @@ type Packet struct {
 	OptionMap
 
 	ifindex int
+
+	// The address the packet was sent to, if the server could tell.
+	dst net.IP
+
+	// The error of validating a request, if the server flags invalid
+	// requests.
+	invalid error
 }
 
 // NewPacket creates and returns a new packet with the specified OpCode.
@@ -235,6 +245,24 @@ func NewPacket(o OpCode) Packet {
 	return p
 }
 
+// NewRequest creates and returns a new request packet for the client with the
+// specified hardware address. The transaction ID is chosen at random.
+func NewRequest(chaddr []byte) Packet {
+	req := NewPacket(BootRequest)
+
+	// Hardware type and address length
+	req.HType()[0] = 1 // Ethernet
+	req.HLen()[0] = byte(len(chaddr))
+
+	binary.BigEndian.PutUint32(req.XID(), rand.Uint32())
+	copy(req.CHAddr(), chaddr)
+
+	// The remainder of the fields are set depending on the state of the
+	// client. Once the packet has been filled in, it should be validated before
+	// sending it out on the wire.
+	return req
+}
+
 // NewReply creates and returns a new reply packet given a request.
 func NewReply(req PacketGetter) Packet {
 	rep := NewPacket(BootReply)
@@ -251,6 +279,13 @@ func NewReply(req PacketGetter) Packet {
 	copy(rep.CHAddr(), req.GetCHAddr())
 	copy(rep.GIAddr(), req.GetGIAddr())
 
+	// Echo the relay agent information option (per RFC3046, section 2.2)
+	if og, ok := req.(OptionGetter); ok {
+		if v, ok := og.GetOption(OptionRelayAgentInformation); ok {
+			rep.SetOption(OptionRelayAgentInformation, v)
+		}
+	}
+
 	// The remainder of the fields are set depending on the outcome of the
 	// handler. Once the packet has been filled in, it should be validated before
 	// sending it out on the wire.
@@ -262,6 +297,19 @@ func (p Packet) InterfaceIndex() int {
 	return p.ifindex
 }
 
+// Destination returns the address this packet was sent to, or nil if the
+// PacketConn it was read from can't tell. See DestinationReader.
+func (p Packet) Destination() net.IP {
+	return p.dst
+}
+
+// ValidationError returns the error of validating this packet against
+// RFC2131, table 5, if it was read by a Server that flags invalid requests.
+// Otherwise it returns nil.
+func (p Packet) ValidationError() error {
+	return p.invalid
+}
+
 // PacketFromBytes deserializes the wire-level representation of a DHCP packet
 // contained in the []byte b into a Packet struct. The function returns an
 // error if the packet is malformed. The contents of []byte b is copied into
@@ -291,11 +339,45 @@ type packetToBytesOptions struct {
 	maxLen    uint16
 	skipFile  bool
 	skipSName bool
+
+	// Options that are written before all others, in this order.
+	order []Option
+}
+
+// optionOrder returns the order in which the options in om are written. The
+// options in order come first, followed by the remaining options in numeric
+// order. The relay agent information option is always the last option
+// (RFC3046, section 2.2).
+func optionOrder(om OptionMap, order []Option) []Option {
+	ks := make([]Option, 0, len(om))
+	seen := make(map[Option]bool)
+
+	for _, k := range order {
+		if _, ok := om[k]; ok && !seen[k] && k != OptionRelayAgentInformation {
+			ks = append(ks, k)
+			seen[k] = true
+		}
+	}
+
+	for _, k := range sortedOptions(om) {
+		if !seen[k] && k != OptionRelayAgentInformation {
+			ks = append(ks, k)
+		}
+	}
+
+	if _, ok := om[OptionRelayAgentInformation]; ok {
+		ks = append(ks, OptionRelayAgentInformation)
+	}
+
+	return ks
 }
 
 // PacketToBytes serializes the DHCP packet pointed to by p into its wire-level
 // representation. The function may return an error if it cannot successfully
-// serialize the packet. Otherwise, it returns a newly created byte slice.
+// serialize the packet, for example ErrOptionsTooLong if its mandatory options,
+// or an option that needs to be split, don't fit. Optional options that don't
+// fit are left out, starting with the one that comes last in the order.
+// Otherwise, it returns a newly created byte slice.
 func PacketToBytes(p Packet, opts *packetToBytesOptions) ([]byte, error) {
 	if len(p.RawPacket) < 240 {
 		return nil, ErrInvalidPacket
@@ -309,59 +391,32 @@ func PacketToBytes(p Packet, opts *packetToBytesOptions) ([]byte, error) {
 		maxLen = opts.maxLen
 	}
 
-	// Buffers we can stash options in
-	var b [3][]byte
-
-	// Variable length options field (starting at byte 240)
-	b[0] = make([]byte, 0, maxLen-240)
-
-	// Fixed length "file" field (from byte 108 to byte 236)
-	if opts == nil || !opts.skipFile {
-		b[1] = make([]byte, 0, 236-108)
+	var order []Option
+	if opts != nil {
+		order = opts.order
 	}
 
-	// Fixed length "sname" field (from byte 44 to byte 108)
-	if opts == nil || !opts.skipSName {
-		b[2] = make([]byte, 0, 108-44)
-	}
-
-	// Write options to one of the buffers.
-	// Iterate over options in numeric order.
-	for _, k := range sortedOptions(p.OptionMap) {
-		v := p.OptionMap[k]
-		l := 2 + len(v)
+	// When space runs short, the optional options that come last in the
+	// order are left out, one by one, until the others fit.
+	ks := optionOrder(p.OptionMap, order)
 
-		// TODO(PN): Deal with DHCP options of length > 255
-		// https://www.pivotaltracker.com/story/show/68123382
-		if len(v) > 255 {
-			continue
+	var b [3][]byte
+	for {
+		var ok bool
+		if b, ok = placeOptions(p.OptionMap, ks, maxLen, opts); ok {
+			break
 		}
 
-		for i := range b {
-			cb := cap(b[i])
-			lb := len(b[i])
-			f := cb - lb
-
-			// The first buffer needs to have at least 3 bytes extra for OptionOverload
-			if i == 0 {
-				f -= 3
-			}
-
-			// Every buffer needs to have at least 1 byte extra for OptionEnd
-			f--
-
-			// Check that this buffer has room for this option
-			if f < l {
-				continue
-			}
+		i := len(ks) - 1
+		for i >= 0 && !optionalOption(ks[i], p.OptionMap[ks[i]]) {
+			i--
+		}
 
-			// Write option to buffer
-			b[i] = b[i][:lb+l]
-			b[i][lb+0] = byte(k)
-			b[i][lb+1] = byte(len(v))
-			copy(b[i][lb+2:], v)
-			break
+		if i < 0 {
+			return nil, ErrOptionsTooLong
 		}
+
+		ks = append(ks[:i], ks[i+1:]...)
 	}
 
 	// Add OptionEnd to the buffers that need one
@@ -412,3 +467,118 @@ func PacketToBytes(p Packet, opts *packetToBytesOptions) ([]byte, error) {
 
 	return o, nil
 }
+
+// optionalOption returns whether option k with value v may be left out of a
+// packet when space runs short. Mandatory options may not, and neither may
+// options that need to be split (RFC3396), as they are only written whole.
+func optionalOption(k Option, v []byte) bool {
+	if len(v) > 255 {
+		return false
+	}
+
+	for _, o := range mandatoryOptions {
+		if k == o {
+			return false
+		}
+	}
+
+	return true
+}
+
+// placeOptions writes the options in om with the tags in ks, in this order, to
+// the options field of a packet of at most maxLen bytes, followed by the file
+// and sname fields unless opts says to skip them. Optional options that don't
+// fit are left out. It returns false if another option doesn't fit.
+func placeOptions(om OptionMap, ks []Option, maxLen uint16, opts *packetToBytesOptions) ([3][]byte, bool) {
+	// Buffers we can stash options in
+	var b [3][]byte
+
+	// Variable length options field (starting at byte 240)
+	b[0] = make([]byte, 0, maxLen-240)
+
+	// Fixed length "file" field (from byte 108 to byte 236)
+	if opts == nil || !opts.skipFile {
+		b[1] = make([]byte, 0, 236-108)
+	}
+
+	// Fixed length "sname" field (from byte 44 to byte 108)
+	if opts == nil || !opts.skipSName {
+		b[2] = make([]byte, 0, 108-44)
+	}
+
+	// Number of bytes available for a new option in buffer i.
+	free := func(i int) int {
+		f := cap(b[i]) - len(b[i])
+
+		// The first buffer needs to have at least 3 bytes extra for OptionOverload
+		if i == 0 {
+			f -= 3
+		}
+
+		// Every buffer needs to have at least 1 byte extra for OptionEnd
+		f--
+
+		return f
+	}
+
+	// Write option with tag k and value v to buffer i.
+	write := func(i int, k Option, v []byte) {
+		lb := len(b[i])
+		b[i] = b[i][:lb+2+len(v)]
+		b[i][lb+0] = byte(k)
+		b[i][lb+1] = byte(len(v))
+		copy(b[i][lb+2:], v)
+	}
+
+	for _, k := range ks {
+		v := om[k]
+
+		if len(v) <= 255 {
+			// Write option to the first buffer that has room for it
+			written := false
+			for i := range b {
+				if free(i) >= 2+len(v) {
+					write(i, k, v)
+					written = true
+					break
+				}
+			}
+
+			if !written && !optionalOption(k, v) {
+				return b, false
+			}
+
+			continue
+		}
+
+		// From RFC3396: options longer than 255 bytes are split into multiple
+		// instances of the same option. The receiver concatenates these in the
+		// order they appear in the options, file and sname fields, so fragments
+		// are written to the buffers in that same order.
+		for i := range b {
+			for len(v) > 0 {
+				n := free(i) - 2
+				if n < 1 {
+					break
+				}
+
+				if n > 255 {
+					n = 255
+				}
+
+				if n > len(v) {
+					n = len(v)
+				}
+
+				write(i, k, v[:n])
+				v = v[n:]
+			}
+		}
+
+		if len(v) > 0 {
+			return b, false
+		}
+	}
+
+	return b, true
+}
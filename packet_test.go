@@ -16,6 +16,7 @@ limitations under the License.
 package dhcpv4
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -179,6 +180,25 @@ func TestPacketFromBytes(t *testing.T) {
 	}
 }
 
+func TestPacketFromBytesConcatenatesOptions(t *testing.T) {
+	var p *testPacket
+
+	// Fabricate packet with an option split over options, `file` and `sname`
+	p = new(testPacket)
+	p.appendToOption(OptionOverload, []byte{0x3})
+	p.appendToOption(OptionClasslessStaticRouteOption, []byte{0x12})
+	p.appendToOption(OptionEnd, nil)
+	p.appendToFile(OptionClasslessStaticRouteOption, []byte{0x34})
+	p.appendToFile(OptionEnd, nil)
+	p.appendToSName(OptionClasslessStaticRouteOption, []byte{0x56})
+	p.appendToSName(OptionEnd, nil)
+	if pckt, err := fromBytes(t, p.buf); assert.Nil(t, err) {
+		o := pckt.OptionMap
+		assert.Equal(t, 2, len(o))
+		assertOption(t, o, OptionClasslessStaticRouteOption, []byte{0x12, 0x34, 0x56})
+	}
+}
+
 func assertEqualOptionMaps(t *testing.T, o1, o2 OptionMap) bool {
 	compare := func(o1, o2 OptionMap) bool {
 		for k, v1 := range o1 {
@@ -268,3 +288,206 @@ func TestPacketToBytes(t *testing.T) {
 		}
 	}
 }
+
+func TestPacketToBytesLongOption(t *testing.T) {
+	v := make([]byte, 600)
+	for i := range v {
+		v[i] = byte(i)
+	}
+
+	p := NewPacket(BootRequest)
+	p.SetOption(OptionVendorSpecific, v)
+
+	b, err := PacketToBytes(p, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// The option is split in instances of 255, 255 and 90 bytes
+	q := RawPacket(b)
+	assert.Equal(t, byte(OptionVendorSpecific), q.Options()[0])
+	assert.Equal(t, byte(255), q.Options()[1])
+	assert.Equal(t, byte(OptionVendorSpecific), q.Options()[257])
+	assert.Equal(t, byte(255), q.Options()[258])
+	assert.Equal(t, byte(OptionVendorSpecific), q.Options()[514])
+	assert.Equal(t, byte(90), q.Options()[515])
+
+	if r, err := PacketFromBytes(b); assert.Nil(t, err) {
+		assertOption(t, r.OptionMap, OptionVendorSpecific, v)
+	}
+}
+
+func TestPacketToBytesLongOptionOverload(t *testing.T) {
+	// The options field of a 577 byte packet has room for 577 - 240 - 3 - 1 =
+	// 333 bytes, so this option needs to continue in the `file` and `sname`
+	// fields.
+	v := make([]byte, 500)
+	for i := range v {
+		v[i] = byte(i)
+	}
+
+	p := NewPacket(BootRequest)
+	p.SetOption(OptionClasslessStaticRouteOption, v)
+
+	b, err := PacketToBytes(p, &packetToBytesOptions{maxLen: 577})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.True(t, len(b) <= 577)
+
+	if r, err := PacketFromBytes(b); assert.Nil(t, err) {
+		assertOption(t, r.OptionMap, OptionOverload, []byte{0x3})
+		assertOption(t, r.OptionMap, OptionClasslessStaticRouteOption, v)
+	}
+}
+
+func TestPacketToBytesLongOptionTooLong(t *testing.T) {
+	p := NewPacket(BootRequest)
+	p.SetOption(OptionClasslessStaticRouteOption, make([]byte, 2000))
+	p.SetOption(OptionDomainName, []byte("example.com"))
+
+	_, err := PacketToBytes(p, nil)
+	assert.Equal(t, ErrOptionsTooLong, err)
+}
+
+func TestPacketToBytesMandatoryOptionTooLong(t *testing.T) {
+	p := NewPacket(BootReply)
+	p.SetOption(OptionDomainName, make([]byte, 200))
+	p.SetOption(OptionDHCPMessage, make([]byte, 200))
+
+	opts := packetToBytesOptions{
+		maxLen:    577,
+		skipFile:  true,
+		skipSName: true,
+	}
+
+	// The optional option comes first, but is left out to make room for the
+	// mandatory one
+	b, err := PacketToBytes(p, &opts)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	if r, err := PacketFromBytes(b); assert.Nil(t, err) {
+		_, ok := r.GetOption(OptionDomainName)
+		assert.False(t, ok)
+		_, ok = r.GetOption(OptionDHCPMessage)
+		assert.True(t, ok)
+	}
+
+	// Mandatory options that don't fit are an error
+	p.SetOption(OptionRelayAgentInformation, make([]byte, 200))
+
+	_, err = PacketToBytes(p, &opts)
+	assert.Equal(t, ErrOptionsTooLong, err)
+}
+
+func TestPacketSetAddrTo4(t *testing.T) {
+	p := NewPacket(BootReply)
+	ip := net.IPv4(1, 2, 3, 4)
+
+	p.SetCIAddr(ip)
+	p.SetYIAddr(ip)
+	p.SetSIAddr(ip)
+	p.SetGIAddr(ip)
+
+	expected := net.IP{1, 2, 3, 4}
+	assert.Equal(t, expected, p.GetCIAddr())
+	assert.Equal(t, expected, p.GetYIAddr())
+	assert.Equal(t, expected, p.GetSIAddr())
+	assert.Equal(t, expected, p.GetGIAddr())
+}
+
+func TestNewRequest(t *testing.T) {
+	chaddr := []byte{0, 1, 2, 3, 4, 5}
+
+	p := NewRequest(chaddr)
+	assert.Equal(t, BootRequest, OpCode(p.Op()[0]))
+	assert.Equal(t, uint8(1), p.GetHType())
+	assert.Equal(t, uint8(6), p.GetHLen())
+	assert.Equal(t, chaddr, p.GetCHAddr())
+
+	q := NewRequest(chaddr)
+	assert.NotEqual(t, p.GetXID(), q.GetXID())
+}
+
+func TestNewReplyEchoesRelayAgentInformation(t *testing.T) {
+	v := []byte{byte(RelayAgentCircuitID), 2, 'e', '1'}
+
+	req := NewPacket(BootRequest)
+	req.SetOption(OptionRelayAgentInformation, v)
+
+	rep := NewReply(req)
+	assertOption(t, rep.OptionMap, OptionRelayAgentInformation, v)
+}
+
+func TestPacketToBytesRelayAgentInformationLast(t *testing.T) {
+	p := NewPacket(BootReply)
+	p.SetMessageType(MessageTypeDHCPAck)
+	p.SetOption(OptionRelayAgentInformation, []byte{byte(RelayAgentRemoteID), 1, 'x'})
+	p.SetOption(OptionClasslessStaticRouteOption, []byte{0, 1, 2, 3, 4})
+
+	b, err := PacketToBytes(p, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Options are in numeric order, with option 82 last, followed by OptionEnd
+	o := RawPacket(b).Options()
+	assert.Equal(t, byte(OptionDHCPMsgType), o[0])
+	assert.Equal(t, byte(OptionClasslessStaticRouteOption), o[3])
+	assert.Equal(t, byte(OptionRelayAgentInformation), o[10])
+	assert.Equal(t, byte(OptionEnd), o[15])
+}
+
+func TestPacketToBytesOptionOrder(t *testing.T) {
+	p := NewPacket(BootReply)
+	p.SetMessageType(MessageTypeDHCPAck)
+	p.SetIP(OptionRouter, net.IPv4(10, 0, 0, 1))
+	p.SetString(OptionDomainName, "example.com")
+
+	opts := packetToBytesOptions{
+		order: []Option{OptionDHCPMsgType, OptionDomainName},
+	}
+
+	b, err := PacketToBytes(p, &opts)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Options in the order come first, followed by the others in numeric order
+	o := RawPacket(b).Options()
+	assert.Equal(t, byte(OptionDHCPMsgType), o[0])
+	assert.Equal(t, byte(OptionDomainName), o[3])
+	assert.Equal(t, byte(OptionRouter), o[16])
+}
+
+func TestPacketToBytesOptionOrderSpaceShort(t *testing.T) {
+	p := NewPacket(BootReply)
+	p.SetOption(Option(200), make([]byte, 150))
+	p.SetOption(Option(201), make([]byte, 150))
+	p.SetOption(Option(202), make([]byte, 150))
+
+	// Without sname and file, a 577 byte packet has room for two of these
+	opts := packetToBytesOptions{
+		maxLen:    577,
+		skipFile:  true,
+		skipSName: true,
+		order:     []Option{Option(202), Option(200)},
+	}
+
+	b, err := PacketToBytes(p, &opts)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	if r, err := PacketFromBytes(b); assert.Nil(t, err) {
+		_, ok := r.GetOption(Option(201))
+		assert.False(t, ok)
+		_, ok = r.GetOption(Option(200))
+		assert.True(t, ok)
+		_, ok = r.GetOption(Option(202))
+		assert.True(t, ok)
+	}
+}
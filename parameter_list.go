@@ -0,0 +1,71 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+// From RFC2131, section 4.3.1: the server returns the parameters the client
+// asked for in its parameter request list. These options are always kept, as
+// they are required for the protocol itself to function.
+var mandatoryOptions = []Option{
+	OptionDHCPMsgType,
+	OptionDHCPServerID,
+	OptionAddressTime,
+	OptionRenewalTime,
+	OptionRebindingTime,
+	OptionDHCPMessage,
+	OptionOverload,
+	OptionRelayAgentInformation,
+}
+
+// KeepRequestedOptions removes the options the client did not ask for in the
+// parameter request list of req, except for the options that are mandatory in
+// replies. Nothing is removed if req doesn't have a parameter request list.
+func (om OptionMap) KeepRequestedOptions(req OptionGetter) {
+	list, ok := req.GetOptionList(OptionParameterList)
+	if !ok {
+		return
+	}
+
+	keep := make(map[Option]bool)
+	for _, o := range mandatoryOptions {
+		keep[o] = true
+	}
+
+	for _, o := range list {
+		keep[o] = true
+	}
+
+	for o := range om {
+		if !keep[o] {
+			delete(om, o)
+		}
+	}
+}
+
+// requestedOptionOrder returns the order in which options should be written to
+// a reply to req: the mandatory options first, followed by the options in the
+// parameter request list, in the order preferred by the client (RFC2132,
+// section 9.8). It returns nil if req doesn't have a parameter request list.
+func requestedOptionOrder(req OptionGetter) []Option {
+	list, ok := req.GetOptionList(OptionParameterList)
+	if !ok {
+		return nil
+	}
+
+	order := make([]Option, 0, len(mandatoryOptions)+len(list))
+	order = append(order, mandatoryOptions...)
+	order = append(order, list...)
+	return order
+}
@@ -0,0 +1,56 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionMapKeepRequestedOptions(t *testing.T) {
+	newOptionMap := func() OptionMap {
+		om := make(OptionMap)
+		om.SetMessageType(MessageTypeDHCPAck)
+		om.SetIP(OptionDHCPServerID, net.IPv4(10, 0, 0, 1))
+		om.SetIP(OptionSubnetMask, net.IPv4(255, 255, 255, 0))
+		om.SetIP(OptionRouter, net.IPv4(10, 0, 0, 1))
+		om.SetString(OptionDomainName, "example.com")
+		return om
+	}
+
+	// Without parameter request list
+	req := NewPacket(BootRequest)
+	om := newOptionMap()
+	om.KeepRequestedOptions(req)
+	assert.Equal(t, newOptionMap(), om)
+
+	// With parameter request list
+	req.SetOptionList(OptionParameterList, []Option{OptionRouter, OptionDomainServer})
+	om.KeepRequestedOptions(req)
+	assert.Equal(t, []Option{OptionRouter, OptionDHCPMsgType, OptionDHCPServerID}, []Option(sortedOptions(om)))
+}
+
+func TestRequestedOptionOrder(t *testing.T) {
+	req := NewPacket(BootRequest)
+	assert.Nil(t, requestedOptionOrder(req))
+
+	req.SetOptionList(OptionParameterList, []Option{OptionDomainName, OptionRouter})
+	order := requestedOptionOrder(req)
+	assert.Equal(t, mandatoryOptions, order[:len(mandatoryOptions)])
+	assert.Equal(t, []Option{OptionDomainName, OptionRouter}, order[len(mandatoryOptions):])
+}
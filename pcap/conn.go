@@ -0,0 +1,282 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pcap
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/vmware/godhcpv4"
+)
+
+var errNoDeadline = errors.New("pcap: connection doesn't support read deadlines")
+
+// timeoutError is returned by ReadFrom when the read deadline expires.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "pcap: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// ReplayConn is an in-memory PacketConn that reads the DHCP packets in a
+// capture file, and records the packets that are written to it. It can be
+// passed to dhcpv4.Serve to replay a capture into a Handler.
+type ReplayConn struct {
+	r *Reader
+
+	mu       sync.Mutex
+	last     Record
+	written  []Packet
+	deadline time.Time
+}
+
+// NewReplayConn returns a ReplayConn that reads from r.
+func NewReplayConn(r *Reader) *ReplayConn {
+	return &ReplayConn{r: r}
+}
+
+// ReadFrom copies the next DHCP packet in the capture into b. The interface
+// index it returns is the interface of the packet in the capture. It returns
+// io.EOF when there are no more packets.
+func (c *ReplayConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	n, addr, _, ifindex, err := c.ReadFromDestination(b)
+	return n, addr, ifindex, err
+}
+
+// ReadFromDestination is like ReadFrom, but also returns the destination IP
+// address of the packet in the capture.
+func (c *ReplayConn) ReadFromDestination(b []byte) (int, net.Addr, net.IP, int, error) {
+	for {
+		c.mu.Lock()
+		deadline := c.deadline
+		c.mu.Unlock()
+
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return 0, nil, nil, -1, timeoutError{}
+		}
+
+		rec, err := c.r.Next()
+		if err != nil {
+			return 0, nil, nil, -1, err
+		}
+
+		f, ok := recordToFrame(rec)
+		if !ok {
+			continue
+		}
+
+		c.mu.Lock()
+		c.last = rec
+		c.mu.Unlock()
+
+		src := f.Src
+		return copy(b, f.Payload), &src, f.Dst.IP, rec.Interface, nil
+	}
+}
+
+// WriteTo records the DHCP packet in b. Its timestamp is the timestamp of the
+// packet that was read last.
+func (c *ReplayConn) WriteTo(b []byte, addr net.Addr, ifindex int) (int, error) {
+	dp, err := dhcpv4.PacketFromBytes(b)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dst := rawAddr(addr)
+	p := Packet{
+		Packet:    dp,
+		Timestamp: c.last.Timestamp,
+		Interface: ifindex,
+		Dst:       &dst,
+	}
+
+	if ifindex == c.last.Interface {
+		p.InterfaceName = c.last.InterfaceName
+	}
+
+	c.written = append(c.written, p)
+	return len(b), nil
+}
+
+// Written returns the packets that were written to the connection.
+func (c *ReplayConn) Written() []Packet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Packet(nil), c.written...)
+}
+
+// SetReadDeadline sets the deadline for calls to ReadFrom. A zero value for t
+// means ReadFrom will not time out.
+func (c *ReplayConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	return nil
+}
+
+func (c *ReplayConn) Close() error {
+	return nil
+}
+
+func (c *ReplayConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: 67}
+}
+
+// Replay serves the DHCP packets in the capture read by r with h, and returns
+// the packets h replied with. Replies that h writes after its ServeDHCP
+// function has returned for the last packet in the capture may be missing.
+func Replay(r *Reader, h dhcpv4.Handler) ([]Packet, error) {
+	c := NewReplayConn(r)
+
+	if err := dhcpv4.Serve(c, h); err != io.EOF {
+		return nil, err
+	}
+
+	return c.Written(), nil
+}
+
+// rawAddr converts addr to a RawAddr.
+func rawAddr(addr net.Addr) dhcpv4.RawAddr {
+	switch a := addr.(type) {
+	case *dhcpv4.RawAddr:
+		return *a
+	case *net.UDPAddr:
+		return dhcpv4.RawAddr{UDPAddr: *a}
+	}
+
+	return dhcpv4.RawAddr{}
+}
+
+type teeConn struct {
+	dhcpv4.PacketConn
+
+	w                *Writer
+	now              func() time.Time
+	interfaceByIndex func(int) (*net.Interface, error)
+
+	// Interface names by index, resolved once
+	mu    sync.Mutex
+	names map[int]string
+}
+
+// Tee returns a PacketConn that writes the packets that are read from and
+// written to pc to w, wrapped in Ethernet frames. Errors writing to w are
+// ignored, so a failing capture never interrupts the connection.
+func Tee(pc dhcpv4.PacketConn, w *Writer) dhcpv4.PacketConn {
+	return &teeConn{
+		PacketConn:       pc,
+		w:                w,
+		now:              time.Now,
+		interfaceByIndex: net.InterfaceByIndex,
+		names:            make(map[int]string),
+	}
+}
+
+// interfaceName returns the name of the interface with the specified index,
+// or an empty string if it doesn't exist.
+func (t *teeConn) interfaceName(ifindex int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	name, ok := t.names[ifindex]
+	if !ok {
+		if ifi, err := t.interfaceByIndex(ifindex); err == nil {
+			name = ifi.Name
+		}
+
+		t.names[ifindex] = name
+	}
+
+	return name
+}
+
+func (t *teeConn) record(b []byte, src, dst net.Addr, ifindex int) {
+	f := dhcpv4.Frame{
+		Src:     rawAddr(src),
+		Dst:     rawAddr(dst),
+		Payload: b,
+	}
+
+	rec := Record{
+		Timestamp:     t.now(),
+		Interface:     ifindex,
+		InterfaceName: t.interfaceName(ifindex),
+		LinkType:      LinkTypeEthernet,
+		Data:          dhcpv4.FrameToBytes(f),
+	}
+
+	t.w.WriteRecord(rec)
+}
+
+func (t *teeConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	n, addr, _, ifindex, err := t.ReadFromDestination(b)
+	return n, addr, ifindex, err
+}
+
+// ReadFromDestination returns the destination of packets if the wrapped
+// PacketConn is a DestinationReader, and nil otherwise.
+func (t *teeConn) ReadFromDestination(b []byte) (int, net.Addr, net.IP, int, error) {
+	var n, ifindex int
+	var addr net.Addr
+	var dst net.IP
+	var err error
+
+	if dr, ok := t.PacketConn.(dhcpv4.DestinationReader); ok {
+		n, addr, dst, ifindex, err = dr.ReadFromDestination(b)
+	} else {
+		n, addr, ifindex, err = t.PacketConn.ReadFrom(b)
+	}
+
+	if err == nil {
+		local := t.LocalAddr()
+		if dst != nil {
+			local = &net.UDPAddr{IP: dst, Port: rawAddr(local).Port}
+		}
+
+		t.record(b[:n], addr, local, ifindex)
+	}
+
+	return n, addr, dst, ifindex, err
+}
+
+// SetReadDeadline sets the read deadline of the wrapped PacketConn, if it
+// supports read deadlines.
+func (t *teeConn) SetReadDeadline(deadline time.Time) error {
+	if d, ok := t.PacketConn.(interface {
+		SetReadDeadline(time.Time) error
+	}); ok {
+		return d.SetReadDeadline(deadline)
+	}
+
+	return errNoDeadline
+}
+
+func (t *teeConn) WriteTo(b []byte, addr net.Addr, ifindex int) (int, error) {
+	n, err := t.PacketConn.WriteTo(b, addr, ifindex)
+	if err == nil {
+		t.record(b, t.LocalAddr(), addr, ifindex)
+	}
+
+	return n, err
+}
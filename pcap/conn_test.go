@@ -0,0 +1,222 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/godhcpv4"
+)
+
+type testHandler struct{}
+
+func (h testHandler) ServeDHCP(req dhcpv4.Request) {
+	switch req := req.(type) {
+	case dhcpv4.DHCPDiscover:
+		rep := dhcpv4.CreateDHCPOffer(req)
+		rep.SetYIAddr(net.IPv4(192, 168, 1, 10))
+		rep.SetDuration(dhcpv4.OptionAddressTime, time.Hour)
+		rep.SetIP(dhcpv4.OptionDHCPServerID, net.IPv4(192, 168, 1, 1))
+		req.WriteReply(rep)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	ts := time.Unix(1400000000, 0)
+	b := testPcap(binary.LittleEndian, magicMicroseconds, ts, testFrame(t), testOtherFrame())
+
+	r, err := NewReader(bytes.NewReader(b))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	replies, err := Replay(r, testHandler{})
+	if assert.NoError(t, err) && assert.Len(t, replies, 1) {
+		rep := replies[0]
+		assert.Equal(t, dhcpv4.MessageTypeDHCPOffer, rep.GetMessageType())
+		assert.True(t, ts.Equal(rep.Timestamp))
+		assert.True(t, net.IPv4(192, 168, 1, 10).Equal(rep.Dst.IP))
+		assert.Equal(t, testClientMAC, rep.Dst.HardwareAddr)
+	}
+}
+
+// testConn is a PacketConn that returns a single packet from ReadFrom.
+type testConn struct {
+	b []byte
+}
+
+func (c *testConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	if c.b == nil {
+		return 0, nil, -1, io.EOF
+	}
+
+	n := copy(b, c.b)
+	c.b = nil
+	return n, &net.UDPAddr{IP: net.IPv4zero, Port: 68}, 2, nil
+}
+
+func (c *testConn) WriteTo(b []byte, addr net.Addr, ifindex int) (int, error) {
+	return len(b), nil
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: 67}
+}
+
+func TestTee(t *testing.T) {
+	req := dhcpv4.CreateDHCPDiscover(testClientMAC)
+	b, err := dhcpv4.PacketToBytes(req.Packet, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	pc := Tee(&testConn{b: b}, w)
+	assert.Equal(t, io.EOF, dhcpv4.Serve(pc, testHandler{}))
+
+	r, err := NewReader(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The request, followed by the reply
+	p, err := r.NextPacket()
+	if assert.NoError(t, err) {
+		assert.Equal(t, dhcpv4.MessageTypeDHCPDiscover, p.GetMessageType())
+		assert.Equal(t, 68, p.Src.Port)
+		assert.Equal(t, 67, p.Dst.Port)
+	}
+
+	p, err = r.NextPacket()
+	if assert.NoError(t, err) {
+		assert.Equal(t, dhcpv4.MessageTypeDHCPOffer, p.GetMessageType())
+		assert.Equal(t, 67, p.Src.Port)
+		assert.Equal(t, 68, p.Dst.Port)
+	}
+
+	_, err = r.NextPacket()
+	assert.Equal(t, io.EOF, err)
+}
+
+// deadlineConn is a testConn that records its read deadline.
+type deadlineConn struct {
+	testConn
+	deadline time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func TestTeeSetReadDeadline(t *testing.T) {
+	w, err := NewWriter(io.Discard)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The deadline is forwarded, so the wrapped conn isn't closed to stop
+	// reading from it
+	dc := &deadlineConn{}
+	d := time.Unix(1, 0)
+	if pc, ok := Tee(dc, w).(interface {
+		SetReadDeadline(time.Time) error
+	}); assert.True(t, ok) {
+		assert.NoError(t, pc.SetReadDeadline(d))
+		assert.Equal(t, d, dc.deadline)
+	}
+
+	if pc, ok := Tee(&testConn{}, w).(interface {
+		SetReadDeadline(time.Time) error
+	}); assert.True(t, ok) {
+		assert.Equal(t, errNoDeadline, pc.SetReadDeadline(d))
+	}
+}
+
+func TestReplayConnSetReadDeadline(t *testing.T) {
+	b := testPcap(binary.LittleEndian, magicMicroseconds, time.Now(), testFrame(t))
+
+	r, err := NewReader(bytes.NewReader(b))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	c := NewReplayConn(r)
+	buf := make([]byte, 1500)
+
+	assert.NoError(t, c.SetReadDeadline(time.Unix(1, 0)))
+	_, _, _, err = c.ReadFrom(buf)
+	if assert.Error(t, err) {
+		assert.True(t, err.(net.Error).Timeout())
+	}
+
+	// The packet is still there once the deadline is cleared
+	assert.NoError(t, c.SetReadDeadline(time.Time{}))
+	_, _, _, err = c.ReadFrom(buf)
+	assert.NoError(t, err)
+}
+
+func TestTeeInterfaceName(t *testing.T) {
+	req := dhcpv4.CreateDHCPDiscover(testClientMAC)
+	b, err := dhcpv4.PacketToBytes(req.Packet, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The interface is only looked up once
+	lookups := 0
+	pc := Tee(&testConn{b: b}, w).(*teeConn)
+	pc.interfaceByIndex = func(ifindex int) (*net.Interface, error) {
+		lookups++
+		return &net.Interface{Index: ifindex, Name: "eth1"}, nil
+	}
+
+	assert.Equal(t, io.EOF, dhcpv4.Serve(pc, testHandler{}))
+	assert.Equal(t, 1, lookups)
+
+	r, err := NewReader(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		p, err := r.NextPacket()
+		if assert.NoError(t, err) {
+			assert.Equal(t, "eth1", p.InterfaceName)
+		}
+	}
+}
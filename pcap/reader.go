@@ -0,0 +1,509 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/vmware/godhcpv4"
+)
+
+var (
+	ErrUnknownFormat = errors.New("pcap: unknown file format")
+	ErrInvalidFile   = errors.New("pcap: invalid file")
+)
+
+// Link types DHCP packets can be read from. LinkTypeLinuxSLL is the link type
+// of captures on the "any" interface on Linux.
+const (
+	LinkTypeEthernet = 1
+	LinkTypeLinuxSLL = 113
+)
+
+// Magic numbers of pcap files (with microsecond and nanosecond timestamps),
+// and the block type of the pcapng section header block.
+const (
+	magicMicroseconds = 0xa1b2c3d4
+	magicNanoseconds  = 0xa1b23c4d
+	magicSection      = 0x0a0d0d0a
+	magicByteOrder    = 0x1a2b3c4d
+)
+
+// pcapng block types
+const (
+	blockInterface      = 0x00000001
+	blockPacket         = 0x00000002
+	blockSimplePacket   = 0x00000003
+	blockEnhancedPacket = 0x00000006
+)
+
+// pcapng interface description block options
+const (
+	optionEnd     = 0
+	optionName    = 2
+	optionTSResol = 9
+)
+
+// Record is a frame read from a capture file.
+type Record struct {
+	Timestamp time.Time
+
+	// Interface is the index of the interface the frame was captured on, in
+	// the order the capture file describes interfaces. It is always 0 for
+	// pcap files.
+	Interface     int
+	InterfaceName string
+	LinkType      int
+
+	Data []byte
+}
+
+// Packet is a DHCP packet read from a capture file.
+type Packet struct {
+	dhcpv4.Packet
+
+	Timestamp     time.Time
+	Interface     int
+	InterfaceName string
+
+	// Addresses of the sender and receiver of the frame
+	Src *dhcpv4.RawAddr
+	Dst *dhcpv4.RawAddr
+}
+
+type captureInterface struct {
+	name     string
+	linkType int
+
+	// Timestamp units per second
+	units int64
+}
+
+// Reader reads frames from a pcap or pcapng file.
+type Reader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	ng    bool
+
+	// For pcap files
+	linkType   int
+	resolution time.Duration
+
+	// For pcapng files
+	interfaces []captureInterface
+}
+
+// NewReader returns a Reader that reads from r. It detects whether r holds a
+// pcap or a pcapng file from its first bytes.
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := Reader{
+		r: bufio.NewReader(r),
+	}
+
+	b, err := cr.r.Peek(4)
+	if err != nil {
+		return nil, ErrUnknownFormat
+	}
+
+	switch {
+	case binary.BigEndian.Uint32(b) == magicSection:
+		cr.ng = true
+		return &cr, nil
+	case binary.LittleEndian.Uint32(b) == magicMicroseconds:
+		cr.order = binary.LittleEndian
+		cr.resolution = time.Microsecond
+	case binary.BigEndian.Uint32(b) == magicMicroseconds:
+		cr.order = binary.BigEndian
+		cr.resolution = time.Microsecond
+	case binary.LittleEndian.Uint32(b) == magicNanoseconds:
+		cr.order = binary.LittleEndian
+		cr.resolution = time.Nanosecond
+	case binary.BigEndian.Uint32(b) == magicNanoseconds:
+		cr.order = binary.BigEndian
+		cr.resolution = time.Nanosecond
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	// The pcap file header
+	h := make([]byte, 24)
+	if _, err := io.ReadFull(cr.r, h); err != nil {
+		return nil, ErrInvalidFile
+	}
+
+	cr.linkType = int(cr.order.Uint32(h[20:24]))
+	return &cr, nil
+}
+
+// readFull reads exactly len(b) bytes, returning io.EOF only if no bytes could
+// be read at all.
+func (r *Reader) readFull(b []byte) error {
+	_, err := io.ReadFull(r.r, b)
+	if err == io.ErrUnexpectedEOF {
+		return ErrInvalidFile
+	}
+
+	return err
+}
+
+// Next returns the next frame in the file. It returns io.EOF when there are no
+// more frames.
+func (r *Reader) Next() (Record, error) {
+	if r.ng {
+		return r.nextBlock()
+	}
+
+	h := make([]byte, 16)
+	if err := r.readFull(h); err != nil {
+		return Record{}, err
+	}
+
+	sec := int64(r.order.Uint32(h[0:4]))
+	frac := int64(r.order.Uint32(h[4:8]))
+	n := r.order.Uint32(h[8:12])
+	if n > 1<<24 {
+		return Record{}, ErrInvalidFile
+	}
+
+	data := make([]byte, n)
+	if err := r.readFull(data); err != nil {
+		return Record{}, ErrInvalidFile
+	}
+
+	rec := Record{
+		Timestamp: time.Unix(sec, frac*int64(r.resolution)),
+		LinkType:  r.linkType,
+		Data:      data,
+	}
+
+	return rec, nil
+}
+
+// nextBlock reads blocks from a pcapng file until it finds a packet block.
+func (r *Reader) nextBlock() (Record, error) {
+	for {
+		h := make([]byte, 8)
+		if err := r.readFull(h); err != nil {
+			return Record{}, err
+		}
+
+		// The section header block determines the byte order of the blocks
+		// that follow, so its length can only be interpreted after reading
+		// the byte order magic.
+		if binary.BigEndian.Uint32(h[0:4]) == magicSection {
+			m := make([]byte, 4)
+			if err := r.readFull(m); err != nil {
+				return Record{}, ErrInvalidFile
+			}
+
+			switch {
+			case binary.LittleEndian.Uint32(m) == magicByteOrder:
+				r.order = binary.LittleEndian
+			case binary.BigEndian.Uint32(m) == magicByteOrder:
+				r.order = binary.BigEndian
+			default:
+				return Record{}, ErrInvalidFile
+			}
+
+			n := r.order.Uint32(h[4:8])
+			if n < 28 || n%4 != 0 || n > 1<<24 {
+				return Record{}, ErrInvalidFile
+			}
+
+			if err := r.readFull(make([]byte, n-12)); err != nil {
+				return Record{}, ErrInvalidFile
+			}
+
+			// Interfaces are scoped to their section
+			r.interfaces = nil
+			continue
+		}
+
+		if r.order == nil {
+			return Record{}, ErrInvalidFile
+		}
+
+		t := r.order.Uint32(h[0:4])
+		n := r.order.Uint32(h[4:8])
+		if n < 12 || n%4 != 0 || n > 1<<24 {
+			return Record{}, ErrInvalidFile
+		}
+
+		// Block body, excluding the trailing length
+		b := make([]byte, n-12)
+		if err := r.readFull(b); err != nil {
+			return Record{}, ErrInvalidFile
+		}
+
+		if err := r.readFull(make([]byte, 4)); err != nil {
+			return Record{}, ErrInvalidFile
+		}
+
+		switch t {
+		case blockInterface:
+			ifc, err := r.parseInterface(b)
+			if err != nil {
+				return Record{}, err
+			}
+
+			r.interfaces = append(r.interfaces, ifc)
+		case blockPacket:
+			// The obsolete packet block has the layout of an enhanced
+			// packet block, but with a 16-bit interface ID, followed by a
+			// 16-bit drops count.
+			if len(b) < 4 {
+				return Record{}, ErrInvalidFile
+			}
+
+			return r.parsePacket(int(r.order.Uint16(b[0:2])), b)
+		case blockEnhancedPacket:
+			if len(b) < 4 {
+				return Record{}, ErrInvalidFile
+			}
+
+			return r.parsePacket(int(r.order.Uint32(b[0:4])), b)
+		case blockSimplePacket:
+			return r.parseSimplePacket(b)
+		}
+	}
+}
+
+func (r *Reader) parseInterface(b []byte) (captureInterface, error) {
+	if len(b) < 8 {
+		return captureInterface{}, ErrInvalidFile
+	}
+
+	ifc := captureInterface{
+		linkType: int(r.order.Uint16(b[0:2])),
+		units:    1e6,
+	}
+
+	for o := b[8:]; len(o) >= 4; {
+		code := r.order.Uint16(o[0:2])
+		length := int(r.order.Uint16(o[2:4]))
+		o = o[4:]
+		if code == optionEnd {
+			break
+		}
+
+		if len(o) < length {
+			return captureInterface{}, ErrInvalidFile
+		}
+
+		v := o[:length]
+
+		switch code {
+		case optionName:
+			ifc.name = string(v)
+		case optionTSResol:
+			if length != 1 {
+				return captureInterface{}, ErrInvalidFile
+			}
+
+			// The most significant bit determines whether the resolution is
+			// a negative power of 2 or of 10.
+			var units int64 = 1
+			for i := 0; i < int(v[0]&0x7f); i++ {
+				if v[0]&0x80 != 0 {
+					units *= 2
+				} else {
+					units *= 10
+				}
+
+				if units > 1e18 {
+					return captureInterface{}, ErrInvalidFile
+				}
+			}
+
+			ifc.units = units
+		}
+
+		// Option values are padded to 32 bits
+		length = (length + 3) &^ 3
+		if len(o) < length {
+			break
+		}
+		o = o[length:]
+	}
+
+	return ifc, nil
+}
+
+// parsePacket parses the body of a packet block captured on interface id.
+func (r *Reader) parsePacket(id int, b []byte) (Record, error) {
+	if len(b) < 20 {
+		return Record{}, ErrInvalidFile
+	}
+
+	if id >= len(r.interfaces) {
+		return Record{}, ErrInvalidFile
+	}
+
+	ifc := r.interfaces[id]
+	ts := int64(r.order.Uint32(b[4:8]))<<32 | int64(r.order.Uint32(b[8:12]))
+
+	n := int(r.order.Uint32(b[12:16]))
+	if len(b) < 20+n {
+		return Record{}, ErrInvalidFile
+	}
+
+	rec := Record{
+		Timestamp:     ifc.timestamp(ts),
+		Interface:     id,
+		InterfaceName: ifc.name,
+		LinkType:      ifc.linkType,
+		Data:          b[20 : 20+n],
+	}
+
+	return rec, nil
+}
+
+func (r *Reader) parseSimplePacket(b []byte) (Record, error) {
+	if len(b) < 4 || len(r.interfaces) == 0 {
+		return Record{}, ErrInvalidFile
+	}
+
+	// Simple packet blocks are always captured on the first interface, and
+	// are only truncated by the block itself.
+	n := int(r.order.Uint32(b[0:4]))
+	if n > len(b)-4 {
+		n = len(b) - 4
+	}
+
+	ifc := r.interfaces[0]
+	rec := Record{
+		InterfaceName: ifc.name,
+		LinkType:      ifc.linkType,
+		Data:          b[4 : 4+n],
+	}
+
+	return rec, nil
+}
+
+// timestamp converts a timestamp in units of the interface's resolution.
+func (ifc captureInterface) timestamp(ts int64) time.Time {
+	sec := ts / ifc.units
+	frac := ts % ifc.units
+
+	var nsec int64
+	if ifc.units <= 1e9 {
+		nsec = frac * 1e9 / ifc.units
+	} else {
+		nsec = frac / (ifc.units / 1e9)
+	}
+
+	return time.Unix(sec, nsec)
+}
+
+// NextPacket returns the next DHCP packet in the file. Frames that are not
+// Ethernet or Linux cooked frames carrying a DHCP packet are skipped. UDP
+// checksums are not verified, since frames captured on the host that sent
+// them may not have a checksum yet. It returns io.EOF when there are no more
+// packets.
+func (r *Reader) NextPacket() (Packet, error) {
+	for {
+		rec, err := r.Next()
+		if err != nil {
+			return Packet{}, err
+		}
+
+		p, ok := recordToPacket(rec)
+		if ok {
+			return p, nil
+		}
+	}
+}
+
+// isDHCPPort returns whether port is the server or client port.
+func isDHCPPort(port int) bool {
+	return port == 67 || port == 68
+}
+
+// sllToEthernet converts a Linux cooked capture frame to an Ethernet frame.
+// The destination hardware address of a Linux cooked frame is not known, so it
+// is the broadcast address for frames that were broadcast, and zero otherwise.
+func sllToEthernet(b []byte) ([]byte, bool) {
+	if len(b) < 16 {
+		return nil, false
+	}
+
+	e := make([]byte, 14, 14+len(b)-16)
+
+	// Packet type 1 is a frame broadcast by another host
+	if binary.BigEndian.Uint16(b[0:2]) == 1 {
+		copy(e[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	}
+
+	// The link-layer address of the sender
+	if binary.BigEndian.Uint16(b[4:6]) == 6 {
+		copy(e[6:12], b[6:12])
+	}
+
+	copy(e[12:14], b[14:16])
+	return append(e, b[16:]...), true
+}
+
+// recordToFrame returns the frame in rec, if it carries a DHCP packet.
+func recordToFrame(rec Record) (dhcpv4.Frame, bool) {
+	data := rec.Data
+
+	switch rec.LinkType {
+	case LinkTypeEthernet:
+	case LinkTypeLinuxSLL:
+		var ok bool
+		if data, ok = sllToEthernet(data); !ok {
+			return dhcpv4.Frame{}, false
+		}
+	default:
+		return dhcpv4.Frame{}, false
+	}
+
+	f, err := dhcpv4.FrameFromBytesUnverified(data)
+	if err != nil || !isDHCPPort(f.Src.Port) || !isDHCPPort(f.Dst.Port) {
+		return dhcpv4.Frame{}, false
+	}
+
+	f.Src.HardwareAddr = append([]byte(nil), f.Src.HardwareAddr...)
+	f.Dst.HardwareAddr = append([]byte(nil), f.Dst.HardwareAddr...)
+	return f, true
+}
+
+func recordToPacket(rec Record) (Packet, bool) {
+	f, ok := recordToFrame(rec)
+	if !ok {
+		return Packet{}, false
+	}
+
+	dp, err := dhcpv4.PacketFromBytes(f.Payload)
+	if err != nil {
+		return Packet{}, false
+	}
+
+	p := Packet{
+		Packet:        dp,
+		Timestamp:     rec.Timestamp,
+		Interface:     rec.Interface,
+		InterfaceName: rec.InterfaceName,
+		Src:           &f.Src,
+		Dst:           &f.Dst,
+	}
+
+	return p, true
+}
@@ -0,0 +1,311 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/godhcpv4"
+)
+
+var (
+	testClientMAC = net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	testServerMAC = net.HardwareAddr{6, 7, 8, 9, 10, 11}
+)
+
+// testFrame returns a frame with a DHCPDISCOVER broadcast by a client.
+func testFrame(t *testing.T) []byte {
+	p := dhcpv4.CreateDHCPDiscover(testClientMAC)
+
+	src := dhcpv4.RawAddr{
+		UDPAddr:      net.UDPAddr{IP: net.IPv4zero, Port: 68},
+		HardwareAddr: testClientMAC,
+	}
+
+	dst := dhcpv4.RawAddr{
+		UDPAddr:      net.UDPAddr{IP: net.IPv4bcast, Port: 67},
+		HardwareAddr: net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+
+	b, err := dhcpv4.PacketToFrame(p.Packet, src, dst)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return b
+}
+
+// testOtherFrame returns a frame that doesn't carry a DHCP packet.
+func testOtherFrame() []byte {
+	f := dhcpv4.Frame{
+		Src:     dhcpv4.RawAddr{UDPAddr: net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53}},
+		Dst:     dhcpv4.RawAddr{UDPAddr: net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 53}},
+		Payload: []byte("dns"),
+	}
+
+	return dhcpv4.FrameToBytes(f)
+}
+
+// testPcap returns a pcap file with the specified byte order and magic.
+func testPcap(order binary.ByteOrder, magic uint32, ts time.Time, frames ...[]byte) []byte {
+	var buf bytes.Buffer
+
+	h := make([]byte, 24)
+	order.PutUint32(h[0:4], magic)
+	order.PutUint16(h[4:6], 2)
+	order.PutUint16(h[6:8], 4)
+	order.PutUint32(h[16:20], 65535)
+	order.PutUint32(h[20:24], LinkTypeEthernet)
+	buf.Write(h)
+
+	frac := ts.Nanosecond() / 1000
+	if magic == magicNanoseconds {
+		frac = ts.Nanosecond()
+	}
+
+	for _, f := range frames {
+		r := make([]byte, 16)
+		order.PutUint32(r[0:4], uint32(ts.Unix()))
+		order.PutUint32(r[4:8], uint32(frac))
+		order.PutUint32(r[8:12], uint32(len(f)))
+		order.PutUint32(r[12:16], uint32(len(f)))
+		buf.Write(r)
+		buf.Write(f)
+	}
+
+	return buf.Bytes()
+}
+
+func TestReaderPcap(t *testing.T) {
+	ts := time.Unix(1400000000, 123456789)
+
+	testCases := []struct {
+		order    binary.ByteOrder
+		magic    uint32
+		expected time.Time
+	}{
+		{binary.LittleEndian, magicMicroseconds, time.Unix(1400000000, 123456000)},
+		{binary.BigEndian, magicMicroseconds, time.Unix(1400000000, 123456000)},
+		{binary.LittleEndian, magicNanoseconds, ts},
+		{binary.BigEndian, magicNanoseconds, ts},
+	}
+
+	for _, testCase := range testCases {
+		b := testPcap(testCase.order, testCase.magic, ts, testOtherFrame(), testFrame(t))
+
+		r, err := NewReader(bytes.NewReader(b))
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		p, err := r.NextPacket()
+		if assert.NoError(t, err) {
+			assert.Equal(t, dhcpv4.MessageTypeDHCPDiscover, p.GetMessageType())
+			assert.True(t, testCase.expected.Equal(p.Timestamp))
+			assert.Equal(t, 0, p.Interface)
+			assert.Equal(t, testClientMAC, p.Src.HardwareAddr)
+			assert.Equal(t, 68, p.Src.Port)
+			assert.Equal(t, 67, p.Dst.Port)
+		}
+
+		_, err = r.NextPacket()
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+func TestReaderPcapBadChecksum(t *testing.T) {
+	// Frames captured on the sending host may have an incomplete checksum
+	f := testFrame(t)
+	f[14+20+6] ^= 0xff
+
+	_, err := dhcpv4.FrameFromBytes(f)
+	assert.Equal(t, dhcpv4.ErrInvalidChecksum, err)
+
+	r, err := NewReader(bytes.NewReader(testPcap(binary.LittleEndian, magicMicroseconds, time.Now(), f)))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p, err := r.NextPacket()
+	if assert.NoError(t, err) {
+		assert.Equal(t, dhcpv4.MessageTypeDHCPDiscover, p.GetMessageType())
+	}
+}
+
+func TestReaderPcapLinuxSLL(t *testing.T) {
+	e := testFrame(t)
+
+	// Replace the Ethernet header with a Linux cooked capture header
+	sll := make([]byte, 16)
+	binary.BigEndian.PutUint16(sll[0:2], 1) // Broadcast
+	binary.BigEndian.PutUint16(sll[2:4], 1) // ARPHRD_ETHER
+	binary.BigEndian.PutUint16(sll[4:6], 6)
+	copy(sll[6:12], testClientMAC)
+	copy(sll[14:16], e[12:14])
+
+	b := testPcap(binary.LittleEndian, magicMicroseconds, time.Now(), append(sll, e[14:]...))
+	binary.LittleEndian.PutUint32(b[20:24], LinkTypeLinuxSLL)
+
+	r, err := NewReader(bytes.NewReader(b))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p, err := r.NextPacket()
+	if assert.NoError(t, err) {
+		assert.Equal(t, dhcpv4.MessageTypeDHCPDiscover, p.GetMessageType())
+		assert.Equal(t, testClientMAC, p.Src.HardwareAddr)
+		assert.Equal(t, net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, p.Dst.HardwareAddr)
+		assert.Equal(t, 67, p.Dst.Port)
+	}
+}
+
+func TestReaderPcapTruncated(t *testing.T) {
+	b := testPcap(binary.LittleEndian, magicMicroseconds, time.Now(), testFrame(t))
+
+	r, err := NewReader(bytes.NewReader(b[:len(b)-1]))
+	if assert.NoError(t, err) {
+		_, err = r.Next()
+		assert.Equal(t, ErrInvalidFile, err)
+	}
+}
+
+func TestReaderUnknownFormat(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("garbage")))
+	assert.Equal(t, ErrUnknownFormat, err)
+
+	_, err = NewReader(bytes.NewReader(nil))
+	assert.Equal(t, ErrUnknownFormat, err)
+}
+
+// testBlock returns a pcapng block in the specified byte order.
+func testBlock(order binary.ByteOrder, t uint32, body []byte) []byte {
+	body = pad(body)
+	n := uint32(12 + len(body))
+
+	b := make([]byte, 8)
+	order.PutUint32(b[0:4], t)
+	order.PutUint32(b[4:8], n)
+	b = append(b, body...)
+
+	l := make([]byte, 4)
+	order.PutUint32(l, n)
+	return append(b, l...)
+}
+
+func TestReaderPcapngBigEndian(t *testing.T) {
+	order := binary.BigEndian
+	frame := testFrame(t)
+
+	var buf bytes.Buffer
+
+	// Section header block
+	shb := make([]byte, 16)
+	order.PutUint32(shb[0:4], magicByteOrder)
+	order.PutUint16(shb[4:6], 1)
+	order.PutUint64(shb[8:16], ^uint64(0))
+	buf.Write(testBlock(order, magicSection, shb))
+
+	// Interface description block with name "eth0" and a resolution of 2^-10
+	idb := make([]byte, 8)
+	order.PutUint16(idb[0:2], LinkTypeEthernet)
+	idb = order.AppendUint16(idb, optionName)
+	idb = order.AppendUint16(idb, 4)
+	idb = append(idb, "eth0"...)
+	idb = order.AppendUint16(idb, optionTSResol)
+	idb = order.AppendUint16(idb, 1)
+	idb = append(idb, 0x80|10, 0, 0, 0)
+	idb = order.AppendUint16(idb, optionEnd)
+	idb = order.AppendUint16(idb, 0)
+	buf.Write(testBlock(order, blockInterface, idb))
+
+	// Unknown block
+	buf.Write(testBlock(order, 0xbad, []byte("skip me")))
+
+	// Enhanced packet block, 3.5 seconds after the epoch
+	epb := make([]byte, 20)
+	order.PutUint32(epb[8:12], 3*1024+512)
+	order.PutUint32(epb[12:16], uint32(len(frame)))
+	order.PutUint32(epb[16:20], uint32(len(frame)))
+	buf.Write(testBlock(order, blockEnhancedPacket, append(epb, frame...)))
+
+	// Simple packet block
+	spb := make([]byte, 4)
+	order.PutUint32(spb, uint32(len(frame)))
+	buf.Write(testBlock(order, blockSimplePacket, append(spb, frame...)))
+
+	// Obsolete packet block, 2 seconds after the epoch
+	pb := make([]byte, 20)
+	order.PutUint16(pb[2:4], 1) // Drops count
+	order.PutUint32(pb[8:12], 2*1024)
+	order.PutUint32(pb[12:16], uint32(len(frame)))
+	order.PutUint32(pb[16:20], uint32(len(frame)))
+	buf.Write(testBlock(order, blockPacket, append(pb, frame...)))
+
+	r, err := NewReader(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p, err := r.NextPacket()
+	if assert.NoError(t, err) {
+		assert.True(t, time.Unix(3, 5e8).Equal(p.Timestamp))
+		assert.Equal(t, "eth0", p.InterfaceName)
+		assert.Equal(t, dhcpv4.MessageTypeDHCPDiscover, p.GetMessageType())
+	}
+
+	p, err = r.NextPacket()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "eth0", p.InterfaceName)
+		assert.Equal(t, dhcpv4.MessageTypeDHCPDiscover, p.GetMessageType())
+	}
+
+	p, err = r.NextPacket()
+	if assert.NoError(t, err) {
+		assert.True(t, time.Unix(2, 0).Equal(p.Timestamp))
+		assert.Equal(t, "eth0", p.InterfaceName)
+		assert.Equal(t, dhcpv4.MessageTypeDHCPDiscover, p.GetMessageType())
+	}
+
+	_, err = r.NextPacket()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReaderPcapngUnknownInterface(t *testing.T) {
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// A packet block referring to an interface that was never described
+	epb := make([]byte, 20)
+	binary.LittleEndian.PutUint32(epb[0:4], 1)
+	w.writeBlock(blockEnhancedPacket, epb)
+
+	r, err := NewReader(&buf)
+	if assert.NoError(t, err) {
+		_, err = r.Next()
+		assert.Equal(t, ErrInvalidFile, err)
+	}
+}
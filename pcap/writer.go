@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// Writer writes frames to a pcapng file. It can be used from multiple
+// goroutines.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+
+	// Maps the interface of records to the index of their interface
+	// description block.
+	interfaces map[int]uint32
+}
+
+// NewWriter returns a Writer that writes to w. It writes the section header of
+// the file before returning.
+func NewWriter(w io.Writer) (*Writer, error) {
+	cw := Writer{
+		w:          w,
+		interfaces: make(map[int]uint32),
+	}
+
+	// Section header block, with unspecified section length
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint32(b[0:4], magicByteOrder)
+	binary.LittleEndian.PutUint16(b[4:6], 1) // Major version
+	binary.LittleEndian.PutUint16(b[6:8], 0) // Minor version
+	binary.LittleEndian.PutUint64(b[8:16], ^uint64(0))
+
+	if err := cw.writeBlock(magicSection, b); err != nil {
+		return nil, err
+	}
+
+	return &cw, nil
+}
+
+// pad returns b padded to a multiple of 32 bits.
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+
+	return b
+}
+
+func (w *Writer) writeBlock(t uint32, body []byte) error {
+	body = pad(body)
+	n := uint32(12 + len(body))
+
+	b := make([]byte, 0, n)
+	b = binary.LittleEndian.AppendUint32(b, t)
+	b = binary.LittleEndian.AppendUint32(b, n)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, n)
+
+	_, err := w.w.Write(b)
+	return err
+}
+
+// writeInterface writes an interface description block for the interface of
+// rec, if it hasn't been written yet, and returns its index.
+func (w *Writer) writeInterface(rec Record) (uint32, error) {
+	if id, ok := w.interfaces[rec.Interface]; ok {
+		return id, nil
+	}
+
+	linkType := rec.LinkType
+	if linkType == 0 {
+		linkType = LinkTypeEthernet
+	}
+
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint16(b[0:2], uint16(linkType))
+	binary.LittleEndian.PutUint32(b[4:8], 0) // No snap length
+
+	if rec.InterfaceName != "" && len(rec.InterfaceName) <= 0xffff {
+		b = binary.LittleEndian.AppendUint16(b, optionName)
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(rec.InterfaceName)))
+		b = pad(append(b, rec.InterfaceName...))
+	}
+
+	// Timestamps are written in nanoseconds
+	b = binary.LittleEndian.AppendUint16(b, optionTSResol)
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	b = pad(append(b, 9))
+
+	b = binary.LittleEndian.AppendUint16(b, optionEnd)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+
+	if err := w.writeBlock(blockInterface, b); err != nil {
+		return 0, err
+	}
+
+	id := uint32(len(w.interfaces))
+	w.interfaces[rec.Interface] = id
+	return id, nil
+}
+
+// WriteRecord writes a frame to the file. The interface of the record is
+// described in the file the first time it is written. Because interfaces are
+// numbered in the order they are described, the interface of the record that
+// is read back may differ from the one that was written.
+func (w *Writer) WriteRecord(rec Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	id, err := w.writeInterface(rec)
+	if err != nil {
+		return err
+	}
+
+	ts := uint64(rec.Timestamp.UnixNano())
+
+	b := make([]byte, 20, 20+len(rec.Data))
+	binary.LittleEndian.PutUint32(b[0:4], id)
+	binary.LittleEndian.PutUint32(b[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(b[12:16], uint32(len(rec.Data)))
+	binary.LittleEndian.PutUint32(b[16:20], uint32(len(rec.Data)))
+	b = append(b, rec.Data...)
+
+	return w.writeBlock(blockEnhancedPacket, b)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pcap
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ts := time.Unix(1400000000, 123456789)
+	records := []Record{
+		{Timestamp: ts, Interface: 7, InterfaceName: "eth0", Data: testFrame(t)},
+		{Timestamp: ts.Add(time.Second), Interface: 3, InterfaceName: "eth1", Data: testOtherFrame()},
+		{Timestamp: ts.Add(2 * time.Second), Interface: 7, InterfaceName: "eth0", Data: testFrame(t)},
+	}
+
+	for _, rec := range records {
+		assert.NoError(t, w.WriteRecord(rec))
+	}
+
+	r, err := NewReader(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Interfaces are numbered in the order they are first written
+	expected := []int{0, 1, 0}
+
+	for i, rec := range records {
+		actual, err := r.Next()
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.True(t, rec.Timestamp.Equal(actual.Timestamp))
+		assert.Equal(t, expected[i], actual.Interface)
+		assert.Equal(t, rec.InterfaceName, actual.InterfaceName)
+		assert.Equal(t, LinkTypeEthernet, actual.LinkType)
+		assert.Equal(t, rec.Data, actual.Data)
+	}
+
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+}
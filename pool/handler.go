@@ -0,0 +1,466 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pool
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/vmware/godhcpv4"
+)
+
+var ErrInvalidServerID = errors.New("pool: invalid server identifier")
+
+// offerTime is how long an offered address is held for the client it was
+// offered to, before it can be offered to another client.
+const offerTime = time.Minute
+
+type leaseState int
+
+const (
+	leaseOffered leaseState = iota
+	leaseBound
+	leaseDeclined
+)
+
+type lease struct {
+	client string
+	ip     uint32
+	state  leaseState
+	expiry time.Time
+}
+
+// Handler is a dhcpv4.Handler that allocates addresses from one or more
+// subnets. It handles the complete lifecycle of a lease, from DHCPDISCOVER to
+// DHCPRELEASE or DHCPDECLINE, and answers DHCPINFORM requests.
+type Handler struct {
+	serverID net.IP
+	subnets  []*subnet
+	store    dhcpv4.LeaseStore
+
+	now func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*lease
+	addrs   map[uint32]*lease
+}
+
+// NewHandler returns a Handler that identifies itself with the specified
+// server identifier and allocates addresses from the specified subnets. Leases
+// are kept in memory.
+func NewHandler(serverID net.IP, subnets []Subnet) (*Handler, error) {
+	return NewHandlerWithStore(serverID, subnets, dhcpv4.NewMemoryLeaseStore())
+}
+
+// NewHandlerWithStore is like NewHandler, but keeps leases in the specified
+// lease store. The leases in the store that were handed out with the same
+// server identifier are loaded when the handler is created.
+func NewHandlerWithStore(serverID net.IP, subnets []Subnet, store dhcpv4.LeaseStore) (*Handler, error) {
+	if serverID.To4() == nil {
+		return nil, ErrInvalidServerID
+	}
+
+	h := Handler{
+		serverID: serverID.To4(),
+		subnets:  make([]*subnet, len(subnets)),
+		store:    store,
+		now:      time.Now,
+		clients:  make(map[string]*lease),
+		addrs:    make(map[uint32]*lease),
+	}
+
+	for i, s := range subnets {
+		t, err := newSubnet(s)
+		if err != nil {
+			return nil, err
+		}
+
+		h.subnets[i] = t
+	}
+
+	store.Iterate(func(sl dhcpv4.Lease) bool {
+		if sl.IP.To4() == nil || !sl.ServerID.Equal(h.serverID) {
+			return true
+		}
+
+		l := &lease{
+			client: sl.ClientKey(),
+			ip:     ipToUint32(sl.IP),
+			state:  leaseBound,
+			expiry: sl.Expiry,
+		}
+
+		h.clients[l.client] = l
+		h.addrs[l.ip] = l
+		return true
+	})
+
+	return &h, nil
+}
+
+// ServeDHCP implements the dhcpv4.Handler interface.
+func (h *Handler) ServeDHCP(req dhcpv4.Request) {
+	switch req := req.(type) {
+	case dhcpv4.DHCPDiscover:
+		if rep := h.offer(req); rep != nil {
+			req.WriteReply(rep)
+		}
+	case dhcpv4.DHCPRequest:
+		if rep := h.ack(req); rep != nil {
+			req.WriteReply(rep)
+		}
+	case dhcpv4.DHCPDecline:
+		h.decline(req)
+	case dhcpv4.DHCPRelease:
+		h.release(req)
+	case dhcpv4.DHCPInform:
+		if rep := h.inform(req); rep != nil {
+			req.WriteReply(rep)
+		}
+	}
+}
+
+// subnetFor returns the subnet a request should be served from. Relayed
+// requests are served from the subnet of the relay agent, requests from bound
+// clients from the subnet of the client address, and all other requests from
+// the subnet attached to the interface they arrived on.
+func (h *Handler) subnetFor(req dhcpv4.Request) *subnet {
+	if giaddr := req.GetGIAddr(); !giaddr.Equal(net.IPv4zero) {
+		for _, s := range h.subnets {
+			if s.Network.Contains(giaddr) {
+				return s
+			}
+		}
+
+		return nil
+	}
+
+	if ciaddr := req.GetCIAddr(); !ciaddr.Equal(net.IPv4zero) {
+		for _, s := range h.subnets {
+			if s.Network.Contains(ciaddr) {
+				return s
+			}
+		}
+	}
+
+	for _, s := range h.subnets {
+		if s.InterfaceIndex == 0 || s.InterfaceIndex == req.InterfaceIndex() {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// available returns whether the address can be bound to the client.
+func (h *Handler) available(s *subnet, req dhcpv4.Request, ip net.IP) bool {
+	if ip.To4() == nil || !s.Network.Contains(ip) {
+		return false
+	}
+
+	// A client with a reservation only ever gets its reserved address
+	if r := s.reservation(req); r != nil {
+		return r.Equal(ip)
+	}
+
+	if s.reserved(ip) || !s.inRange(ipToUint32(ip)) {
+		return false
+	}
+
+	l, ok := h.addrs[ipToUint32(ip)]
+	if !ok || h.now().After(l.expiry) {
+		return true
+	}
+
+	return l.state != leaseDeclined && l.client == clientKey(req)
+}
+
+// allocate returns the address to offer to the client, or nil if there is
+// none. It prefers the client's reservation, then the address the client was
+// previously bound to, then the address the client asks for, and finally any
+// available address.
+func (h *Handler) allocate(s *subnet, req dhcpv4.Request) net.IP {
+	if r := s.reservation(req); r != nil {
+		return r
+	}
+
+	if l, ok := h.clients[clientKey(req)]; ok {
+		if ip := uint32ToIP(l.ip); h.available(s, req, ip) {
+			return ip
+		}
+	}
+
+	if ip, ok := req.GetIP(dhcpv4.OptionAddressRequest); ok && h.available(s, req, ip) {
+		return ip.To4()
+	}
+
+	for _, r := range s.ranges {
+		for i := r.start; ; i++ {
+			if ip := uint32ToIP(i); h.available(s, req, ip) {
+				return ip
+			}
+
+			if i == r.end {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// unbind removes a lease from the lease tables.
+func (h *Handler) unbind(l *lease) {
+	if h.clients[l.client] == l {
+		delete(h.clients, l.client)
+	}
+
+	if h.addrs[l.ip] == l {
+		delete(h.addrs, l.ip)
+	}
+}
+
+// expire removes a lease from the lease tables and from the lease store.
+func (h *Handler) expire(l *lease) {
+	h.unbind(l)
+
+	if l.state == leaseBound {
+		h.store.Expire(uint32ToIP(l.ip))
+	}
+}
+
+// bind binds the address to the client, replacing the client's previous lease
+// and any previous lease for the address. Bound leases are written to the
+// lease store.
+func (h *Handler) bind(req dhcpv4.Request, ip net.IP, state leaseState, d time.Duration) error {
+	l := &lease{
+		client: clientKey(req),
+		ip:     ipToUint32(ip),
+		state:  state,
+		expiry: h.now().Add(d),
+	}
+
+	if state == leaseBound {
+		sl := dhcpv4.LeaseFromRequest(req)
+		sl.IP = ip.To4()
+		sl.ServerID = h.serverID
+		sl.LeaseTime = d
+		sl.Expiry = l.expiry
+
+		// Storing the lease replaces the previous leases in the store
+		if err := h.store.Put(sl); err != nil {
+			return err
+		}
+
+		if m, ok := h.clients[l.client]; ok {
+			h.unbind(m)
+		}
+
+		if m, ok := h.addrs[l.ip]; ok {
+			h.unbind(m)
+		}
+	} else {
+		if m, ok := h.clients[l.client]; ok {
+			h.expire(m)
+		}
+
+		if m, ok := h.addrs[l.ip]; ok {
+			h.expire(m)
+		}
+	}
+
+	h.clients[l.client] = l
+	h.addrs[l.ip] = l
+	return nil
+}
+
+// ourServerID returns whether the request is either not addressed to a
+// specific server, or addressed to this server.
+func (h *Handler) ourServerID(req dhcpv4.Request) bool {
+	sid, ok := req.GetIP(dhcpv4.OptionDHCPServerID)
+	return !ok || sid.Equal(h.serverID)
+}
+
+func (h *Handler) setOptions(rep dhcpv4.Reply, s *subnet) {
+	om := make(dhcpv4.OptionMap)
+	for o, v := range s.Options {
+		om.SetOption(o, v)
+	}
+
+	om.SetIP(dhcpv4.OptionSubnetMask, s.mask())
+
+	// Only return the options the client asked for
+	om.KeepRequestedOptions(rep.Request())
+	for o, v := range om {
+		rep.SetOption(o, v)
+	}
+
+	rep.SetIP(dhcpv4.OptionDHCPServerID, h.serverID)
+}
+
+func (h *Handler) setLeaseOptions(rep dhcpv4.Reply, s *subnet) {
+	rep.SetDuration(dhcpv4.OptionAddressTime, s.LeaseTime)
+	rep.SetDuration(dhcpv4.OptionRenewalTime, s.LeaseTime/2)
+	rep.SetDuration(dhcpv4.OptionRebindingTime, s.LeaseTime*7/8)
+}
+
+func (h *Handler) offer(req dhcpv4.DHCPDiscover) dhcpv4.Reply {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.subnetFor(req)
+	if s == nil {
+		return nil
+	}
+
+	ip := h.allocate(s, req)
+	if ip == nil {
+		return nil
+	}
+
+	// Hold the address for the client, unless it is already bound to it
+	l, ok := h.clients[clientKey(req)]
+	if !ok || l.state != leaseBound || l.ip != ipToUint32(ip) {
+		h.bind(req, ip, leaseOffered, offerTime)
+	}
+
+	rep := dhcpv4.CreateDHCPOffer(req)
+	rep.SetYIAddr(ip)
+	h.setOptions(rep, s)
+	h.setLeaseOptions(rep, s)
+	return rep
+}
+
+func (h *Handler) nak(req dhcpv4.DHCPRequest) dhcpv4.Reply {
+	rep := dhcpv4.CreateDHCPNak(req)
+	rep.SetIP(dhcpv4.OptionDHCPServerID, h.serverID)
+	return rep
+}
+
+func (h *Handler) ack(req dhcpv4.DHCPRequest) dhcpv4.Reply {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.subnetFor(req)
+	if s == nil {
+		return nil
+	}
+
+	state, ip := req.State()
+	if state == dhcpv4.RequestStateSelecting && !h.ourServerID(req) {
+		// The client picked another server; release the address we offered.
+		if l, ok := h.clients[clientKey(req)]; ok && l.state == leaseOffered {
+			h.unbind(l)
+		}
+
+		return nil
+	}
+
+	// From RFC2131, section 4.3.2: a client in the INIT-REBOOT state that is
+	// on the wrong network gets a DHCPNAK. Otherwise, a server that has no
+	// record of the client MUST remain silent, since another server may have
+	// the record, whether or not the address is available here.
+	if state == dhcpv4.RequestStateInitReboot {
+		if !s.Network.Contains(ip) {
+			return h.nak(req)
+		}
+
+		if _, ok := h.clients[clientKey(req)]; !ok {
+			return nil
+		}
+	}
+
+	if ip == nil || !h.available(s, req, ip) {
+		return h.nak(req)
+	}
+
+	// Don't acknowledge a lease that cannot be stored
+	if err := h.bind(req, ip, leaseBound, s.LeaseTime); err != nil {
+		return nil
+	}
+
+	rep := dhcpv4.CreateDHCPAck(req)
+	rep.SetCIAddr(req.GetCIAddr())
+	rep.SetYIAddr(ip)
+	h.setOptions(rep, s)
+	h.setLeaseOptions(rep, s)
+	return rep
+}
+
+func (h *Handler) decline(req dhcpv4.DHCPDecline) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.ourServerID(req) {
+		return
+	}
+
+	ip, ok := req.GetIP(dhcpv4.OptionAddressRequest)
+	if !ok {
+		return
+	}
+
+	l, ok := h.addrs[ipToUint32(ip)]
+	if !ok || l.client != clientKey(req) {
+		return
+	}
+
+	d := DefaultLeaseTime
+	if s := h.subnetFor(req); s != nil {
+		d = s.LeaseTime
+	}
+
+	// The address is in use by some other host; don't hand it out again
+	// until the lease time has passed.
+	h.expire(l)
+	l.client = ""
+	l.state = leaseDeclined
+	l.expiry = h.now().Add(d)
+	h.addrs[l.ip] = l
+}
+
+func (h *Handler) release(req dhcpv4.DHCPRelease) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.ourServerID(req) {
+		return
+	}
+
+	l, ok := h.clients[clientKey(req)]
+	if !ok || !uint32ToIP(l.ip).Equal(req.GetCIAddr()) {
+		return
+	}
+
+	h.expire(l)
+}
+
+func (h *Handler) inform(req dhcpv4.DHCPInform) dhcpv4.Reply {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.subnetFor(req)
+	if s == nil {
+		return nil
+	}
+
+	rep := dhcpv4.CreateDHCPAck(req)
+	rep.SetCIAddr(req.GetCIAddr())
+	h.setOptions(rep, s)
+	return rep
+}
@@ -0,0 +1,417 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pool
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/godhcpv4"
+)
+
+var (
+	testServerID = net.IPv4(192, 168, 1, 1)
+	testOtherID  = net.IPv4(192, 168, 1, 2)
+)
+
+type testReplyWriter struct {
+	replies []dhcpv4.Packet
+}
+
+func (w *testReplyWriter) WriteReply(r dhcpv4.Reply) error {
+	if err := r.Validate(); err != nil {
+		panic(err)
+	}
+
+	b, err := r.ToBytes()
+	if err != nil {
+		panic(err)
+	}
+
+	p, err := dhcpv4.PacketFromBytes(b)
+	if err != nil {
+		panic(err)
+	}
+
+	w.replies = append(w.replies, p)
+	return nil
+}
+
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func newTestHandler(t *testing.T) (*Handler, *testClock) {
+	h, err := NewHandler(testServerID, []Subnet{
+		{
+			Network: testNetwork("192.168.1.0/24"),
+			Ranges: []Range{
+				{net.IPv4(192, 168, 1, 10), net.IPv4(192, 168, 1, 12)},
+			},
+			Exclusions: []Range{
+				{net.IPv4(192, 168, 1, 11), net.IPv4(192, 168, 1, 11)},
+			},
+			Reservations: []Reservation{
+				{HardwareAddr: net.HardwareAddr{0, 0, 0, 0, 0, 0xff}, IP: net.IPv4(192, 168, 1, 100)},
+			},
+			LeaseTime: time.Hour,
+			Options: dhcpv4.OptionMap{
+				dhcpv4.OptionRouter: []byte{192, 168, 1, 1},
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testClock{t: time.Unix(1000000, 0)}
+	h.now = c.now
+	return h, c
+}
+
+func newTestPacket(t dhcpv4.MessageType, mac byte) dhcpv4.Packet {
+	p := dhcpv4.NewPacket(dhcpv4.BootRequest)
+	p.HType()[0] = 1
+	p.HLen()[0] = 6
+	copy(p.CHAddr(), []byte{0, 0, 0, 0, 0, mac})
+	p.SetMessageType(t)
+	return p
+}
+
+func discover(h *Handler, p dhcpv4.Packet) *dhcpv4.Packet {
+	rw := &testReplyWriter{}
+	h.ServeDHCP(dhcpv4.DHCPDiscover{Packet: p, ReplyWriter: rw})
+	if len(rw.replies) == 0 {
+		return nil
+	}
+
+	return &rw.replies[0]
+}
+
+func request(h *Handler, p dhcpv4.Packet) *dhcpv4.Packet {
+	rw := &testReplyWriter{}
+	h.ServeDHCP(dhcpv4.DHCPRequest{Packet: p, ReplyWriter: rw})
+	if len(rw.replies) == 0 {
+		return nil
+	}
+
+	return &rw.replies[0]
+}
+
+// dora runs a complete DISCOVER/OFFER/REQUEST/ACK exchange.
+func dora(t *testing.T, h *Handler, mac byte) net.IP {
+	offer := discover(h, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, mac))
+	if !assert.NotNil(t, offer) {
+		return nil
+	}
+
+	p := newTestPacket(dhcpv4.MessageTypeDHCPRequest, mac)
+	p.SetIP(dhcpv4.OptionDHCPServerID, testServerID)
+	p.SetIP(dhcpv4.OptionAddressRequest, offer.GetYIAddr())
+
+	ack := request(h, p)
+	if !assert.NotNil(t, ack) || !assert.Equal(t, dhcpv4.MessageTypeDHCPAck, ack.GetMessageType()) {
+		return nil
+	}
+
+	return ack.GetYIAddr()
+}
+
+func TestHandlerDiscoverRequest(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	offer := discover(h, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 1))
+	if !assert.NotNil(t, offer) {
+		return
+	}
+
+	assert.Equal(t, dhcpv4.MessageTypeDHCPOffer, offer.GetMessageType())
+	assert.Equal(t, net.IP{192, 168, 1, 10}, offer.GetYIAddr())
+	assertOptionIP(t, offer, dhcpv4.OptionDHCPServerID, testServerID)
+	assertOptionIP(t, offer, dhcpv4.OptionSubnetMask, net.IPv4(255, 255, 255, 0))
+	assertOptionIP(t, offer, dhcpv4.OptionRouter, net.IPv4(192, 168, 1, 1))
+
+	d, ok := offer.GetDuration(dhcpv4.OptionAddressTime)
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, d)
+
+	// Offering again yields the same address
+	again := discover(h, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 1))
+	if assert.NotNil(t, again) {
+		assert.Equal(t, offer.GetYIAddr(), again.GetYIAddr())
+	}
+
+	assert.Equal(t, net.IP{192, 168, 1, 10}, dora(t, h, 1))
+}
+
+func TestHandlerParameterList(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	p := newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 1)
+	p.SetOptionList(dhcpv4.OptionParameterList, []dhcpv4.Option{dhcpv4.OptionSubnetMask})
+
+	offer := discover(h, p)
+	if !assert.NotNil(t, offer) {
+		return
+	}
+
+	// The router option is configured, but not requested
+	assertOptionIP(t, offer, dhcpv4.OptionSubnetMask, net.IPv4(255, 255, 255, 0))
+	assertOptionIP(t, offer, dhcpv4.OptionDHCPServerID, testServerID)
+	_, ok := offer.GetOption(dhcpv4.OptionRouter)
+	assert.False(t, ok)
+}
+
+func assertOptionIP(t *testing.T, p *dhcpv4.Packet, o dhcpv4.Option, expected net.IP) {
+	ip, ok := p.GetIP(o)
+	if assert.True(t, ok) {
+		assert.Equal(t, expected, ip)
+	}
+}
+
+func TestHandlerExclusionsAndExhaustion(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	assert.Equal(t, net.IP{192, 168, 1, 10}, dora(t, h, 1))
+	assert.Equal(t, net.IP{192, 168, 1, 12}, dora(t, h, 2))
+	assert.Nil(t, discover(h, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 3)))
+}
+
+func TestHandlerRequestedAddress(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	p := newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 1)
+	p.SetIP(dhcpv4.OptionAddressRequest, net.IPv4(192, 168, 1, 12))
+
+	offer := discover(h, p)
+	if assert.NotNil(t, offer) {
+		assert.Equal(t, net.IP{192, 168, 1, 12}, offer.GetYIAddr())
+	}
+}
+
+func TestHandlerReservation(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	assert.Equal(t, net.IP{192, 168, 1, 100}, dora(t, h, 0xff))
+
+	// Nobody else gets a reserved address
+	dora(t, h, 1)
+
+	p := newTestPacket(dhcpv4.MessageTypeDHCPRequest, 1)
+	p.SetIP(dhcpv4.OptionAddressRequest, net.IPv4(192, 168, 1, 100))
+
+	nak := request(h, p)
+	if assert.NotNil(t, nak) {
+		assert.Equal(t, dhcpv4.MessageTypeDHCPNak, nak.GetMessageType())
+	}
+}
+
+func TestHandlerOfferExpires(t *testing.T) {
+	h, c := newTestHandler(t)
+
+	assert.NotNil(t, discover(h, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 1)))
+	assert.NotNil(t, discover(h, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 2)))
+	assert.Nil(t, discover(h, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 3)))
+
+	c.t = c.t.Add(offerTime + time.Second)
+	assert.NotNil(t, discover(h, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 3)))
+}
+
+func TestHandlerRequestOtherServer(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	assert.NotNil(t, discover(h, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 1)))
+	assert.NotNil(t, discover(h, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 2)))
+
+	// Client 1 picks another server, which frees up the offered address
+	p := newTestPacket(dhcpv4.MessageTypeDHCPRequest, 1)
+	p.SetIP(dhcpv4.OptionDHCPServerID, testOtherID)
+	p.SetIP(dhcpv4.OptionAddressRequest, net.IPv4(10, 0, 0, 1))
+	assert.Nil(t, request(h, p))
+
+	assert.Equal(t, net.IP{192, 168, 1, 10}, dora(t, h, 3))
+}
+
+func TestHandlerInitRebootWrongSubnet(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	p := newTestPacket(dhcpv4.MessageTypeDHCPRequest, 1)
+	p.SetIP(dhcpv4.OptionAddressRequest, net.IPv4(10, 0, 0, 1))
+
+	nak := request(h, p)
+	if assert.NotNil(t, nak) {
+		assert.Equal(t, dhcpv4.MessageTypeDHCPNak, nak.GetMessageType())
+		assertOptionIP(t, nak, dhcpv4.OptionDHCPServerID, testServerID)
+	}
+}
+
+func TestHandlerInitReboot(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	ip := dora(t, h, 1)
+
+	// The client that has the lease gets it acknowledged
+	p := newTestPacket(dhcpv4.MessageTypeDHCPRequest, 1)
+	p.SetIP(dhcpv4.OptionAddressRequest, ip)
+
+	ack := request(h, p)
+	if assert.NotNil(t, ack) {
+		assert.Equal(t, dhcpv4.MessageTypeDHCPAck, ack.GetMessageType())
+		assert.Equal(t, ip, ack.GetYIAddr())
+	}
+
+	// A client without a record is ignored, whether the address is free or
+	// leased to another client
+	p = newTestPacket(dhcpv4.MessageTypeDHCPRequest, 2)
+	p.SetIP(dhcpv4.OptionAddressRequest, net.IPv4(192, 168, 1, 12))
+	assert.Nil(t, request(h, p))
+
+	p = newTestPacket(dhcpv4.MessageTypeDHCPRequest, 2)
+	p.SetIP(dhcpv4.OptionAddressRequest, ip)
+	assert.Nil(t, request(h, p))
+
+	// Another client with a record asking for the same address gets a
+	// DHCPNAK
+	dora(t, h, 2)
+
+	nak := request(h, p)
+	if assert.NotNil(t, nak) {
+		assert.Equal(t, dhcpv4.MessageTypeDHCPNak, nak.GetMessageType())
+	}
+}
+
+func TestHandlerRenew(t *testing.T) {
+	h, c := newTestHandler(t)
+
+	ip := dora(t, h, 1)
+
+	c.t = c.t.Add(45 * time.Minute)
+
+	p := newTestPacket(dhcpv4.MessageTypeDHCPRequest, 1)
+	p.SetCIAddr(ip)
+
+	ack := request(h, p)
+	if assert.NotNil(t, ack) {
+		assert.Equal(t, dhcpv4.MessageTypeDHCPAck, ack.GetMessageType())
+		assert.Equal(t, ip, ack.GetCIAddr())
+		assert.Equal(t, ip, ack.GetYIAddr())
+	}
+
+	// Another client cannot renew the same address
+	p = newTestPacket(dhcpv4.MessageTypeDHCPRequest, 2)
+	p.SetCIAddr(ip)
+
+	nak := request(h, p)
+	if assert.NotNil(t, nak) {
+		assert.Equal(t, dhcpv4.MessageTypeDHCPNak, nak.GetMessageType())
+	}
+}
+
+func TestHandlerRelease(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	assert.Equal(t, net.IP{192, 168, 1, 10}, dora(t, h, 1))
+	assert.Equal(t, net.IP{192, 168, 1, 12}, dora(t, h, 2))
+
+	p := newTestPacket(dhcpv4.MessageTypeDHCPRelease, 1)
+	p.SetCIAddr(net.IPv4(192, 168, 1, 10))
+	p.SetIP(dhcpv4.OptionDHCPServerID, testServerID)
+	h.ServeDHCP(dhcpv4.DHCPRelease{Packet: p})
+
+	assert.Equal(t, net.IP{192, 168, 1, 10}, dora(t, h, 3))
+}
+
+func TestHandlerDecline(t *testing.T) {
+	h, c := newTestHandler(t)
+
+	assert.Equal(t, net.IP{192, 168, 1, 10}, dora(t, h, 1))
+
+	p := newTestPacket(dhcpv4.MessageTypeDHCPDecline, 1)
+	p.SetIP(dhcpv4.OptionAddressRequest, net.IPv4(192, 168, 1, 10))
+	p.SetIP(dhcpv4.OptionDHCPServerID, testServerID)
+	h.ServeDHCP(dhcpv4.DHCPDecline{Packet: p})
+
+	// The declined address is not handed out, not even to the same client
+	assert.Equal(t, net.IP{192, 168, 1, 12}, dora(t, h, 1))
+	assert.Nil(t, discover(h, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 2)))
+
+	c.t = c.t.Add(time.Hour + time.Second)
+	assert.Equal(t, net.IP{192, 168, 1, 10}, dora(t, h, 2))
+}
+
+func TestHandlerInform(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	p := newTestPacket(dhcpv4.MessageTypeDHCPInform, 1)
+	p.SetCIAddr(net.IPv4(192, 168, 1, 50))
+
+	rw := &testReplyWriter{}
+	h.ServeDHCP(dhcpv4.DHCPInform{Packet: p, ReplyWriter: rw})
+
+	if assert.Len(t, rw.replies, 1) {
+		ack := rw.replies[0]
+		assert.Equal(t, dhcpv4.MessageTypeDHCPAck, ack.GetMessageType())
+		assert.Equal(t, net.IP{192, 168, 1, 50}, ack.GetCIAddr())
+		assert.Equal(t, net.IP{0, 0, 0, 0}, ack.GetYIAddr())
+		assertOptionIP(t, &ack, dhcpv4.OptionRouter, net.IPv4(192, 168, 1, 1))
+
+		_, ok := ack.GetOption(dhcpv4.OptionAddressTime)
+		assert.False(t, ok)
+	}
+}
+
+func TestHandlerLeaseStore(t *testing.T) {
+	store := dhcpv4.NewMemoryLeaseStore()
+
+	h, _ := newTestHandler(t)
+	h.store = store
+
+	assert.Equal(t, net.IP{192, 168, 1, 10}, dora(t, h, 1))
+
+	if l, ok := store.GetByIP(net.IPv4(192, 168, 1, 10)); assert.True(t, ok) {
+		assert.Equal(t, net.HardwareAddr{0, 0, 0, 0, 0, 1}, l.HardwareAddr)
+		assert.Equal(t, net.IP{192, 168, 1, 1}, l.ServerID)
+		assert.Equal(t, time.Hour, l.LeaseTime)
+	}
+
+	// A new handler picks up the lease from the store
+	k, err := NewHandlerWithStore(testServerID, []Subnet{h.subnets[0].Subnet}, store)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	k.now = h.now
+
+	offer := discover(k, newTestPacket(dhcpv4.MessageTypeDHCPDiscover, 2))
+	if assert.NotNil(t, offer) {
+		assert.Equal(t, net.IP{192, 168, 1, 12}, offer.GetYIAddr())
+	}
+
+	// Releasing the lease removes it from the store
+	p := newTestPacket(dhcpv4.MessageTypeDHCPRelease, 1)
+	p.SetCIAddr(net.IPv4(192, 168, 1, 10))
+	k.ServeDHCP(dhcpv4.DHCPRelease{Packet: p})
+
+	_, ok := store.GetByIP(net.IPv4(192, 168, 1, 10))
+	assert.False(t, ok)
+}
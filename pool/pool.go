@@ -0,0 +1,235 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pool
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/vmware/godhcpv4"
+)
+
+var (
+	ErrInvalidSubnet      = errors.New("pool: invalid subnet")
+	ErrInvalidRange       = errors.New("pool: invalid range")
+	ErrInvalidReservation = errors.New("pool: invalid reservation")
+)
+
+// DefaultLeaseTime is the lease time used for subnets that don't specify one.
+const DefaultLeaseTime = 24 * time.Hour
+
+// Range defines an inclusive range of IPv4 addresses.
+type Range struct {
+	Start net.IP
+	End   net.IP
+}
+
+// Reservation statically binds an address to a client. The client is
+// identified by its client identifier if ClientID is set, and by its hardware
+// address otherwise.
+type Reservation struct {
+	HardwareAddr net.HardwareAddr
+	ClientID     []byte
+	IP           net.IP
+}
+
+// Subnet defines an IPv4 subnet and the addresses that can be allocated in it.
+type Subnet struct {
+	// Network is the address and mask of the subnet.
+	Network net.IPNet
+
+	// InterfaceIndex is the index of the interface this subnet is directly
+	// attached to. Requests that were not relayed are served from the subnet
+	// attached to the interface they arrived on. A zero index matches every
+	// interface.
+	InterfaceIndex int
+
+	// Ranges holds the ranges of addresses to allocate from.
+	Ranges []Range
+
+	// Exclusions holds ranges of addresses that are never allocated.
+	Exclusions []Range
+
+	// Reservations holds the static bindings for this subnet. Reserved
+	// addresses don't need to be part of Ranges.
+	Reservations []Reservation
+
+	// LeaseTime is the lease time handed to clients.
+	LeaseTime time.Duration
+
+	// Options holds the options to include in every DHCPOFFER and DHCPACK,
+	// such as routers and domain name servers.
+	Options dhcpv4.OptionMap
+}
+
+type addrRange struct {
+	start uint32
+	end   uint32
+}
+
+func (r addrRange) contains(ip uint32) bool {
+	return ip >= r.start && ip <= r.end
+}
+
+type subnet struct {
+	Subnet
+
+	ranges     []addrRange
+	exclusions []addrRange
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uint32ToIP(v uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip
+}
+
+func newAddrRange(r Range, n net.IPNet) (addrRange, error) {
+	if r.Start.To4() == nil || r.End.To4() == nil {
+		return addrRange{}, ErrInvalidRange
+	}
+
+	if !n.Contains(r.Start) || !n.Contains(r.End) {
+		return addrRange{}, ErrInvalidRange
+	}
+
+	ar := addrRange{
+		start: ipToUint32(r.Start),
+		end:   ipToUint32(r.End),
+	}
+
+	if ar.start > ar.end {
+		return addrRange{}, ErrInvalidRange
+	}
+
+	return ar, nil
+}
+
+func newSubnet(s Subnet) (*subnet, error) {
+	var err error
+
+	if s.Network.IP.To4() == nil || len(s.Network.Mask) != net.IPv4len {
+		return nil, ErrInvalidSubnet
+	}
+
+	if s.LeaseTime == 0 {
+		s.LeaseTime = DefaultLeaseTime
+	}
+
+	t := subnet{
+		Subnet:     s,
+		ranges:     make([]addrRange, len(s.Ranges)),
+		exclusions: make([]addrRange, len(s.Exclusions)),
+	}
+
+	for i, r := range s.Ranges {
+		if t.ranges[i], err = newAddrRange(r, s.Network); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, r := range s.Exclusions {
+		if t.exclusions[i], err = newAddrRange(r, s.Network); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, r := range s.Reservations {
+		if r.IP.To4() == nil || !s.Network.Contains(r.IP) {
+			return nil, ErrInvalidReservation
+		}
+
+		if r.HardwareAddr == nil && r.ClientID == nil {
+			return nil, ErrInvalidReservation
+		}
+	}
+
+	return &t, nil
+}
+
+// mask returns the subnet mask as an IP address.
+func (s *subnet) mask() net.IP {
+	return net.IP(s.Network.Mask)
+}
+
+// inRange returns whether the address can be dynamically allocated.
+func (s *subnet) inRange(ip uint32) bool {
+	n := ipToUint32(s.Network.IP)
+	m := binary.BigEndian.Uint32(s.Network.Mask)
+
+	// Never allocate the network or the broadcast address
+	if ip == n&m || ip == n|^m {
+		return false
+	}
+
+	for _, r := range s.exclusions {
+		if r.contains(ip) {
+			return false
+		}
+	}
+
+	for _, r := range s.ranges {
+		if r.contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reservation returns the address reserved for the specified client, if any.
+func (s *subnet) reservation(req dhcpv4.Request) net.IP {
+	id, hasID := req.GetOption(dhcpv4.OptionClientID)
+
+	for _, r := range s.Reservations {
+		if r.ClientID != nil {
+			if hasID && bytes.Equal(r.ClientID, id) {
+				return r.IP
+			}
+			continue
+		}
+
+		if bytes.Equal(r.HardwareAddr, req.GetCHAddr()) {
+			return r.IP
+		}
+	}
+
+	return nil
+}
+
+// reserved returns whether the address is reserved for any client.
+func (s *subnet) reserved(ip net.IP) bool {
+	for _, r := range s.Reservations {
+		if r.IP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientKey returns the key a client is known by.
+func clientKey(req dhcpv4.Request) string {
+	id, _ := req.GetOption(dhcpv4.OptionClientID)
+	return dhcpv4.ClientKey(id, req.GetCHAddr())
+}
@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pool
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/godhcpv4"
+)
+
+func testNetwork(s string) net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return *n
+}
+
+func TestNewSubnetValidation(t *testing.T) {
+	n := testNetwork("192.168.1.0/24")
+	ip := net.IPv4(192, 168, 1, 10)
+	out := net.IPv4(192, 168, 2, 10)
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+
+	testCases := []struct {
+		s   Subnet
+		err error
+	}{
+		{Subnet{Network: n}, nil},
+		{Subnet{}, ErrInvalidSubnet},
+		{Subnet{Network: testNetwork("2001:db8::/64")}, ErrInvalidSubnet},
+		{Subnet{Network: n, Ranges: []Range{{ip, ip}}}, nil},
+		{Subnet{Network: n, Ranges: []Range{{ip, out}}}, ErrInvalidRange},
+		{Subnet{Network: n, Ranges: []Range{{ip, net.IPv4(192, 168, 1, 9)}}}, ErrInvalidRange},
+		{Subnet{Network: n, Exclusions: []Range{{out, out}}}, ErrInvalidRange},
+		{Subnet{Network: n, Reservations: []Reservation{{HardwareAddr: mac, IP: ip}}}, nil},
+		{Subnet{Network: n, Reservations: []Reservation{{HardwareAddr: mac, IP: out}}}, ErrInvalidReservation},
+		{Subnet{Network: n, Reservations: []Reservation{{IP: ip}}}, ErrInvalidReservation},
+	}
+
+	for _, testCase := range testCases {
+		_, err := newSubnet(testCase.s)
+		assert.Equal(t, testCase.err, err)
+	}
+}
+
+func TestSubnetInRange(t *testing.T) {
+	s, err := newSubnet(Subnet{
+		Network: testNetwork("192.168.1.0/24"),
+		Ranges: []Range{
+			{net.IPv4(192, 168, 1, 0), net.IPv4(192, 168, 1, 255)},
+		},
+		Exclusions: []Range{
+			{net.IPv4(192, 168, 1, 10), net.IPv4(192, 168, 1, 19)},
+		},
+	})
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.False(t, s.inRange(ipToUint32(net.IPv4(192, 168, 1, 0))))
+	assert.True(t, s.inRange(ipToUint32(net.IPv4(192, 168, 1, 1))))
+	assert.True(t, s.inRange(ipToUint32(net.IPv4(192, 168, 1, 9))))
+	assert.False(t, s.inRange(ipToUint32(net.IPv4(192, 168, 1, 10))))
+	assert.False(t, s.inRange(ipToUint32(net.IPv4(192, 168, 1, 19))))
+	assert.True(t, s.inRange(ipToUint32(net.IPv4(192, 168, 1, 20))))
+	assert.True(t, s.inRange(ipToUint32(net.IPv4(192, 168, 1, 254))))
+	assert.False(t, s.inRange(ipToUint32(net.IPv4(192, 168, 1, 255))))
+	assert.False(t, s.inRange(ipToUint32(net.IPv4(192, 168, 2, 20))))
+}
+
+func TestSubnetReservation(t *testing.T) {
+	byMAC := net.IPv4(192, 168, 1, 10)
+	byID := net.IPv4(192, 168, 1, 11)
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+
+	s, err := newSubnet(Subnet{
+		Network: testNetwork("192.168.1.0/24"),
+		Reservations: []Reservation{
+			{HardwareAddr: mac, IP: byMAC},
+			{ClientID: []byte("client"), IP: byID},
+		},
+	})
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p := dhcpv4.NewPacket(dhcpv4.BootRequest)
+	assert.Nil(t, s.reservation(p))
+
+	p.HLen()[0] = 6
+	copy(p.CHAddr(), mac)
+	assert.Equal(t, byMAC, s.reservation(p))
+
+	p.SetOption(dhcpv4.OptionClientID, []byte("client"))
+	assert.Equal(t, byMAC, s.reservation(p))
+
+	copy(p.CHAddr(), []byte{1, 1, 1, 1, 1, 1})
+	assert.Equal(t, byID, s.reservation(p))
+
+	assert.True(t, s.reserved(byMAC))
+	assert.True(t, s.reserved(byID))
+	assert.False(t, s.reserved(net.IPv4(192, 168, 1, 12)))
+}
+
+func TestClientKey(t *testing.T) {
+	p := dhcpv4.NewPacket(dhcpv4.BootRequest)
+	p.HLen()[0] = 6
+	copy(p.CHAddr(), []byte{0, 1, 2, 3, 4, 5})
+	assert.Equal(t, "hw:000102030405", clientKey(p))
+
+	p.SetOption(dhcpv4.OptionClientID, []byte{1, 2})
+	assert.Equal(t, "id:0102", clientKey(p))
+}
@@ -0,0 +1,387 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+)
+
+var ErrNoHardwareAddr = errors.New("dhcpv4: no hardware address for destination")
+
+// From linux/if_packet.h
+const (
+	packetAuxdata        = 8
+	packetOutgoing       = 4
+	tpStatusCsumNotReady = 1 << 3
+	tpStatusVLANValid    = 1 << 4
+)
+
+// tpacketAuxdata mirrors struct tpacket_auxdata from linux/if_packet.h.
+type tpacketAuxdata struct {
+	Status   uint32
+	Len      uint32
+	Snaplen  uint32
+	Mac      uint16
+	Net      uint16
+	VLANTCI  uint16
+	VLANTPID uint16
+}
+
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
+
+type rawPacketConn struct {
+	f     *os.File
+	rc    syscall.RawConn
+	ifi   *net.Interface
+	local RawAddr
+
+	// Buffers for frames and control messages, reused between reads
+	rmu sync.Mutex
+	buf []byte
+	oob []byte
+}
+
+// udpFilter returns a BPF program that only accepts IPv4 UDP datagrams for
+// port, in Ethernet frames with or without an 802.1Q tag, so that the other
+// traffic on the link isn't copied to userspace. Fragments are not accepted.
+func udpFilter(port int) []bpf.Instruction {
+	const (
+		accept = 20
+		drop   = 21
+	)
+
+	prog := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: etherTypeVLAN, SkipTrue: 9},
+	}
+
+	// The IPv4 header follows the EtherType, with or without a VLAN tag
+	for _, o := range []uint32{14, 18} {
+		// Number of instructions to skip to get from the next one to i
+		skip := func(i int) uint8 {
+			return uint8(i - len(prog) - 1)
+		}
+
+		prog = append(prog, bpf.LoadAbsolute{Off: o - 2, Size: 2})
+		prog = append(prog, bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: etherTypeIPv4, SkipTrue: skip(drop)})
+		prog = append(prog, bpf.LoadAbsolute{Off: o + 9, Size: 1})
+		prog = append(prog, bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: syscall.IPPROTO_UDP, SkipTrue: skip(drop)})
+		prog = append(prog, bpf.LoadAbsolute{Off: o + 6, Size: 2})
+		prog = append(prog, bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x3fff, SkipTrue: skip(drop)})
+		prog = append(prog, bpf.LoadMemShift{Off: o})
+		prog = append(prog, bpf.LoadIndirect{Off: o + 2, Size: 2})
+		prog = append(prog, bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(port), SkipTrue: skip(accept), SkipFalse: skip(drop)})
+	}
+
+	return append(prog, bpf.RetConstant{Val: 1 << 18}, bpf.RetConstant{Val: 0})
+}
+
+// attachFilter attaches the BPF program prog to socket fd.
+func attachFilter(fd int, prog []bpf.Instruction) error {
+	raw, err := bpf.Assemble(prog)
+	if err != nil {
+		return err
+	}
+
+	filter := make([]syscall.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = syscall.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+
+	fprog := syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd),
+		syscall.SOL_SOCKET, syscall.SO_ATTACH_FILTER,
+		uintptr(unsafe.Pointer(&fprog)), unsafe.Sizeof(fprog), 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// NewRawPacketConn returns a PacketConn that reads and writes Ethernet frames
+// on the specified interface through an AF_PACKET socket. It only receives UDP
+// datagrams destined for the port of addr, which are filtered in the kernel,
+// and uses the IP address and port of addr as source of the datagrams it
+// sends. Because it is not limited by the IP configuration of the interface,
+// it can receive packets on interfaces that have no IPv4 address, and send
+// unicast packets to clients that do not have an address yet. Opening an
+// AF_PACKET socket requires CAP_NET_RAW.
+//
+// The addresses returned by ReadFrom are of type *RawAddr, and include the
+// hardware address of the sender and the VLAN the frame was received on. The
+// address passed to WriteTo can either be a *RawAddr or a *net.UDPAddr. The
+// latter can only be used to send broadcast packets.
+func NewRawPacketConn(ifi *net.Interface, addr *net.UDPAddr) (PacketConn, error) {
+	// Frames are only received once the socket is bound, after the filter
+	// is attached
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = attachFilter(fd, udpFilter(addr.Port)); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	sa := syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ALL),
+		Ifindex:  ifi.Index,
+	}
+
+	if err = syscall.Bind(fd, &sa); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// Have the kernel tell us about VLAN tags it stripped from frames
+	if err = syscall.SetsockoptInt(fd, syscall.SOL_PACKET, packetAuxdata, 1); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// Integrate with the runtime's network poller so Close unblocks ReadFrom
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), "packet")
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	p := rawPacketConn{
+		f:   f,
+		rc:  rc,
+		ifi: ifi,
+		local: RawAddr{
+			UDPAddr:      *addr,
+			HardwareAddr: ifi.HardwareAddr,
+		},
+	}
+
+	if p.local.IP == nil {
+		p.local.IP = net.IPv4zero
+	}
+
+	// The loopback interface has no hardware address
+	if len(p.local.HardwareAddr) == 0 {
+		p.local.HardwareAddr = make(net.HardwareAddr, 6)
+	}
+
+	return &p, nil
+}
+
+// auxdata returns the PACKET_AUXDATA control message in oob. It returns the
+// zero value if there is none.
+func auxdata(oob []byte) tpacketAuxdata {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return tpacketAuxdata{}
+	}
+
+	for _, m := range msgs {
+		if m.Header.Level != syscall.SOL_PACKET || m.Header.Type != packetAuxdata {
+			continue
+		}
+
+		if len(m.Data) < int(unsafe.Sizeof(tpacketAuxdata{})) {
+			continue
+		}
+
+		return *(*tpacketAuxdata)(unsafe.Pointer(&m.Data[0]))
+	}
+
+	return tpacketAuxdata{}
+}
+
+// vlan returns the VLAN the kernel stripped from the frame, if any.
+func (aux tpacketAuxdata) vlan() int {
+	if aux.Status&tpStatusVLANValid != 0 {
+		return int(aux.VLANTCI & 0xfff)
+	}
+
+	return 0
+}
+
+// frameFromAuxdata deserializes the frame in b. Frames from local peers, such
+// as virtual machines and containers, may only have a partial checksum that
+// the kernel would leave for the hardware to complete, so the UDP checksum is
+// not verified if the kernel says it is not ready.
+func frameFromAuxdata(b []byte, aux tpacketAuxdata) (Frame, error) {
+	if aux.Status&tpStatusCsumNotReady != 0 {
+		return FrameFromBytesUnverified(b)
+	}
+
+	return FrameFromBytes(b)
+}
+
+// ReadFrom reads a frame from the connection and copies the UDP payload into
+// b. Frames that do not carry a UDP datagram for the connection's port are
+// skipped.
+func (p *rawPacketConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	n, src, _, ifindex, err := p.ReadFromDestination(b)
+	return n, src, ifindex, err
+}
+
+// ReadFromDestination is like ReadFrom, but also returns the destination IP
+// address of the frame.
+func (p *rawPacketConn) ReadFromDestination(b []byte) (int, net.Addr, net.IP, int, error) {
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+
+	// Leave room for the Ethernet, 802.1Q, IPv4 and UDP headers
+	size := len(b) + 14 + 4 + 60 + 8
+	if len(p.buf) < size {
+		p.buf = make([]byte, size)
+	}
+
+	if p.oob == nil {
+		p.oob = make([]byte, syscall.CmsgSpace(int(unsafe.Sizeof(tpacketAuxdata{}))))
+	}
+
+	buf, oob := p.buf[:size], p.oob
+
+	for {
+		var n, oobn int
+		var from syscall.Sockaddr
+		var rerr error
+
+		err := p.rc.Read(func(fd uintptr) bool {
+			n, oobn, _, from, rerr = syscall.Recvmsg(int(fd), buf, oob, 0)
+			return rerr != syscall.EAGAIN
+		})
+		if err == nil {
+			err = rerr
+		}
+		if err != nil {
+			return 0, nil, nil, -1, err
+		}
+
+		// Skip frames sent by this host
+		sa, ok := from.(*syscall.SockaddrLinklayer)
+		if !ok || sa.Pkttype == packetOutgoing {
+			continue
+		}
+
+		aux := auxdata(oob[:oobn])
+
+		f, err := frameFromAuxdata(buf[:n], aux)
+		if err != nil || f.Dst.Port != p.local.Port {
+			continue
+		}
+
+		// The kernel may have stripped the VLAN tag from the frame
+		if vlan := aux.vlan(); vlan != 0 {
+			f.Src.VLAN = vlan
+		}
+
+		src := f.Src
+		src.HardwareAddr = append(net.HardwareAddr(nil), src.HardwareAddr...)
+		dst := append(net.IP(nil), f.Dst.IP...)
+		return copy(b, f.Payload), &src, dst, sa.Ifindex, nil
+	}
+}
+
+// WriteTo wraps b in an Ethernet frame and writes it to addr.
+func (p *rawPacketConn) WriteTo(b []byte, addr net.Addr, ifindex int) (int, error) {
+	var dst RawAddr
+
+	switch a := addr.(type) {
+	case *RawAddr:
+		dst = *a
+	case *net.UDPAddr:
+		dst.UDPAddr = *a
+	default:
+		return 0, syscall.EAFNOSUPPORT
+	}
+
+	if dst.IP.Equal(net.IPv4bcast) {
+		dst.HardwareAddr = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	}
+
+	if len(dst.HardwareAddr) != 6 {
+		return 0, ErrNoHardwareAddr
+	}
+
+	if ifindex == 0 {
+		ifindex = p.ifi.Index
+	}
+
+	f := Frame{
+		Src:     p.local,
+		Dst:     dst,
+		Payload: b,
+	}
+
+	sa := syscall.SockaddrLinklayer{
+		Protocol: htons(etherTypeIPv4),
+		Ifindex:  ifindex,
+		Halen:    6,
+	}
+	copy(sa.Addr[:], dst.HardwareAddr)
+
+	buf := FrameToBytes(f)
+
+	var werr error
+	err := p.rc.Write(func(fd uintptr) bool {
+		werr = syscall.Sendto(int(fd), buf, 0, &sa)
+		return werr != syscall.EAGAIN
+	})
+	if err == nil {
+		err = werr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// SetReadDeadline sets the deadline for pending and future ReadFrom calls.
+func (p *rawPacketConn) SetReadDeadline(t time.Time) error {
+	return p.f.SetReadDeadline(t)
+}
+
+func (p *rawPacketConn) Close() error {
+	return p.f.Close()
+}
+
+func (p *rawPacketConn) LocalAddr() net.Addr {
+	local := p.local
+	return &local
+}
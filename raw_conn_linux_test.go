@@ -0,0 +1,164 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/bpf"
+)
+
+func TestAuxdataVLAN(t *testing.T) {
+	aux := tpacketAuxdata{
+		Status:  tpStatusVLANValid,
+		VLANTCI: 0x2000 | 100, // Priority 1, VLAN 100
+	}
+
+	size := int(unsafe.Sizeof(aux))
+	oob := make([]byte, syscall.CmsgSpace(size))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = syscall.SOL_PACKET
+	h.Type = packetAuxdata
+	h.SetLen(syscall.CmsgLen(size))
+	*(*tpacketAuxdata)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])) = aux
+
+	assert.Equal(t, 100, auxdata(oob).vlan())
+
+	// Without a valid VLAN
+	(*tpacketAuxdata)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])).Status = 0
+	assert.Equal(t, 0, auxdata(oob).vlan())
+
+	// Without auxdata
+	assert.Equal(t, 0, auxdata(nil).vlan())
+}
+
+func TestFrameFromAuxdataChecksumNotReady(t *testing.T) {
+	f := Frame{
+		Src:     RawAddr{UDPAddr: net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 68}},
+		Dst:     RawAddr{UDPAddr: net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 67}},
+		Payload: []byte("xyz"),
+	}
+
+	// A partial checksum, as left for the hardware to complete
+	b := FrameToBytes(f)
+	b[14+20+6] ^= 0xff
+
+	_, err := frameFromAuxdata(b, tpacketAuxdata{})
+	assert.Equal(t, ErrInvalidChecksum, err)
+
+	fX, err := frameFromAuxdata(b, tpacketAuxdata{Status: tpStatusCsumNotReady})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte("xyz"), fX.Payload)
+	}
+}
+
+func TestUDPFilter(t *testing.T) {
+	vm, err := bpf.NewVM(udpFilter(67))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	frame := func(port, vlan int) []byte {
+		return FrameToBytes(Frame{
+			Src:     RawAddr{UDPAddr: net.UDPAddr{IP: net.IPv4zero, Port: 68}},
+			Dst:     RawAddr{UDPAddr: net.UDPAddr{IP: net.IPv4bcast, Port: port}, VLAN: vlan},
+			Payload: []byte("xyz"),
+		})
+	}
+
+	// IPv4 header with options
+	options := frame(67, 0)
+	options[14] = 0x46
+	options = append(options[:14+20], append([]byte{1, 1, 1, 1}, options[14+20:]...)...)
+
+	fragment := frame(67, 0)
+	fragment[14+6] = 0x20 // More fragments
+
+	other := frame(67, 0)
+	other[12] = 0x86 // IPv6
+	other[13] = 0xdd
+
+	testCases := []struct {
+		b      []byte
+		accept bool
+	}{
+		{frame(67, 0), true},
+		{frame(67, 100), true},
+		{options, true},
+		{frame(68, 0), false},
+		{frame(68, 100), false},
+		{fragment, false},
+		{other, false},
+	}
+
+	for _, tc := range testCases {
+		n, err := vm.Run(tc.b)
+		if assert.NoError(t, err) {
+			assert.Equal(t, tc.accept, n > 0)
+		}
+	}
+}
+
+func TestRawPacketConnWriteToRequiresHardwareAddr(t *testing.T) {
+	p := rawPacketConn{
+		ifi: &net.Interface{Index: 1},
+		local: RawAddr{
+			HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		},
+	}
+
+	_, err := p.WriteTo([]byte("xyz"), &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 68}, 1)
+	assert.Equal(t, ErrNoHardwareAddr, err)
+}
+
+func TestRawPacketConnLoopback(t *testing.T) {
+	ifi, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6767}
+	pc, err := NewRawPacketConn(ifi, addr)
+	if err != nil {
+		t.Skipf("no raw socket: %s", err)
+	}
+	defer pc.Close()
+
+	dst := &RawAddr{
+		UDPAddr:      *addr,
+		HardwareAddr: make(net.HardwareAddr, 6),
+	}
+
+	_, err = pc.WriteTo([]byte("xyz"), dst, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	b := make([]byte, 1500)
+	n, src, ifindex, err := pc.ReadFrom(b)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte("xyz"), b[:n])
+		assert.Equal(t, ifi.Index, ifindex)
+		if raw, ok := src.(*RawAddr); assert.True(t, ok) {
+			assert.True(t, addr.IP.Equal(raw.IP))
+			assert.Equal(t, addr.Port, raw.Port)
+		}
+	}
+}
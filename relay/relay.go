@@ -0,0 +1,229 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package relay
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/vmware/godhcpv4"
+)
+
+var ErrNoInterfaceAddr = errors.New("relay: interface has no IPv4 address")
+
+// DefaultMaxHops is the hop count above which requests are discarded if
+// MaxHops is not set. RFC1542 section 4.1.1 recommends a default of 4.
+const DefaultMaxHops = 4
+
+// Relay is a BOOTP/DHCP relay agent as defined in RFC1542. It relays requests
+// from clients to one or more servers and relays replies from those servers
+// back to the clients.
+type Relay struct {
+	// Servers lists the addresses of the servers requests are relayed to.
+	Servers []net.IP
+
+	// MaxHops is the hop count above which requests are discarded. Defaults
+	// to DefaultMaxHops.
+	MaxHops int
+
+	// InterfaceAddr returns the address of the interface with the specified
+	// index. This address is used as relay agent address in requests that
+	// arrive on that interface. Defaults to the first IPv4 address of the
+	// interface.
+	InterfaceAddr func(ifindex int) (net.IP, error)
+
+	// ErrorLog, if set, is used to log packets that can't be relayed because
+	// the relay agent address can't be determined or writing them failed.
+	ErrorLog *log.Logger
+
+	mu     sync.Mutex
+	ifaces map[string]int
+}
+
+func interfaceAddr(ifindex int) (net.IP, error) {
+	ifi, err := net.InterfaceByIndex(ifindex)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4(), nil
+		}
+	}
+
+	return nil, ErrNoInterfaceAddr
+}
+
+// giaddr returns the relay agent address for the interface with the specified
+// index, and remembers the interface so replies can be relayed back to it.
+func (r *Relay) giaddr(ifindex int) (net.IP, error) {
+	fn := r.InterfaceAddr
+	if fn == nil {
+		fn = interfaceAddr
+	}
+
+	ip, err := fn(ifindex)
+	if err != nil {
+		return nil, err
+	}
+
+	ip = ip.To4()
+	if ip == nil {
+		return nil, ErrNoInterfaceAddr
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ifaces == nil {
+		r.ifaces = make(map[string]int)
+	}
+
+	r.ifaces[string(ip)] = ifindex
+	return ip, nil
+}
+
+func (r *Relay) logf(format string, args ...interface{}) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	}
+}
+
+// ifindex returns the index of the interface with the specified relay agent
+// address.
+func (r *Relay) ifindex(giaddr net.IP) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ifindex, ok := r.ifaces[string(giaddr.To4())]
+	return ifindex, ok
+}
+
+// relayRequest relays a request that arrived on the interface with the
+// specified index to the servers, as described in RFC1542 section 4.1.1.
+func (r *Relay) relayRequest(pw dhcpv4.PacketWriter, p dhcpv4.Packet, ifindex int) {
+	maxHops := r.MaxHops
+	if maxHops == 0 {
+		maxHops = DefaultMaxHops
+	}
+
+	// Discard requests that have been relayed too many times
+	hops := int(p.Hops()[0])
+	if hops > maxHops {
+		return
+	}
+
+	p.Hops()[0] = byte(hops + 1)
+
+	// Fill in the relay agent address, unless a relay agent closer to the
+	// client already did.
+	if p.GetGIAddr().Equal(net.IPv4zero) {
+		giaddr, err := r.giaddr(ifindex)
+		if err != nil {
+			r.logf("relay: no relay agent address for interface %d: %s", ifindex, err)
+			return
+		}
+
+		p.SetGIAddr(giaddr)
+	}
+
+	for _, server := range r.Servers {
+		addr := net.UDPAddr{
+			IP:   server,
+			Port: 67,
+		}
+
+		if _, err := pw.WriteTo(p.RawPacket, &addr, 0); err != nil {
+			r.logf("relay: relaying request to %s: %s", &addr, err)
+		}
+	}
+}
+
+// relayReply relays a reply from a server back to the client, as described in
+// RFC1542 section 4.1.2. If hw is set, pw deals in frames and the reply can be
+// sent to the hardware address of the client.
+func (r *Relay) relayReply(pw dhcpv4.PacketWriter, p dhcpv4.Packet, hw bool) {
+	// Discard replies that were not relayed by this relay agent
+	ifindex, ok := r.ifindex(p.GetGIAddr())
+	if !ok {
+		return
+	}
+
+	var addr net.Addr
+	udp := net.UDPAddr{
+		IP:   p.GetCIAddr(),
+		Port: 68,
+	}
+
+	bcast := p.GetFlags()[0] & 128
+
+	// A client without an address can only be sent a unicast if the reply
+	// can be sent to its hardware address, since it can't answer ARP. It is
+	// broadcast otherwise, or if the client explicitly asks for a broadcast
+	// reply.
+	switch {
+	case !udp.IP.Equal(net.IPv4zero):
+		addr = &udp
+	case hw && bcast == 0 && p.GetHLen() == 6 && !p.GetYIAddr().Equal(net.IPv4zero):
+		udp.IP = p.GetYIAddr()
+		addr = &dhcpv4.RawAddr{
+			UDPAddr:      udp,
+			HardwareAddr: net.HardwareAddr(p.GetCHAddr()[:6]),
+		}
+	default:
+		udp.IP = net.IPv4bcast
+		addr = &udp
+	}
+
+	if _, err := pw.WriteTo(p.RawPacket, addr, ifindex); err != nil {
+		r.logf("relay: relaying reply to %s on interface %d: %s", addr, ifindex, err)
+	}
+}
+
+// Serve reads packets off the network and relays them. The connection should
+// be bound to port 67, where it receives both requests from clients and
+// replies from servers.
+func (r *Relay) Serve(pc dhcpv4.PacketConn) error {
+	buf := make([]byte, 65536)
+
+	for {
+		n, addr, ifindex, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		p, err := dhcpv4.PacketFromBytes(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch dhcpv4.OpCode(p.Op()[0]) {
+		case dhcpv4.BootRequest:
+			r.relayRequest(pc, p, ifindex)
+		case dhcpv4.BootReply:
+			_, hw := addr.(*dhcpv4.RawAddr)
+			r.relayReply(pc, p, hw)
+		}
+	}
+}
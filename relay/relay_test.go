@@ -0,0 +1,292 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/godhcpv4"
+)
+
+type testPacket struct {
+	b       []byte
+	addr    net.Addr
+	ifindex int
+}
+
+// testPacketConn hands out the packets in reads and records the packets
+// written to it in writes.
+type testPacketConn struct {
+	reads  []testPacket
+	writes []testPacket
+
+	// Returned by WriteTo, if set
+	err error
+}
+
+func (pc *testPacketConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	if len(pc.reads) == 0 {
+		return 0, nil, 0, io.EOF
+	}
+
+	p := pc.reads[0]
+	pc.reads = pc.reads[1:]
+	return copy(b, p.b), p.addr, p.ifindex, nil
+}
+
+func (pc *testPacketConn) WriteTo(b []byte, addr net.Addr, ifindex int) (int, error) {
+	if pc.err != nil {
+		return 0, pc.err
+	}
+
+	c := make([]byte, len(b))
+	copy(c, b)
+	pc.writes = append(pc.writes, testPacket{c, addr, ifindex})
+	return len(b), nil
+}
+
+func (pc *testPacketConn) Close() error {
+	return nil
+}
+
+func (pc *testPacketConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: 67}
+}
+
+func (pc *testPacketConn) read(p dhcpv4.Packet, ifindex int) {
+	b, err := dhcpv4.PacketToBytes(p, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4zero, Port: 68}
+	pc.reads = append(pc.reads, testPacket{b, addr, ifindex})
+}
+
+func (pc *testPacketConn) written(t *testing.T, i int) (dhcpv4.Packet, *net.UDPAddr, int) {
+	p, err := dhcpv4.PacketFromBytes(pc.writes[i].b)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return p, pc.writes[i].addr.(*net.UDPAddr), pc.writes[i].ifindex
+}
+
+var (
+	testServers = []net.IP{
+		net.IPv4(10, 0, 0, 1),
+		net.IPv4(10, 0, 0, 2),
+	}
+
+	testInterfaces = map[int]net.IP{
+		2: net.IPv4(192, 168, 1, 1),
+		3: net.IPv4(192, 168, 2, 1),
+	}
+)
+
+func newTestRelay() *Relay {
+	return &Relay{
+		Servers: testServers,
+		InterfaceAddr: func(ifindex int) (net.IP, error) {
+			ip, ok := testInterfaces[ifindex]
+			if !ok {
+				return nil, ErrNoInterfaceAddr
+			}
+			return ip, nil
+		},
+	}
+}
+
+func newTestRequest() dhcpv4.Packet {
+	p := dhcpv4.CreateDHCPDiscover([]byte{0, 1, 2, 3, 4, 5})
+	return p.Packet
+}
+
+func newTestReply(req dhcpv4.Packet) dhcpv4.Packet {
+	p := dhcpv4.NewPacket(dhcpv4.BootReply)
+	copy(p.XID(), req.XID())
+	copy(p.HLen(), req.HLen())
+	copy(p.CHAddr(), req.CHAddr())
+	p.SetGIAddr(req.GetGIAddr())
+	p.SetYIAddr(net.IPv4(192, 168, 2, 10))
+	p.SetMessageType(dhcpv4.MessageTypeDHCPOffer)
+	return p
+}
+
+func TestRelayRequest(t *testing.T) {
+	r := newTestRelay()
+	pc := &testPacketConn{}
+
+	pc.read(newTestRequest(), 3)
+	assert.Equal(t, io.EOF, r.Serve(pc))
+
+	if assert.Len(t, pc.writes, len(testServers)) {
+		for i, server := range testServers {
+			p, addr, _ := pc.written(t, i)
+			assert.True(t, server.Equal(addr.IP))
+			assert.Equal(t, 67, addr.Port)
+			assert.Equal(t, byte(1), p.Hops()[0])
+			assert.True(t, testInterfaces[3].Equal(p.GetGIAddr()))
+		}
+	}
+}
+
+func TestRelayRequestKeepsGIAddr(t *testing.T) {
+	r := newTestRelay()
+	pc := &testPacketConn{}
+
+	req := newTestRequest()
+	req.Hops()[0] = 1
+	req.SetGIAddr(net.IPv4(172, 16, 0, 1))
+
+	pc.read(req, 2)
+	assert.Equal(t, io.EOF, r.Serve(pc))
+
+	if assert.Len(t, pc.writes, len(testServers)) {
+		p, _, _ := pc.written(t, 0)
+		assert.Equal(t, byte(2), p.Hops()[0])
+		assert.True(t, net.IPv4(172, 16, 0, 1).Equal(p.GetGIAddr()))
+	}
+}
+
+func TestRelayRequestMaxHops(t *testing.T) {
+	r := newTestRelay()
+	r.MaxHops = 2
+	pc := &testPacketConn{}
+
+	req := newTestRequest()
+	req.Hops()[0] = 2
+	pc.read(req, 2)
+
+	req = newTestRequest()
+	req.Hops()[0] = 3
+	pc.read(req, 2)
+
+	assert.Equal(t, io.EOF, r.Serve(pc))
+	assert.Len(t, pc.writes, len(testServers))
+}
+
+func TestRelayRequestNoInterfaceAddr(t *testing.T) {
+	r := newTestRelay()
+	pc := &testPacketConn{}
+
+	pc.read(newTestRequest(), 4)
+	assert.Equal(t, io.EOF, r.Serve(pc))
+	assert.Len(t, pc.writes, 0)
+}
+
+func TestRelayReply(t *testing.T) {
+	r := newTestRelay()
+	pc := &testPacketConn{}
+
+	pc.read(newTestRequest(), 3)
+	assert.Equal(t, io.EOF, r.Serve(pc))
+	req, _, _ := pc.written(t, 0)
+	pc.writes = nil
+
+	// Broadcast, since the client can't answer ARP for yiaddr
+	rep := newTestReply(req)
+	pc.read(rep, 1)
+
+	// Broadcast if the client asks for it
+	rep = newTestReply(req)
+	rep.Flags()[0] |= 128
+	pc.read(rep, 1)
+
+	// Unicast to ciaddr
+	rep = newTestReply(req)
+	rep.SetCIAddr(net.IPv4(192, 168, 2, 20))
+	pc.read(rep, 1)
+
+	assert.Equal(t, io.EOF, r.Serve(pc))
+
+	expected := []net.IP{
+		net.IPv4bcast,
+		net.IPv4bcast,
+		net.IPv4(192, 168, 2, 20),
+	}
+
+	if assert.Len(t, pc.writes, len(expected)) {
+		for i, ip := range expected {
+			_, addr, ifindex := pc.written(t, i)
+			assert.True(t, ip.Equal(addr.IP))
+			assert.Equal(t, 68, addr.Port)
+			assert.Equal(t, 3, ifindex)
+		}
+	}
+}
+
+func TestRelayReplyRawAddr(t *testing.T) {
+	r := newTestRelay()
+	pc := &testPacketConn{}
+
+	pc.read(newTestRequest(), 3)
+	assert.Equal(t, io.EOF, r.Serve(pc))
+	req, _, _ := pc.written(t, 0)
+	pc.writes = nil
+
+	// On a connection that deals in frames, the reply is unicast to the
+	// hardware address of the client
+	b, err := dhcpv4.PacketToBytes(newTestReply(req), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	pc.reads = append(pc.reads, testPacket{b, &dhcpv4.RawAddr{}, 1})
+	assert.Equal(t, io.EOF, r.Serve(pc))
+
+	if assert.Len(t, pc.writes, 1) {
+		addr, ok := pc.writes[0].addr.(*dhcpv4.RawAddr)
+		if assert.True(t, ok) {
+			assert.True(t, net.IPv4(192, 168, 2, 10).Equal(addr.IP))
+			assert.Equal(t, net.HardwareAddr{0, 1, 2, 3, 4, 5}, addr.HardwareAddr)
+		}
+	}
+}
+
+func TestRelayLogsWriteErrors(t *testing.T) {
+	var buf bytes.Buffer
+
+	r := newTestRelay()
+	r.ErrorLog = log.New(&buf, "", 0)
+	pc := &testPacketConn{err: errors.New("write failed")}
+
+	pc.read(newTestRequest(), 3)
+	pc.read(newTestRequest(), 4)
+	assert.Equal(t, io.EOF, r.Serve(pc))
+
+	assert.Equal(t, "relay: relaying request to 10.0.0.1:67: write failed\n"+
+		"relay: relaying request to 10.0.0.2:67: write failed\n"+
+		"relay: no relay agent address for interface 4: relay: interface has no IPv4 address\n", buf.String())
+}
+
+func TestRelayReplyUnknownGIAddr(t *testing.T) {
+	r := newTestRelay()
+	pc := &testPacketConn{}
+
+	req := newTestRequest()
+	req.SetGIAddr(net.IPv4(192, 168, 1, 1))
+	pc.read(newTestReply(req), 1)
+
+	assert.Equal(t, io.EOF, r.Serve(pc))
+	assert.Len(t, pc.writes, 0)
+}
@@ -0,0 +1,233 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// RelayAgentSubOption is the type for the sub-option tags of the relay agent
+// information option (option 82).
+type RelayAgentSubOption byte
+
+// From RFC3046: DHCP Relay Agent Information Option
+const (
+	RelayAgentCircuitID = RelayAgentSubOption(1)
+	RelayAgentRemoteID  = RelayAgentSubOption(2)
+)
+
+// From RFC3527: Link Selection sub-option for the Relay Agent Information Option for DHCPv4
+const (
+	RelayAgentLinkSelection = RelayAgentSubOption(5)
+)
+
+// From RFC3993: Subscriber-ID Suboption for the Dynamic Host Configuration Protocol (DHCP) Relay Agent Option
+const (
+	RelayAgentSubscriberID = RelayAgentSubOption(6)
+)
+
+// From RFC5010: The Dynamic Host Configuration Protocol Version 4 (DHCPv4) Relay Agent Flags Suboption
+const (
+	RelayAgentFlags = RelayAgentSubOption(10)
+)
+
+// From RFC5107: DHCP Server Identifier Override Suboption
+const (
+	RelayAgentServerIDOverride = RelayAgentSubOption(11)
+)
+
+// RelayFlagUnicast is set in the relay agent flags sub-option if the relay
+// agent received the request as unicast (RFC5010).
+const RelayFlagUnicast = uint8(0x80)
+
+// SubOptionTooLongError is returned when the value of a relay agent
+// information sub-option is longer than 255 bytes, and can't be encoded.
+type SubOptionTooLongError struct {
+	SubOption RelayAgentSubOption
+}
+
+func (e SubOptionTooLongError) Error() string {
+	return fmt.Sprintf("dhcpv4: relay agent sub-option %d is longer than 255 bytes", e.SubOption)
+}
+
+// RelayAgentInformation maps the sub-option tags of the relay agent
+// information option to their values.
+type RelayAgentInformation map[RelayAgentSubOption][]byte
+
+// GetSubOption gets the []byte value of a sub-option.
+func (ri RelayAgentInformation) GetSubOption(o RelayAgentSubOption) ([]byte, bool) {
+	v, ok := ri[o]
+	return v, ok
+}
+
+// SetSubOption sets the []byte value of a sub-option.
+func (ri RelayAgentInformation) SetSubOption(o RelayAgentSubOption, v []byte) {
+	ri[o] = v
+}
+
+// GetCircuitID gets the agent circuit ID.
+func (ri RelayAgentInformation) GetCircuitID() ([]byte, bool) {
+	return ri.GetSubOption(RelayAgentCircuitID)
+}
+
+// SetCircuitID sets the agent circuit ID.
+func (ri RelayAgentInformation) SetCircuitID(v []byte) {
+	ri.SetSubOption(RelayAgentCircuitID, v)
+}
+
+// GetRemoteID gets the agent remote ID.
+func (ri RelayAgentInformation) GetRemoteID() ([]byte, bool) {
+	return ri.GetSubOption(RelayAgentRemoteID)
+}
+
+// SetRemoteID sets the agent remote ID.
+func (ri RelayAgentInformation) SetRemoteID(v []byte) {
+	ri.SetSubOption(RelayAgentRemoteID, v)
+}
+
+// GetLinkSelection gets the address of the subnet the client is on.
+func (ri RelayAgentInformation) GetLinkSelection() (net.IP, bool) {
+	if v, ok := ri.GetSubOption(RelayAgentLinkSelection); ok && len(v) == 4 {
+		return net.IPv4(v[0], v[1], v[2], v[3]), true
+	}
+
+	return nil, false
+}
+
+// SetLinkSelection sets the address of the subnet the client is on.
+func (ri RelayAgentInformation) SetLinkSelection(ip net.IP) {
+	ri.SetSubOption(RelayAgentLinkSelection, []byte(ip.To4()))
+}
+
+// GetSubscriberID gets the subscriber ID.
+func (ri RelayAgentInformation) GetSubscriberID() (string, bool) {
+	if v, ok := ri.GetSubOption(RelayAgentSubscriberID); ok {
+		return string(v), true
+	}
+
+	return "", false
+}
+
+// SetSubscriberID sets the subscriber ID.
+func (ri RelayAgentInformation) SetSubscriberID(v string) {
+	ri.SetSubOption(RelayAgentSubscriberID, []byte(v))
+}
+
+// GetRelayFlags gets the relay agent flags.
+func (ri RelayAgentInformation) GetRelayFlags() (uint8, bool) {
+	if v, ok := ri.GetSubOption(RelayAgentFlags); ok && len(v) == 1 {
+		return v[0], true
+	}
+
+	return 0, false
+}
+
+// SetRelayFlags sets the relay agent flags.
+func (ri RelayAgentInformation) SetRelayFlags(v uint8) {
+	ri.SetSubOption(RelayAgentFlags, []byte{v})
+}
+
+// GetServerIDOverride gets the address the client should use as server
+// identifier.
+func (ri RelayAgentInformation) GetServerIDOverride() (net.IP, bool) {
+	if v, ok := ri.GetSubOption(RelayAgentServerIDOverride); ok && len(v) == 4 {
+		return net.IPv4(v[0], v[1], v[2], v[3]), true
+	}
+
+	return nil, false
+}
+
+// SetServerIDOverride sets the address the client should use as server
+// identifier.
+func (ri RelayAgentInformation) SetServerIDOverride(ip net.IP) {
+	ri.SetSubOption(RelayAgentServerIDOverride, []byte(ip.To4()))
+}
+
+// Deserialize reads sub-options from the []byte into the map.
+func (ri RelayAgentInformation) Deserialize(x []byte) error {
+	for len(x) > 0 {
+		if len(x) < 2 {
+			return ErrShortPacket
+		}
+
+		tag := RelayAgentSubOption(x[0])
+		length := int(x[1])
+		x = x[2:]
+		if len(x) < length {
+			return ErrShortPacket
+		}
+
+		ri[tag] = x[0:length]
+		x = x[length:]
+	}
+
+	return nil
+}
+
+// Serialize writes the contents of the map to a []byte, in numeric order of
+// the sub-option tags. It returns a SubOptionTooLongError for the first
+// sub-option with a value longer than 255 bytes.
+func (ri RelayAgentInformation) Serialize() ([]byte, error) {
+	ks := make([]int, 0, len(ri))
+	for k := range ri {
+		ks = append(ks, int(k))
+	}
+	sort.Ints(ks)
+
+	b := make([]byte, 0)
+	for _, k := range ks {
+		v := ri[RelayAgentSubOption(k)]
+		if len(v) > 255 {
+			return nil, SubOptionTooLongError{RelayAgentSubOption(k)}
+		}
+
+		b = append(b, byte(k), byte(len(v)))
+		b = append(b, v...)
+	}
+
+	return b, nil
+}
+
+// GetRelayAgentInformation gets the sub-options of the relay agent information
+// option. It returns false if the option is not present or malformed.
+func (om OptionMap) GetRelayAgentInformation() (RelayAgentInformation, bool) {
+	v, ok := om.GetOption(OptionRelayAgentInformation)
+	if !ok {
+		return nil, false
+	}
+
+	ri := make(RelayAgentInformation)
+	if err := ri.Deserialize(v); err != nil {
+		return nil, false
+	}
+
+	return ri, true
+}
+
+// SetRelayAgentInformation sets the sub-options of the relay agent information
+// option. It returns the error of Serialize, and leaves the option untouched,
+// if a sub-option can't be encoded.
+func (om OptionMap) SetRelayAgentInformation(ri RelayAgentInformation) error {
+	b, err := ri.Serialize()
+	if err != nil {
+		return err
+	}
+
+	om.SetOption(OptionRelayAgentInformation, b)
+	return nil
+}
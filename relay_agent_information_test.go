@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayAgentInformationSubOptions(t *testing.T) {
+	ri := make(RelayAgentInformation)
+
+	_, ok := ri.GetCircuitID()
+	assert.False(t, ok)
+
+	ri.SetCircuitID([]byte("eth0:10"))
+	ri.SetRemoteID([]byte{0, 1, 2, 3, 4, 5})
+	ri.SetLinkSelection(net.IPv4(192, 168, 1, 0))
+	ri.SetSubscriberID("subscriber")
+	ri.SetRelayFlags(RelayFlagUnicast)
+	ri.SetServerIDOverride(net.IPv4(192, 168, 1, 1))
+
+	circuitID, ok := ri.GetCircuitID()
+	assert.True(t, ok)
+	assert.Equal(t, []byte("eth0:10"), circuitID)
+
+	remoteID, ok := ri.GetRemoteID()
+	assert.True(t, ok)
+	assert.Equal(t, []byte{0, 1, 2, 3, 4, 5}, remoteID)
+
+	link, ok := ri.GetLinkSelection()
+	assert.True(t, ok)
+	assert.Equal(t, net.IPv4(192, 168, 1, 0), link)
+
+	subscriberID, ok := ri.GetSubscriberID()
+	assert.True(t, ok)
+	assert.Equal(t, "subscriber", subscriberID)
+
+	flags, ok := ri.GetRelayFlags()
+	assert.True(t, ok)
+	assert.Equal(t, RelayFlagUnicast, flags)
+
+	serverID, ok := ri.GetServerIDOverride()
+	assert.True(t, ok)
+	assert.Equal(t, net.IPv4(192, 168, 1, 1), serverID)
+}
+
+func TestRelayAgentInformationSerialize(t *testing.T) {
+	ri := make(RelayAgentInformation)
+	ri.SetRemoteID([]byte{0xa})
+	ri.SetCircuitID([]byte{0xb, 0xc})
+
+	b, err := ri.Serialize()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []byte{1, 2, 0xb, 0xc, 2, 1, 0xa}, b)
+
+	riX := make(RelayAgentInformation)
+	if assert.NoError(t, riX.Deserialize(b)) {
+		assert.Equal(t, ri, riX)
+	}
+}
+
+func TestRelayAgentInformationSerializeTooLong(t *testing.T) {
+	ri := make(RelayAgentInformation)
+	ri.SetCircuitID([]byte("eth0"))
+	ri.SetSubscriberID(string(make([]byte, 256)))
+
+	_, err := ri.Serialize()
+	assert.Equal(t, SubOptionTooLongError{RelayAgentSubscriberID}, err)
+
+	om := make(OptionMap)
+	assert.Equal(t, err, om.SetRelayAgentInformation(ri))
+
+	_, ok := om.GetOption(OptionRelayAgentInformation)
+	assert.False(t, ok)
+}
+
+func TestRelayAgentInformationDeserializeShort(t *testing.T) {
+	for _, b := range [][]byte{{1}, {1, 2, 0xb}} {
+		ri := make(RelayAgentInformation)
+		assert.Equal(t, ErrShortPacket, ri.Deserialize(b))
+	}
+}
+
+func TestOptionMapRelayAgentInformation(t *testing.T) {
+	om := make(OptionMap)
+
+	_, ok := om.GetRelayAgentInformation()
+	assert.False(t, ok)
+
+	ri := make(RelayAgentInformation)
+	ri.SetCircuitID([]byte("eth0"))
+	assert.NoError(t, om.SetRelayAgentInformation(ri))
+
+	riX, ok := om.GetRelayAgentInformation()
+	assert.True(t, ok)
+	assert.Equal(t, ri, riX)
+
+	// Malformed
+	om.SetOption(OptionRelayAgentInformation, []byte{1, 5, 0})
+	_, ok = om.GetRelayAgentInformation()
+	assert.False(t, ok)
+}
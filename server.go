@@ -0,0 +1,534 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrServerClosed = errors.New("dhcpv4: server closed")
+
+// DropReason describes why the server dropped a packet without passing it to
+// its handler.
+type DropReason int
+
+const (
+	// DropMalformed is used for packets that could not be parsed.
+	DropMalformed DropReason = iota + 1
+
+	// DropNotRequest is used for packets that are not BOOTREQUEST messages.
+	DropNotRequest
+
+	// DropUnknownMessageType is used for requests with a message type that
+	// is missing or not handled by the server, such as a DHCPOFFER.
+	DropUnknownMessageType
+
+	// DropQueueFull is used for requests for a worker with a full queue.
+	DropQueueFull
+
+	// DropInvalidRequest is used for requests that fail validation, if the
+	// server drops invalid requests.
+	DropInvalidRequest
+)
+
+var dropReasonNames = map[DropReason]string{
+	DropMalformed:          "malformed",
+	DropNotRequest:         "not_request",
+	DropUnknownMessageType: "unknown_message_type",
+	DropQueueFull:          "queue_full",
+	DropInvalidRequest:     "invalid_request",
+}
+
+func (r DropReason) String() string {
+	if s, ok := dropReasonNames[r]; ok {
+		return s
+	}
+
+	return "DropReason(" + strconv.Itoa(int(r)) + ")"
+}
+
+// ValidationMode determines what a Server does with requests that don't
+// conform to RFC2131, table 5.
+type ValidationMode int
+
+const (
+	// SkipValidation passes requests to the handler without validating
+	// them.
+	SkipValidation ValidationMode = iota
+
+	// FlagInvalid passes invalid requests to the handler, which can tell
+	// them apart by their ValidationError.
+	FlagInvalid
+
+	// DropInvalid drops invalid requests.
+	DropInvalid
+)
+
+// validateRequest validates req against the rules for its message type.
+func validateRequest(req Request) error {
+	if v, ok := req.(interface {
+		Validate() error
+	}); ok {
+		return v.Validate()
+	}
+
+	return nil
+}
+
+// Drop describes a packet that the server dropped without passing it to its
+// handler.
+type Drop struct {
+	Reason DropReason
+
+	// The error returned by PacketFromBytes if the reason is DropMalformed,
+	// or the validation error if the reason is DropInvalidRequest.
+	Err error
+
+	// The packet's data, and where it came from.
+	Data           []byte
+	Addr           net.Addr
+	InterfaceIndex int
+}
+
+// drop accounts for a dropped packet, and reports it to the OnDrop function
+// and the error log.
+func (s *Server) drop(d Drop) {
+	s.Metrics.packetDropped(d.Reason)
+
+	if s.OnDrop != nil {
+		s.OnDrop(d)
+	}
+
+	if s.ErrorLog != nil {
+		if d.Err != nil {
+			s.ErrorLog.Printf("dhcpv4: dropped packet from %s on interface %d: %s: %s",
+				d.Addr, d.InterfaceIndex, d.Reason, d.Err)
+		} else {
+			s.ErrorLog.Printf("dhcpv4: dropped packet from %s on interface %d: %s",
+				d.Addr, d.InterfaceIndex, d.Reason)
+		}
+	}
+}
+
+// DefaultQueueSize is the number of requests that can wait for a worker if
+// QueueSize is not set.
+const DefaultQueueSize = 64
+
+// Server serves DHCP requests on one or more PacketConns. Unlike the Serve
+// function, it can be stopped without closing the connections it reads from.
+type Server struct {
+	// Number of requests dropped because of a full queue. Accessed
+	// atomically, and kept first to be 64-bit aligned on 32-bit platforms.
+	dropped uint64
+
+	// Handler is called for every request the server reads.
+	Handler Handler
+
+	// Workers is the number of goroutines that call the handler. Requests
+	// from the same client are always handled by the same worker, in the
+	// order they were read, while requests from different clients are
+	// handled in parallel. If zero, the handler is called from the loop that
+	// reads packets, and must return quickly to not hold up other requests.
+	Workers int
+
+	// QueueSize is the number of requests that can wait for each worker.
+	// Requests for a worker with a full queue are dropped. Defaults to
+	// DefaultQueueSize.
+	QueueSize int
+
+	// Metrics, if set, collects counters about the packets the server reads
+	// and the replies it writes.
+	Metrics *Metrics
+
+	// Validation determines what the server does with requests that don't
+	// conform to RFC2131, table 5. Defaults to SkipValidation.
+	Validation ValidationMode
+
+	// OnDrop, if set, is called for every packet the server drops without
+	// passing it to the handler. It is called from the loop that reads
+	// packets, and must not retain the packet's data after returning.
+	OnDrop func(d Drop)
+
+	// ErrorLog, if set, is used to log dropped packets and temporary read
+	// errors.
+	ErrorLog *log.Logger
+
+	mu       sync.Mutex
+	conns    map[PacketConn]struct{}
+	shutdown bool
+
+	// Number of ServeDHCP and WriteReply calls in progress, and a channel,
+	// created by the first call to Shutdown, that is closed when it drops to
+	// zero.
+	active int
+	idle   chan struct{}
+}
+
+// deadliner is implemented by connections that support read deadlines, such
+// as the ones returned by NewPacketConn and NewRawPacketConn.
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// temporary is implemented by errors that may go away when retried.
+type temporary interface {
+	Temporary() bool
+}
+
+// stopReading unblocks a pending ReadFrom on pc. If pc doesn't support read
+// deadlines, or setting one fails, it is closed instead.
+func stopReading(pc PacketConn) {
+	if d, ok := pc.(deadliner); ok && d.SetReadDeadline(time.Unix(1, 0)) == nil {
+		return
+	}
+
+	pc.Close()
+}
+
+func (s *Server) track(delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active += delta
+	if s.active == 0 && s.idle != nil {
+		select {
+		case <-s.idle:
+		default:
+			close(s.idle)
+		}
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+// serverReplyWriter keeps track of WriteReply calls in progress, so Shutdown
+// can wait for them.
+type serverReplyWriter struct {
+	ReplyWriter
+	s *Server
+}
+
+func (rw *serverReplyWriter) WriteReply(r Reply) error {
+	rw.s.track(1)
+	defer rw.s.track(-1)
+	return rw.ReplyWriter.WriteReply(r)
+}
+
+// Serve reads packets off the network and calls the server's handler. It is
+// equivalent to ServeContext with a context that is never canceled.
+func (s *Server) Serve(pc PacketConn) error {
+	return s.ServeContext(context.Background(), pc)
+}
+
+// ServeContext reads packets off the network and calls the server's handler.
+// It stops reading and returns when ctx is canceled, or when Shutdown is
+// called, in which case it returns ErrServerClosed. Temporary read errors are
+// retried with an exponential backoff; any other read error is returned.
+//
+// To stop reading, a read deadline in the past is set on pc, and cleared again
+// before ServeContext returns. If pc doesn't support read deadlines, it is
+// closed instead, so it can't be used anymore afterwards.
+func (s *Server) ServeContext(ctx context.Context, pc PacketConn) error {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+
+	if s.conns == nil {
+		s.conns = make(map[PacketConn]struct{})
+	}
+
+	s.conns[pc] = struct{}{}
+	s.mu.Unlock()
+
+	// Unblock the read loop when the context is canceled
+	done := make(chan struct{})
+	exited := make(chan struct{})
+
+	go func() {
+		defer close(exited)
+
+		select {
+		case <-ctx.Done():
+			stopReading(pc)
+		case <-done:
+		}
+	}()
+
+	defer func() {
+		close(done)
+		<-exited
+
+		s.mu.Lock()
+		delete(s.conns, pc)
+		s.mu.Unlock()
+
+		// Clear the deadline that may have been set to stop reading, now
+		// that nothing can set it anymore, so pc can be served again.
+		if d, ok := pc.(deadliner); ok {
+			d.SetReadDeadline(time.Time{})
+		}
+	}()
+
+	queues := s.startWorkers()
+	defer func() {
+		for _, q := range queues {
+			close(q)
+		}
+	}()
+
+	buf := make([]byte, 65536)
+	var delay time.Duration
+
+	for {
+		var n, ifindex int
+		var addr net.Addr
+		var dst net.IP
+		var err error
+
+		if dr, ok := pc.(DestinationReader); ok {
+			n, addr, dst, ifindex, err = dr.ReadFromDestination(buf)
+		} else {
+			n, addr, ifindex, err = pc.ReadFrom(buf)
+		}
+
+		if err != nil {
+			// Both are set before the read deadline is set to stop reading,
+			// so the resulting timeout is never retried.
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if t, ok := err.(temporary); ok && t.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else {
+					delay *= 2
+				}
+
+				if delay > time.Second {
+					delay = time.Second
+				}
+
+				if s.ErrorLog != nil {
+					s.ErrorLog.Printf("dhcpv4: read error: %s; retrying in %s", err, delay)
+				}
+
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+				}
+
+				continue
+			}
+
+			return err
+		}
+
+		delay = 0
+
+		p, err := PacketFromBytes(buf[:n])
+		if err != nil {
+			s.Metrics.parseError(err)
+			s.drop(Drop{
+				Reason:         DropMalformed,
+				Err:            err,
+				Data:           buf[:n],
+				Addr:           addr,
+				InterfaceIndex: ifindex,
+			})
+
+			continue
+		}
+
+		// Stash interface index and destination in packet structure
+		p.ifindex = ifindex
+		p.dst = dst
+
+		s.Metrics.packetReceived(p.GetMessageType(), ifindex)
+
+		d := Drop{
+			Data:           p.RawPacket,
+			Addr:           addr,
+			InterfaceIndex: ifindex,
+		}
+
+		if OpCode(p.Op()[0]) != BootRequest {
+			d.Reason = DropNotRequest
+			s.drop(d)
+			continue
+		}
+
+		rw := serverReplyWriter{
+			ReplyWriter: &replyWriter{
+				pw:      pc,
+				addr:    addr,
+				ifindex: ifindex,
+				metrics: s.Metrics,
+			},
+			s: s,
+		}
+
+		req := newRequest(p, &rw)
+		if req == nil {
+			d.Reason = DropUnknownMessageType
+			s.drop(d)
+			continue
+		}
+
+		if s.Validation != SkipValidation {
+			if err := validateRequest(req); err != nil {
+				if s.Validation == DropInvalid {
+					d.Reason = DropInvalidRequest
+					d.Err = err
+					s.drop(d)
+					continue
+				}
+
+				p.invalid = err
+				req = newRequest(p, &rw)
+			}
+		}
+
+		if !s.dispatch(queues, req) {
+			d.Reason = DropQueueFull
+			s.drop(d)
+		}
+	}
+}
+
+// startWorkers starts the server's workers, and returns the queues that feed
+// them. Closing the queues stops the workers after they have handled the
+// requests that are still queued.
+func (s *Server) startWorkers() []chan Request {
+	size := s.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+
+	queues := make([]chan Request, s.Workers)
+	for i := range queues {
+		queues[i] = make(chan Request, size)
+
+		go func(q chan Request) {
+			for req := range q {
+				s.serve(req)
+			}
+		}(queues[i])
+	}
+
+	return queues
+}
+
+// shard returns the index of the queue that requests of the client that sent
+// req go to.
+func shard(req Request, n int) int {
+	id, _ := req.GetOption(OptionClientID)
+
+	h := fnv.New32a()
+	h.Write([]byte(ClientKey(id, req.GetCHAddr())))
+	return int(h.Sum32() % uint32(n))
+}
+
+// dispatch calls the handler for req, or queues it for a worker. It returns
+// false if the request was dropped because the worker's queue is full.
+func (s *Server) dispatch(queues []chan Request, req Request) bool {
+	s.track(1)
+
+	if len(queues) == 0 {
+		s.serve(req)
+		return true
+	}
+
+	select {
+	case queues[shard(req, len(queues))] <- req:
+		return true
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		s.track(-1)
+		return false
+	}
+}
+
+// serve calls the handler for a request that was dispatched.
+func (s *Server) serve(req Request) {
+	defer s.track(-1)
+
+	if s.Metrics != nil {
+		start := time.Now()
+		defer func() {
+			s.Metrics.handled(time.Since(start))
+		}()
+	}
+
+	s.Handler.ServeDHCP(req)
+}
+
+// Dropped returns the number of requests that were dropped because the queue
+// of their worker was full.
+func (s *Server) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Shutdown stops the server from reading packets, and waits for calls to the
+// handler and to WriteReply that are in progress, as well as requests that are
+// queued for a worker, to complete. If ctx expires before that, Shutdown
+// returns the context's error. Replies written while shutting down are still
+// sent. Connections that don't support read deadlines are closed to stop
+// reading from them.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+
+	for pc := range s.conns {
+		stopReading(pc)
+	}
+
+	// Concurrent calls wait for the same channel
+	if s.idle == nil {
+		s.idle = make(chan struct{})
+		if s.active == 0 {
+			close(s.idle)
+		}
+	}
+
+	idle := s.idle
+	s.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
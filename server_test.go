@@ -0,0 +1,592 @@
+/*
+Copyright (c) 2014 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dhcpv4
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestUDPConn(t *testing.T) (PacketConn, net.Addr) {
+	c, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	pc, err := NewPacketConn(c)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return pc, c.LocalAddr()
+}
+
+func sendTestRequest(t *testing.T, addr net.Addr) {
+	p := CreateDHCPDiscover([]byte{0, 1, 2, 3, 4, 5})
+	b, err := p.ToBytes()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	c, err := net.Dial("udp4", addr.String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer c.Close()
+
+	c.Write(b)
+}
+
+// blockingHandler signals when ServeDHCP is called, and blocks until it is
+// released.
+type blockingHandler struct {
+	called  chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHandler) ServeDHCP(req Request) {
+	close(h.called)
+	<-h.release
+}
+
+func TestServerShutdown(t *testing.T) {
+	pc, _ := newTestUDPConn(t)
+	defer pc.Close()
+
+	s := Server{Handler: &testHandler{}}
+
+	errc := make(chan error)
+	go func() {
+		errc <- s.Serve(pc)
+	}()
+
+	// Give Serve a chance to start reading
+	time.Sleep(10 * time.Millisecond)
+
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, ErrServerClosed, <-errc)
+
+	// The connection is left open
+	_, err := pc.WriteTo([]byte("xyz"), pc.LocalAddr(), 0)
+	assert.NoError(t, err)
+
+	// The server can't be used after shutdown
+	assert.Equal(t, ErrServerClosed, s.Serve(pc))
+}
+
+func TestServerShutdownWaitsForHandler(t *testing.T) {
+	pc, addr := newTestUDPConn(t)
+	defer pc.Close()
+
+	h := &blockingHandler{
+		called:  make(chan struct{}),
+		release: make(chan struct{}),
+	}
+
+	s := Server{Handler: h}
+
+	errc := make(chan error)
+	go func() {
+		errc <- s.Serve(pc)
+	}()
+
+	sendTestRequest(t, addr)
+	<-h.called
+
+	// The handler is still running when the deadline expires
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+
+	close(h.release)
+	assert.Equal(t, ErrServerClosed, <-errc)
+	assert.NoError(t, s.Shutdown(context.Background()))
+}
+
+func TestServerConcurrentShutdown(t *testing.T) {
+	pc, addr := newTestUDPConn(t)
+	defer pc.Close()
+
+	h := &blockingHandler{
+		called:  make(chan struct{}),
+		release: make(chan struct{}),
+	}
+
+	s := Server{Handler: h}
+
+	errc := make(chan error)
+	go func() {
+		errc <- s.Serve(pc)
+	}()
+
+	sendTestRequest(t, addr)
+	<-h.called
+
+	// Both calls return once the handler does
+	shutdownc := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			shutdownc <- s.Shutdown(ctx)
+		}()
+	}
+
+	// Give both calls a chance to start waiting
+	time.Sleep(10 * time.Millisecond)
+	close(h.release)
+
+	assert.NoError(t, <-shutdownc)
+	assert.NoError(t, <-shutdownc)
+	assert.Equal(t, ErrServerClosed, <-errc)
+}
+
+func TestServerServeContextCanceled(t *testing.T) {
+	pc, _ := newTestUDPConn(t)
+	defer pc.Close()
+
+	s := Server{Handler: &testHandler{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error)
+	go func() {
+		errc <- s.ServeContext(ctx, pc)
+	}()
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-errc)
+}
+
+func TestServerServeAfterCancel(t *testing.T) {
+	pc, addr := newTestUDPConn(t)
+	defer pc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := Server{Handler: &testHandler{}}
+	assert.Equal(t, context.Canceled, s.ServeContext(ctx, pc))
+
+	// The connection can be served again
+	h := &blockingHandler{
+		called:  make(chan struct{}),
+		release: make(chan struct{}),
+	}
+
+	s = Server{Handler: h}
+
+	errc := make(chan error)
+	go func() {
+		errc <- s.Serve(pc)
+	}()
+
+	sendTestRequest(t, addr)
+
+	select {
+	case <-h.called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for request")
+	}
+
+	close(h.release)
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, ErrServerClosed, <-errc)
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary" }
+func (temporaryError) Temporary() bool { return true }
+
+func TestServerRetriesTemporaryErrors(t *testing.T) {
+	pc := &testPacketConn{}
+	pc.On("ReadFrom", mock.Anything).Return(nil, nil, -1, temporaryError{}).Times(3)
+	pc.ReadError(io.EOF)
+
+	s := Server{Handler: &testHandler{}}
+	assert.Equal(t, io.EOF, s.Serve(pc))
+	pc.AssertNumberOfCalls(t, "ReadFrom", 4)
+}
+
+func TestServerReturnsReadError(t *testing.T) {
+	readError := errors.New("some read error")
+
+	pc := &testPacketConn{}
+	pc.On("ReadFrom", mock.Anything).Return(nil, nil, -1, readError)
+
+	s := Server{Handler: &testHandler{}}
+	assert.Equal(t, readError, s.Serve(pc))
+}
+
+// chanConn is a PacketConn that reads packets from a channel. ReadFrom returns
+// io.EOF when the channel is closed.
+type chanConn struct {
+	testPacketConn
+	c chan []byte
+}
+
+func (c *chanConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	p, ok := <-c.c
+	if !ok {
+		return 0, nil, -1, io.EOF
+	}
+
+	return copy(b, p), &net.UDPAddr{IP: net.IPv4zero, Port: 68}, 1, nil
+}
+
+func testRequestBytes(mac byte, secs uint16) []byte {
+	p := CreateDHCPDiscover([]byte{0, 0, 0, 0, 0, mac})
+	p.Secs()[1] = byte(secs)
+
+	b, err := p.ToBytes()
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// recordingHandler records the order in which requests of each client are
+// handled. It signals called, and blocks on requests of the client in block
+// until unblock is closed.
+type recordingHandler struct {
+	mu    sync.Mutex
+	order map[byte][]byte
+
+	called  chan struct{}
+	block   byte
+	unblock chan struct{}
+}
+
+func (h *recordingHandler) ServeDHCP(req Request) {
+	mac := req.GetCHAddr()[5]
+	if mac == h.block {
+		h.called <- struct{}{}
+		<-h.unblock
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.order[mac] = append(h.order[mac], req.(DHCPDiscover).Secs()[1])
+}
+
+func (h *recordingHandler) handled(mac byte) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.order[mac])
+}
+
+func TestServerWorkersOrdering(t *testing.T) {
+	const workers = 4
+
+	// Find two clients that are handled by different workers
+	a, b := byte(1), byte(2)
+	for shard(CreateDHCPDiscover([]byte{0, 0, 0, 0, 0, a}), workers) ==
+		shard(CreateDHCPDiscover([]byte{0, 0, 0, 0, 0, b}), workers) {
+		b++
+	}
+
+	h := &recordingHandler{
+		order:   make(map[byte][]byte),
+		called:  make(chan struct{}, 10),
+		block:   a,
+		unblock: make(chan struct{}),
+	}
+
+	pc := &chanConn{c: make(chan []byte)}
+	s := Server{Handler: h, Workers: workers}
+
+	errc := make(chan error)
+	go func() {
+		errc <- s.Serve(pc)
+	}()
+
+	for i := uint16(0); i < 10; i++ {
+		pc.c <- testRequestBytes(a, i)
+		pc.c <- testRequestBytes(b, i)
+	}
+
+	close(pc.c)
+	assert.Equal(t, io.EOF, <-errc)
+
+	// Client b is handled while client a is blocked
+	for h.handled(b) < 10 {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Equal(t, 0, h.handled(a))
+	close(h.unblock)
+
+	assert.NoError(t, s.Shutdown(context.Background()))
+
+	expected := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	assert.Equal(t, expected, h.order[a])
+	assert.Equal(t, expected, h.order[b])
+	assert.Equal(t, uint64(0), s.Dropped())
+}
+
+func TestServerWorkersQueueFull(t *testing.T) {
+	h := &recordingHandler{
+		order:   make(map[byte][]byte),
+		called:  make(chan struct{}, 3),
+		block:   1,
+		unblock: make(chan struct{}),
+	}
+
+	pc := &chanConn{c: make(chan []byte)}
+	s := Server{Handler: h, Workers: 1, QueueSize: 1}
+
+	errc := make(chan error)
+	go func() {
+		errc <- s.Serve(pc)
+	}()
+
+	// The first request keeps the worker busy, the second is queued, and the
+	// third is dropped.
+	pc.c <- testRequestBytes(1, 0)
+	<-h.called
+	pc.c <- testRequestBytes(1, 1)
+	pc.c <- testRequestBytes(1, 2)
+
+	close(pc.c)
+	assert.Equal(t, io.EOF, <-errc)
+	assert.Equal(t, uint64(1), s.Dropped())
+
+	close(h.unblock)
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, []byte{0, 1}, h.order[1])
+}
+
+func TestServerOnDrop(t *testing.T) {
+	chaddr := []byte{0, 1, 2, 3, 4, 5}
+
+	unknown := NewRequest(chaddr)
+	unknown.SetMessageType(MessageTypeDHCPOffer)
+
+	var packets [][]byte
+	for _, p := range []Packet{unknown, CreateDHCPOffer(CreateDHCPDiscover(chaddr)).Packet} {
+		b, err := PacketToBytes(p, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		packets = append(packets, b)
+	}
+
+	packets = append(packets, []byte{1, 2, 3})
+
+	src := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 68}
+
+	pc := &testPacketConn{}
+	for _, b := range packets {
+		pc.On("ReadFrom", mock.Anything).Return(b, src, 3, nil).Once()
+	}
+
+	pc.ReadError(io.EOF)
+
+	var drops []Drop
+	var buf bytes.Buffer
+
+	s := Server{
+		Handler: &testHandler{},
+		OnDrop: func(d Drop) {
+			d.Data = append([]byte(nil), d.Data...)
+			drops = append(drops, d)
+		},
+		ErrorLog: log.New(&buf, "", 0),
+	}
+
+	assert.Equal(t, io.EOF, s.Serve(pc))
+
+	reasons := []DropReason{DropUnknownMessageType, DropNotRequest, DropMalformed}
+	if assert.Len(t, drops, len(reasons)) {
+		for i, d := range drops {
+			assert.Equal(t, reasons[i], d.Reason)
+			assert.Equal(t, packets[i], d.Data)
+			assert.Equal(t, src, d.Addr)
+			assert.Equal(t, 3, d.InterfaceIndex)
+		}
+
+		assert.Nil(t, drops[0].Err)
+		assert.Equal(t, ErrShortPacket, drops[2].Err)
+	}
+
+	expected := "" +
+		"dhcpv4: dropped packet from 10.0.0.1:68 on interface 3: unknown_message_type\n" +
+		"dhcpv4: dropped packet from 10.0.0.1:68 on interface 3: not_request\n" +
+		"dhcpv4: dropped packet from 10.0.0.1:68 on interface 3: malformed: dhcpv4: short packet\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestServerOnDropQueueFull(t *testing.T) {
+	h := &recordingHandler{
+		order:   make(map[byte][]byte),
+		called:  make(chan struct{}, 2),
+		block:   1,
+		unblock: make(chan struct{}),
+	}
+
+	var drops []Drop
+
+	pc := &chanConn{c: make(chan []byte)}
+	s := Server{
+		Handler:   h,
+		Workers:   1,
+		QueueSize: 1,
+		OnDrop: func(d Drop) {
+			drops = append(drops, d)
+		},
+	}
+
+	errc := make(chan error)
+	go func() {
+		errc <- s.Serve(pc)
+	}()
+
+	pc.c <- testRequestBytes(1, 0)
+	<-h.called
+	pc.c <- testRequestBytes(1, 1)
+	pc.c <- testRequestBytes(1, 2)
+
+	close(pc.c)
+	assert.Equal(t, io.EOF, <-errc)
+
+	if assert.Len(t, drops, 1) {
+		assert.Equal(t, DropQueueFull, drops[0].Reason)
+		assert.Equal(t, byte(2), drops[0].Data[9])
+	}
+
+	close(h.unblock)
+	assert.NoError(t, s.Shutdown(context.Background()))
+}
+
+func TestDropReasonString(t *testing.T) {
+	assert.Equal(t, "queue_full", DropQueueFull.String())
+	assert.Equal(t, "DropReason(0)", DropReason(0).String())
+}
+
+func TestServerValidation(t *testing.T) {
+	chaddr := []byte{0, 1, 2, 3, 4, 5}
+
+	valid := CreateDHCPDiscover(chaddr)
+	invalid := CreateDHCPDecline(chaddr, net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1))
+	delete(invalid.OptionMap, OptionDHCPServerID)
+
+	var packets [][]byte
+	for _, p := range []Packet{valid.Packet, invalid.Packet} {
+		b, err := PacketToBytes(p, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		packets = append(packets, b)
+	}
+
+	testCases := []struct {
+		mode    ValidationMode
+		handled []bool
+		drops   int
+	}{
+		{SkipValidation, []bool{true, true}, 0},
+		{FlagInvalid, []bool{true, false}, 0},
+		{DropInvalid, []bool{true}, 1},
+	}
+
+	for _, tc := range testCases {
+		pc := &testPacketConn{}
+		for _, b := range packets {
+			pc.ReadSuccess(b)
+		}
+
+		pc.ReadError(io.EOF)
+
+		var handled []bool
+		var drops []Drop
+
+		s := Server{
+			Handler: HandlerFunc(func(req Request) {
+				handled = append(handled, req.ValidationError() == nil)
+			}),
+			Validation: tc.mode,
+			OnDrop: func(d Drop) {
+				drops = append(drops, d)
+			},
+		}
+
+		assert.Equal(t, io.EOF, s.Serve(pc))
+		assert.Equal(t, tc.handled, handled)
+
+		if assert.Len(t, drops, tc.drops) && tc.drops > 0 {
+			assert.Equal(t, DropInvalidRequest, drops[0].Reason)
+			assert.Error(t, drops[0].Err)
+		}
+	}
+}
+
+// destinationConn reports that every packet read from PacketConn was sent to
+// dst.
+type destinationConn struct {
+	PacketConn
+	dst net.IP
+}
+
+func (c *destinationConn) ReadFromDestination(b []byte) (int, net.Addr, net.IP, int, error) {
+	n, addr, ifindex, err := c.ReadFrom(b)
+	return n, addr, c.dst, ifindex, err
+}
+
+func TestServerRecordsDestination(t *testing.T) {
+	req := CreateDHCPRequestRebinding([]byte{0, 1, 2, 3, 4, 5}, net.IPv4(10, 0, 0, 1))
+	b, err := req.ToBytes()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testCases := []struct {
+		dst   net.IP
+		state RequestState
+	}{
+		{net.IPv4bcast, RequestStateRebinding},
+		{net.IPv4(10, 0, 0, 254), RequestStateRenewing},
+	}
+
+	for _, tc := range testCases {
+		pc := &testPacketConn{}
+		pc.ReadSuccess(b)
+		pc.ReadError(io.EOF)
+
+		var state RequestState
+		s := Server{
+			Handler: HandlerFunc(func(req Request) {
+				state, _ = req.(DHCPRequest).State()
+			}),
+		}
+
+		assert.Equal(t, io.EOF, s.Serve(&destinationConn{pc, tc.dst}))
+		assert.Equal(t, tc.state, state)
+	}
+}
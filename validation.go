@@ -15,23 +15,119 @@ limitations under the License.
 */
 package dhcpv4
 
-import "fmt"
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
 
 type Validation interface {
 	Validate(p Packet) error
 }
 
+// Violation describes a way in which a packet doesn't conform to a
+// Validation.
+type Violation struct {
+	// Option is the option the violation is about. It is zero if the
+	// violation is about a field in the fixed header, or about the packet as
+	// a whole.
+	Option Option
+
+	// Field is the name of the field in the fixed header the violation is
+	// about, such as "yiaddr".
+	Field string
+
+	Message string
+}
+
+// Names of the options in RFC2131, table 3, and of options with values that
+// are checked by optionValueValidation.
+var optionNames = map[Option]string{
+	OptionSubnetMask:       "subnet mask",
+	OptionTimeOffset:       "time offset",
+	OptionRouter:           "router",
+	OptionDomainServer:     "domain name server",
+	OptionHostname:         "host name",
+	OptionDomainName:       "domain name",
+	OptionMTUInterface:     "interface MTU",
+	OptionBroadcastAddress: "broadcast address",
+	OptionNTPServers:       "NTP servers",
+	OptionVendorSpecific:   "vendor specific information",
+	OptionAddressRequest:   "requested IP address",
+	OptionAddressTime:      "IP address lease time",
+	OptionOverload:         "option overload",
+	OptionDHCPMsgType:      "DHCP message type",
+	OptionDHCPServerID:     "server identifier",
+	OptionParameterList:    "parameter request list",
+	OptionDHCPMessage:      "message",
+	OptionDHCPMaxMsgSize:   "maximum DHCP message size",
+	OptionRenewalTime:      "renewal (T1) time value",
+	OptionRebindingTime:    "rebinding (T2) time value",
+	OptionClassID:          "vendor class identifier",
+	OptionClientID:         "client identifier",
+}
+
+func (v Violation) String() string {
+	switch {
+	case v.Field != "":
+		return fmt.Sprintf("'%s' %s", v.Field, v.Message)
+	case v.Option != 0:
+		if name, ok := optionNames[v.Option]; ok {
+			return fmt.Sprintf("option %d (%s) %s", v.Option, name, v.Message)
+		}
+
+		return fmt.Sprintf("option %d %s", v.Option, v.Message)
+	}
+
+	return v.Message
+}
+
+// ValidationError is returned when a packet fails validation. It lists every
+// violation, rather than just the first one.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	s := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		s[i] = v.String()
+	}
+
+	return "dhcpv4: " + strings.Join(s, "; ")
+}
+
+// violations returns an error for vs, or nil if vs is empty.
+func violations(vs ...Violation) error {
+	if len(vs) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Violations: vs}
+}
+
+// Validate runs every validation in vs against p. If any of them fails, it
+// returns a *ValidationError with the violations of all of them. Errors
+// other than a *ValidationError are included as a violation of the packet as
+// a whole.
 func Validate(p Packet, vs []Validation) error {
-	var err error
+	var all []Violation
 
 	for _, v := range vs {
-		err = v.Validate(p)
-		if err != nil {
-			return err
+		err := v.Validate(p)
+		if err == nil {
+			continue
+		}
+
+		if verr, ok := err.(*ValidationError); ok {
+			all = append(all, verr.Violations...)
+		} else {
+			all = append(all, Violation{Message: err.Error()})
 		}
 	}
 
-	return nil
+	return violations(all...)
 }
 
 type validateMust struct {
@@ -40,22 +136,20 @@ type validateMust struct {
 }
 
 func (v validateMust) Validate(p Packet) error {
-	var err error
-
 	_, ok := p.GetOption(v.o)
 	if v.have {
 		// MUST HAVE
 		if !ok {
-			err = fmt.Errorf("dhcpv4: packet MUST have field %d", v.o)
+			return violations(Violation{Option: v.o, Message: "MUST be present"})
 		}
 	} else {
 		// MUST NOT HAVE
 		if ok {
-			err = fmt.Errorf("dhcpv4: packet MUST NOT have field %d", v.o)
+			return violations(Violation{Option: v.o, Message: "MUST NOT be present"})
 		}
 	}
 
-	return err
+	return nil
 }
 
 func ValidateMustNot(o Option) Validation {
@@ -71,17 +165,23 @@ type validateAllowedOptions struct {
 }
 
 func (v validateAllowedOptions) Validate(p Packet) error {
-	var err error
+	var os []Option
 
 	for k := range p.OptionMap {
 		// If an option is not allowed, the packet MUST NOT have it.
 		if !v.allowed[k] {
-			err = fmt.Errorf("dhcpv4: packet MUST NOT have field %d", k)
-			break
+			os = append(os, k)
 		}
 	}
 
-	return err
+	sort.Slice(os, func(i, j int) bool { return os[i] < os[j] })
+
+	vs := make([]Violation, len(os))
+	for i, o := range os {
+		vs[i] = Violation{Option: o, Message: "MUST NOT be present"}
+	}
+
+	return violations(vs...)
 }
 
 func ValidateAllowedOptions(os []Option) Validation {
@@ -92,3 +192,194 @@ func ValidateAllowedOptions(os []Option) Validation {
 
 	return validateAllowedOptions{allowed}
 }
+
+type validateLength struct {
+	o        Option
+	min, max int
+	multiple int
+}
+
+func (v validateLength) Validate(p Packet) error {
+	b, ok := p.GetOption(v.o)
+	if !ok {
+		return nil
+	}
+
+	var msg string
+
+	switch n := len(b); {
+	case v.min == v.max && n != v.min:
+		msg = fmt.Sprintf("has length %d, expected %d", n, v.min)
+	case n < v.min:
+		msg = fmt.Sprintf("has length %d, expected at least %d", n, v.min)
+	case v.max > 0 && n > v.max:
+		msg = fmt.Sprintf("has length %d, expected at most %d", n, v.max)
+	case v.multiple > 0 && n%v.multiple != 0:
+		msg = fmt.Sprintf("has length %d, expected a multiple of %d", n, v.multiple)
+	default:
+		return nil
+	}
+
+	return violations(Violation{Option: v.o, Message: msg})
+}
+
+// ValidateLength returns a Validation that checks that the value of option o,
+// if present, is between min and max bytes long. A max of zero means there is
+// no upper bound.
+func ValidateLength(o Option, min, max int) Validation {
+	return validateLength{o: o, min: min, max: max}
+}
+
+// ValueType is the type of the value of an option, as defined in RFC2132.
+type ValueType int
+
+const (
+	ValueIP     ValueType = iota // A single IP address
+	ValueIPList                  // One or more IP addresses
+	ValueUint8
+	ValueUint16
+	ValueUint32
+	ValueBool // A single byte that is either 0 or 1
+	ValueString
+)
+
+type validateBool struct {
+	o Option
+}
+
+func (v validateBool) Validate(p Packet) error {
+	b, ok := p.GetOption(v.o)
+	if !ok || len(b) != 1 || b[0] <= 1 {
+		return nil
+	}
+
+	return violations(Violation{
+		Option:  v.o,
+		Message: fmt.Sprintf("has value %d, expected 0 or 1", b[0]),
+	})
+}
+
+// validations runs a list of validations as a single Validation.
+type validations []Validation
+
+func (vs validations) Validate(p Packet) error {
+	return Validate(p, vs)
+}
+
+// ValidateType returns a Validation that checks that the value of option o,
+// if present, is of type t.
+func ValidateType(o Option, t ValueType) Validation {
+	switch t {
+	case ValueIP, ValueUint32:
+		return validateLength{o: o, min: 4, max: 4}
+	case ValueIPList:
+		return validateLength{o: o, min: 4, multiple: 4}
+	case ValueUint8:
+		return validateLength{o: o, min: 1, max: 1}
+	case ValueUint16:
+		return validateLength{o: o, min: 2, max: 2}
+	case ValueBool:
+		return validations{
+			validateLength{o: o, min: 1, max: 1},
+			validateBool{o},
+		}
+	case ValueString:
+		return validateLength{o: o, min: 1}
+	}
+
+	panic("dhcpv4: unknown value type")
+}
+
+// AddrField is one of the address fields in the fixed header of a packet.
+type AddrField int
+
+const (
+	FieldCIAddr AddrField = iota
+	FieldYIAddr
+	FieldSIAddr
+	FieldGIAddr
+)
+
+var addrFieldNames = map[AddrField]string{
+	FieldCIAddr: "ciaddr",
+	FieldYIAddr: "yiaddr",
+	FieldSIAddr: "siaddr",
+	FieldGIAddr: "giaddr",
+}
+
+func (f AddrField) String() string {
+	return addrFieldNames[f]
+}
+
+func (f AddrField) get(p Packet) net.IP {
+	switch f {
+	case FieldCIAddr:
+		return p.GetCIAddr()
+	case FieldYIAddr:
+		return p.GetYIAddr()
+	case FieldSIAddr:
+		return p.GetSIAddr()
+	case FieldGIAddr:
+		return p.GetGIAddr()
+	}
+
+	panic("dhcpv4: unknown address field")
+}
+
+type validateZero struct {
+	f    AddrField
+	zero bool
+}
+
+func (v validateZero) Validate(p Packet) error {
+	zero := v.f.get(p).Equal(net.IPv4zero)
+	if v.zero {
+		if !zero {
+			return violations(Violation{Field: v.f.String(), Message: "MUST be zero"})
+		}
+	} else {
+		if zero {
+			return violations(Violation{Field: v.f.String(), Message: "MUST NOT be zero"})
+		}
+	}
+
+	return nil
+}
+
+// ValidateZero returns a Validation that checks that address field f in the
+// fixed header is zero.
+func ValidateZero(f AddrField) Validation {
+	return validateZero{f, true}
+}
+
+// ValidateNonZero returns a Validation that checks that address field f in
+// the fixed header is not zero.
+func ValidateNonZero(f AddrField) Validation {
+	return validateZero{f, false}
+}
+
+// From RFC2132, the types of the values of options with a fixed format.
+// These are checked for every message, if the options are present.
+var optionValueValidation = validations{
+	ValidateType(OptionSubnetMask, ValueIP),
+	ValidateType(OptionTimeOffset, ValueUint32),
+	ValidateType(OptionRouter, ValueIPList),
+	ValidateType(OptionDomainServer, ValueIPList),
+	ValidateType(OptionHostname, ValueString),
+	ValidateType(OptionDomainName, ValueString),
+	ValidateType(OptionMTUInterface, ValueUint16),
+	ValidateType(OptionBroadcastAddress, ValueIP),
+	ValidateType(OptionNTPServers, ValueIPList),
+	ValidateType(OptionAddressRequest, ValueIP),
+	ValidateType(OptionAddressTime, ValueUint32),
+	ValidateType(OptionOverload, ValueUint8),
+	ValidateType(OptionDHCPMsgType, ValueUint8),
+	ValidateType(OptionDHCPServerID, ValueIP),
+	ValidateType(OptionParameterList, ValueString),
+	ValidateType(OptionDHCPMessage, ValueString),
+	ValidateType(OptionDHCPMaxMsgSize, ValueUint16),
+	ValidateType(OptionRenewalTime, ValueUint32),
+	ValidateType(OptionRebindingTime, ValueUint32),
+	ValidateType(OptionClassID, ValueString),
+	ValidateLength(OptionClientID, 2, 0),
+}
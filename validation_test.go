@@ -16,6 +16,8 @@ limitations under the License.
 package dhcpv4
 
 import (
+	"errors"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -72,3 +74,130 @@ func TestValidateAllowedOptions(t *testing.T) {
 	err = Validate(p, []Validation{v})
 	assert.Error(t, err)
 }
+
+func TestValidateAggregatesViolations(t *testing.T) {
+	p := NewPacket(BootReply)
+	p.SetOption(OptionAddressTime, []byte{0, 0, 1})
+
+	err := Validate(p, []Validation{
+		ValidateMust(OptionDHCPServerID),
+		ValidateMustNot(OptionAddressTime),
+		ValidateType(OptionAddressTime, ValueUint32),
+		ValidateNonZero(FieldYIAddr),
+	})
+
+	verr, ok := err.(*ValidationError)
+	if !assert.True(t, ok) {
+		return
+	}
+
+	assert.Equal(t, []Violation{
+		{Option: OptionDHCPServerID, Message: "MUST be present"},
+		{Option: OptionAddressTime, Message: "MUST NOT be present"},
+		{Option: OptionAddressTime, Message: "has length 3, expected 4"},
+		{Field: "yiaddr", Message: "MUST NOT be zero"},
+	}, verr.Violations)
+
+	assert.Equal(t, "dhcpv4: option 54 (server identifier) MUST be present; "+
+		"option 51 (IP address lease time) MUST NOT be present; "+
+		"option 51 (IP address lease time) has length 3, expected 4; "+
+		"'yiaddr' MUST NOT be zero", err.Error())
+}
+
+type testValidation struct {
+	err error
+}
+
+func (v testValidation) Validate(p Packet) error {
+	return v.err
+}
+
+func TestValidateOtherErrors(t *testing.T) {
+	err := Validate(NewPacket(BootReply), []Validation{
+		testValidation{errors.New("dhcpv4: something")},
+		ValidateMust(Option(200)),
+	})
+
+	assert.Equal(t, "dhcpv4: dhcpv4: something; option 200 MUST be present", err.Error())
+}
+
+func TestValidateAllowedOptionsListsAll(t *testing.T) {
+	p := NewPacket(BootReply)
+	p.SetOption(OptionRouter, []byte{10, 0, 0, 1})
+	p.SetOption(OptionSubnetMask, []byte{255, 0, 0, 0})
+
+	err := Validate(p, []Validation{ValidateAllowedOptions(nil)})
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, []Violation{
+			{Option: OptionSubnetMask, Message: "MUST NOT be present"},
+			{Option: OptionRouter, Message: "MUST NOT be present"},
+		}, err.(*ValidationError).Violations)
+	}
+}
+
+func TestValidateLength(t *testing.T) {
+	testCases := []struct {
+		v     Validation
+		value []byte
+		msg   string
+	}{
+		{ValidateLength(OptionClientID, 2, 0), []byte{1}, "has length 1, expected at least 2"},
+		{ValidateLength(OptionClientID, 2, 0), []byte{1, 2, 3}, ""},
+		{ValidateLength(OptionClientID, 2, 3), []byte{1, 2, 3, 4}, "has length 4, expected at most 3"},
+		{ValidateType(OptionSubnetMask, ValueIP), []byte{255, 255, 0}, "has length 3, expected 4"},
+		{ValidateType(OptionRouter, ValueIPList), []byte{10, 0, 0, 1, 10}, "has length 5, expected a multiple of 4"},
+		{ValidateType(OptionRouter, ValueIPList), []byte{10, 0, 0, 1, 10, 0, 0, 2}, ""},
+		{ValidateType(OptionOverload, ValueUint8), []byte{1, 2}, "has length 2, expected 1"},
+		{ValidateType(OptionDHCPMaxMsgSize, ValueUint16), []byte{2, 64}, ""},
+		{ValidateType(OptionHostname, ValueString), []byte{}, "has length 0, expected at least 1"},
+		{ValidateType(OptionTrailers, ValueBool), []byte{2}, "has value 2, expected 0 or 1"},
+		{ValidateType(OptionTrailers, ValueBool), []byte{1}, ""},
+	}
+
+	for _, tc := range testCases {
+		p := NewPacket(BootReply)
+
+		// Absent options are not checked
+		assert.NoError(t, Validate(p, []Validation{tc.v}))
+
+		p.SetOption(OptionClientID, tc.value)
+		p.SetOption(OptionSubnetMask, tc.value)
+		p.SetOption(OptionRouter, tc.value)
+		p.SetOption(OptionOverload, tc.value)
+		p.SetOption(OptionDHCPMaxMsgSize, tc.value)
+		p.SetOption(OptionHostname, tc.value)
+		p.SetOption(OptionTrailers, tc.value)
+
+		err := Validate(p, []Validation{tc.v})
+		if tc.msg == "" {
+			assert.NoError(t, err)
+			continue
+		}
+
+		if assert.IsType(t, &ValidationError{}, err) {
+			assert.Equal(t, tc.msg, err.(*ValidationError).Violations[0].Message)
+		}
+	}
+}
+
+func TestValidateZero(t *testing.T) {
+	testCases := []struct {
+		f   AddrField
+		set func(p Packet, ip net.IP)
+	}{
+		{FieldCIAddr, Packet.SetCIAddr},
+		{FieldYIAddr, Packet.SetYIAddr},
+		{FieldSIAddr, Packet.SetSIAddr},
+		{FieldGIAddr, Packet.SetGIAddr},
+	}
+
+	for _, tc := range testCases {
+		p := NewPacket(BootReply)
+		assert.NoError(t, Validate(p, []Validation{ValidateZero(tc.f)}))
+		assert.Error(t, Validate(p, []Validation{ValidateNonZero(tc.f)}))
+
+		tc.set(p, net.IPv4(10, 0, 0, 1))
+		assert.Error(t, Validate(p, []Validation{ValidateZero(tc.f)}))
+		assert.NoError(t, Validate(p, []Validation{ValidateNonZero(tc.f)}))
+	}
+}